@@ -5,10 +5,22 @@ import (
 	"os"
 )
 
+// Succeeded is the exit code when a command completes successfully.
+const Succeeded int = 0
+
 const (
 	InvalidArgument int = iota + 1
 	Interrupted
 	RuntimeError
+
+	// UpstreamFailed indicates that a job that we depend upon failed.
+	UpstreamFailed
+
+	// TimedOut indicates that we gave up waiting.
+	TimedOut
+
+	// NotFound indicates that a job that we depend upon was never created.
+	NotFound
 )
 
 // ExitOnRuntimeError checks for an error, then quits, returning a non-zero exit code.
@@ -1,11 +1,27 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
 	"github.com/carolynvs/handbrk8s/internal/dashboard"
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 )
 
 func main() {
-	log.Fatal(dashboard.Serve())
+	sharedVolume := parseArgs()
+	log.Fatal(dashboard.Serve(sharedVolume))
+}
+
+// parseArgs reads and validates flags and environment variables.
+func parseArgs() (sharedVolume string) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+
+	fs.StringVar(&sharedVolume, "shared-volume", "",
+		"Shared volume containing /watch, /work and /claim directories, required to retry failed videos")
+	api.DefaultClientConfig.AddFlags(fs)
+	fs.Parse(os.Args[1:])
+
+	return sharedVolume
 }
@@ -1,55 +1,187 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/carolynvs/handbrk8s/cmd"
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-// jobchain -name JOBNAME [-namespace NAMESPACE]
-// Exit with 0 only when the job completes successfully
+// Outcomes reported by jobchain, in addition to the job outcomes.
+const (
+	outcomeTimedOut    = "TimedOut"
+	outcomeInterrupted = "Interrupted"
+	outcomeError       = "Error"
+)
+
+// summary is printed as the last line of output, in json, so that the init
+// container logs are machine-readable.
+type summary struct {
+	Namespace string       `json:"namespace"`
+	Names     []string     `json:"names,omitempty"`
+	Selector  string       `json:"selector,omitempty"`
+	Mode      string       `json:"mode"`
+	Outcome   string       `json:"outcome"`
+	Reason    string       `json:"reason,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	ExitCode  int          `json:"exitCode"`
+	Elapsed   string       `json:"elapsed"`
+	Jobs      []jobSummary `json:"jobs,omitempty"`
+}
+
+// jobSummary is the outcome of a single job that finished.
+type jobSummary struct {
+	Name    string `json:"name"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// jobchain [-name JOBNAME...] [-selector SELECTOR] [-mode all|any] [-namespace NAMESPACE] [-timeout DURATION]
+// Exit with 0 only when the jobs complete successfully, otherwise the exit
+// code identifies the outcome, see the exit codes defined in cmd.
 func main() {
-	name, namespace := parseFlags()
+	set, mode, namespace, timeout, opts := parseFlags()
+	start := time.Now()
+
+	var finished []jobs.Result
+	exit := func(outcome string, exitCode int, reason, message string) {
+		s := summary{
+			Namespace: namespace,
+			Names:     set.Names,
+			Mode:      string(mode),
+			Outcome:   outcome,
+			Reason:    reason,
+			Message:   message,
+			ExitCode:  exitCode,
+			Elapsed:   time.Since(start).Round(time.Second).String(),
+		}
+		if set.Selector != nil {
+			s.Selector = set.Selector.String()
+		}
+		for _, r := range finished {
+			s.Jobs = append(s.Jobs, jobSummary{Name: r.Name, Outcome: string(r.Outcome), Reason: r.Reason})
+		}
+		line, _ := json.Marshal(s)
+		fmt.Println(string(line))
+		os.Exit(exitCode)
+	}
+
+	jobClient, err := jobs.NewDefaultClient()
+	if err != nil {
+		exit(outcomeError, cmd.RuntimeError, "", err.Error())
+	}
 
 	done := make(chan struct{})
-	jobChan, errChan := jobs.WaitUntilComplete(done, namespace, name)
+	resultChan, errChan := jobClient.WaitForJobs(done, namespace, set, mode, opts)
 
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
+	// The kubelet stops containers with SIGTERM
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timedOut = time.After(timeout)
+	}
 
 	for {
 		select {
 		case <-signals:
 			fmt.Println("Stopping...")
-			os.Exit(cmd.Interrupted)
-		case job, ok := <-jobChan:
+			exit(outcomeInterrupted, cmd.Interrupted, "", "")
+		case <-timedOut:
+			fmt.Printf("Giving up after waiting %s...\n", timeout)
+			exit(outcomeTimedOut, cmd.TimedOut, "", fmt.Sprintf("the jobs did not finish within %s", timeout))
+		case result, ok := <-resultChan:
 			if !ok {
 				fmt.Println("Giving up...")
-				os.Exit(cmd.RuntimeError)
+				exit(outcomeError, cmd.RuntimeError, "", "stopped waiting before the jobs finished")
+			}
+			finished = result.Results
+			switch result.Outcome {
+			case jobs.Succeeded:
+				for _, r := range result.Results {
+					if r.Succeeded() {
+						fmt.Printf("%s completed sucessfully at %s\n", r.Name, r.Job.Status.CompletionTime)
+					}
+				}
+				exit(string(result.Outcome), cmd.Succeeded, "", "")
+			case jobs.NotFound:
+				fmt.Println(result.Error())
+				exit(string(result.Outcome), cmd.NotFound, result.Reason, result.Message)
+			default:
+				fmt.Println(result.Error())
+				exit(string(result.Outcome), cmd.UpstreamFailed, result.Reason, result.Message)
 			}
-			fmt.Printf("Job completed sucessfully at %s\n", job.Status.CompletionTime)
-			return
 		case err, ok := <-errChan:
-			if ok {
+			message := "stopped waiting before the jobs finished"
+			if ok && err != nil {
 				fmt.Printf("%#v", err)
+				message = err.Error()
 			}
 			fmt.Println("Giving up...")
-			os.Exit(cmd.RuntimeError)
+			exit(outcomeError, cmd.RuntimeError, "", message)
 		}
 	}
 }
 
-func parseFlags() (name, namespace string) {
+func parseFlags() (set jobs.JobSet, mode jobs.WaitMode, namespace string, timeout time.Duration, opts jobs.WaitOptions) {
+	var names stringSlice
+	var selector, modeFlag string
 	fs := flag.NewFlagSet("jobchain", flag.ExitOnError)
-	fs.StringVar(&name, "name", "", "job to wait for")
-	fs.StringVar(&namespace, "namespace", "", "namespace of the job")
+	fs.Var(&names, "name", "job to wait for, may be repeated")
+	fs.StringVar(&selector, "selector", "", "label selector of the jobs to wait for, for example video=foo")
+	fs.StringVar(&modeFlag, "mode", string(jobs.WaitForAll),
+		"all waits for every job to succeed, any waits for the first job to succeed")
+	fs.StringVar(&namespace, "namespace", "", "namespace of the jobs")
+	fs.DurationVar(&timeout, "timeout", 0, "how long to wait for the job to finish, defaults to waiting forever")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", time.Minute,
+		"how often to read the jobs from the API server, in addition to watching them for changes")
+	fs.DurationVar(&opts.RequireCreatedWithin, "require-created-within", 0,
+		"how long to wait for the jobs to be created, defaults to waiting forever")
+	api.DefaultClientConfig.AddFlags(fs)
 	fs.Parse(os.Args[1:])
 
-	cmd.ExitOnMissingFlag(name, "-name")
+	set.Names = names
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		exitOnInvalidArgument(errors.Wrapf(err, "invalid -selector %q", selector))
+		set.Selector = parsed
+	}
+	exitOnInvalidArgument(errors.Wrap(set.Validate(), "-name or -selector is required"))
+
+	mode = jobs.WaitMode(modeFlag)
+	if mode != jobs.WaitForAll && mode != jobs.WaitForAny {
+		exitOnInvalidArgument(errors.Errorf("invalid -mode %q, must be %s or %s", modeFlag, jobs.WaitForAll, jobs.WaitForAny))
+	}
+
+	return set, mode, namespace, timeout, opts
+}
+
+func exitOnInvalidArgument(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(cmd.InvalidArgument)
+	}
+}
+
+// stringSlice is a flag that may be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
 
-	return name, namespace
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/carolynvs/handbrk8s/cmd"
 	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
 	"github.com/carolynvs/handbrk8s/internal/plex"
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -34,7 +35,7 @@ func main() {
 			shouldUpload = true
 		} else {
 			err := errors.Wrapf(destErr, "cannot stat %s", uploadPath)
-			cmd.ExitOnRuntimeError(err)
+			exitOnRuntimeError(err)
 		}
 	}
 
@@ -42,13 +43,12 @@ func main() {
 	if srcErr != nil {
 		if os.IsNotExist(srcErr) {
 			if shouldUpload {
-				fmt.Println(errors.Wrapf(srcErr, "cannot stat the transcoded video file '%s'", transcodedPath))
-				os.Exit(cmd.RuntimeError)
+				exitOnRuntimeError(errors.Wrapf(srcErr, "cannot stat the transcoded video file '%s'", transcodedPath))
 			}
 			fmt.Println("the transcoded video file is gone and was found on the Plex share. Skipping upload.")
 		} else {
 			err := errors.Wrapf(destErr, "cannot stat %s", uploadPath)
-			cmd.ExitOnRuntimeError(err)
+			exitOnRuntimeError(err)
 		}
 	} else if !shouldUpload {
 		destSize := uint64(destStat.Size())
@@ -65,12 +65,12 @@ func main() {
 		shouldRefresh = true
 		fmt.Println("uploading the video to Plex...")
 		err := fs.CopyFile(transcodedPath, uploadPath)
-		cmd.ExitOnRuntimeError(err)
+		exitOnRuntimeError(err)
 	}
 
 	plexC := plex.NewClient(libCfg.ServerConfig)
 	lib, err := plexC.FindLibrary(libCfg.Name)
-	cmd.ExitOnRuntimeError(err)
+	exitOnRuntimeError(err)
 
 	// Determine if the Plex library should be refreshed
 	dirName := parentDir(pathSuffix)
@@ -78,14 +78,15 @@ func main() {
 	if !shouldRefresh {
 		fmt.Println("checking for the video in the Plex library...")
 		exists, err := lib.HasVideo(dirName, filename)
-		cmd.ExitOnRuntimeError(err)
+		exitOnRuntimeError(err)
 		shouldRefresh = !exists
 	}
 
 	if shouldRefresh {
 		fmt.Println("updating the Plex library index...")
+		refreshStart := time.Now()
 		err := lib.Update()
-		cmd.ExitOnRuntimeError(err)
+		exitOnRuntimeError(err)
 
 		fmt.Println("checking that the video in now in the Plex library...")
 		exists := false
@@ -101,8 +102,9 @@ func main() {
 		}
 		if !exists {
 			err = errors.New("plex was updated but the video is still not in the library")
-			cmd.ExitOnRuntimeError(err)
+			exitOnRuntimeError(err)
 		}
+		metrics.PlexRefreshLatency.Observe(time.Since(refreshStart).Seconds())
 	} else {
 		fmt.Println("the video is already in the Plex library. Skipping update.")
 	}
@@ -112,12 +114,12 @@ func main() {
 	if err != nil {
 		if !os.IsNotExist(err) {
 			err = errors.Wrapf(err, "cannot stat %s", transcodedPath)
-			cmd.ExitOnRuntimeError(err)
+			exitOnRuntimeError(err)
 		}
 	} else {
 		fmt.Printf("removing %s\n", transcodedPath)
 		err = os.Remove(transcodedPath)
-		cmd.ExitOnRuntimeError(err)
+		exitOnRuntimeError(err)
 	}
 
 	// Determine if the original raw file should be removed
@@ -125,13 +127,37 @@ func main() {
 	if err != nil {
 		if !os.IsNotExist(err) {
 			err = errors.Wrapf(err, "cannot stat %s", transcodedPath)
-			cmd.ExitOnRuntimeError(err)
+			exitOnRuntimeError(err)
 		}
 	} else {
 		fmt.Printf("removing %s\n", rawPath)
 		err = os.Remove(rawPath)
-		cmd.ExitOnRuntimeError(err)
+		exitOnRuntimeError(err)
 	}
+
+	writeMetrics()
+}
+
+// metricsFile is where the metrics are saved before exiting.
+var metricsFile string
+
+// writeMetrics saves the metrics, since the uploader exits before it can be scraped.
+func writeMetrics() {
+	if metricsFile == "" {
+		return
+	}
+	err := metrics.WriteFile(metricsFile)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// exitOnRuntimeError saves the metrics before exiting on an error.
+func exitOnRuntimeError(err error) {
+	if err != nil {
+		writeMetrics()
+	}
+	cmd.ExitOnRuntimeError(err)
 }
 
 // parseArgs reads and validates flags and environment variables.
@@ -147,6 +173,7 @@ func parseArgs() (plexCfg plex.LibraryConfig, transcodedPath, destinationSuffix,
 	fs.StringVar(&plexCfg.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
 	fs.StringVar(&plexCfg.Name, "plex-library", "", "Name of a Plex library")
 	fs.StringVar(&plexCfg.Share, "plex-share", "", "Location of the Plex share")
+	fs.StringVar(&metricsFile, "metrics-file", "", "Save metrics in the Prometheus text format to this file before exiting")
 
 	fs.Parse(os.Args[1:])
 
@@ -2,55 +2,186 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/carolynvs/handbrk8s/cmd"
-	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
 	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/pkg/errors"
 )
 
-const configVolume = "/config"
-const plexVolume = "/plex"
-const videoPreset = "tivo"
+// defaultConfigFile is used when the -config flag is not specified.
+const defaultConfigFile = "/config/watcher/watcher.yaml"
 
 func main() {
-	sharedVolume, plexCfg := parseArgs()
-	watchVolume := sharedVolume
-	workVolume := sharedVolume
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(cmd.InvalidArgument)
+	}
 
-	w, err := watcher.NewVideoWatcher(configVolume, watchVolume, workVolume, videoPreset, plexCfg)
+	w, err := watcher.NewVideoWatcher(cfg)
 	if err != nil {
 		cmd.ExitOnRuntimeError(err)
 	}
 	defer w.Close()
 
-	// Only stop watching when our process is killed
+	go func() {
+		log.Println(metrics.Serve(cfg.MetricsAddress))
+	}()
+
+	// Reload the configuration on SIGHUP, and only stop watching when our process is killed
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-	for range signals {
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			reload(w, cfg)
+			continue
+		}
+
 		// Do any cleanup before being shut down
 		log.Println("done watching for videos!")
 		return
 	}
 }
 
-// parseArgs reads and validates flags and environment variables.
-func parseArgs() (sharedVolume string, plexCfg plex.LibraryConfig) {
-	fs := flag.NewFlagSet("watcher", flag.ExitOnError)
+// reload rereads the configuration, keeping the current configuration when
+// the new one is invalid.
+func reload(w *watcher.VideoWatcher, current watcher.Config) {
+	log.Println("reloading the watcher configuration")
+	cfg, err := loadConfig(os.Args[1:])
+	if err == nil {
+		err = w.Reload(cfg)
+	}
+	if err != nil {
+		log.Println(errors.Wrap(err, "unable to reload the watcher configuration, continuing with the previous configuration"))
+		return
+	}
 
-	fs.StringVar(&sharedVolume, "shared-volume", "/", "Shared volume containing /watch, /work and /claim directories")
-	fs.StringVar(&plexCfg.URL, "plex-server", "",
+	if cfg.MetricsAddress != current.MetricsAddress {
+		log.Printf("ignoring the change to the metrics address, restart the watcher to serve metrics on %s\n", cfg.MetricsAddress)
+	}
+}
+
+// loadConfig reads the config file, then applies any environment variables
+// and flags, which take precedence over the file.
+func loadConfig(args []string) (watcher.Config, error) {
+	// Find the config file first, so that its values can be overridden
+	cfg := watcher.DefaultConfig()
+	configFile, err := parseArgs(args, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	if _, statErr := os.Stat(configFile); statErr == nil {
+		cfg, err = watcher.LoadConfig(configFile)
+		if err != nil {
+			return cfg, err
+		}
+	} else if configFile != defaultConfigFile {
+		return cfg, errors.Wrapf(statErr, "unable to load the watcher configuration")
+	}
+
+	_, err = parseArgs(args, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// parseArgs reads flags and environment variables into the configuration,
+// using the current values as the defaults. Each flag can be set with an
+// environment variable, shown in the flag usage, e.g. -plex-token and PLEX_TOKEN.
+func parseArgs(args []string, cfg *watcher.Config) (configFile string, err error) {
+	fs := flag.NewFlagSet("watcher", flag.ContinueOnError)
+
+	fs.StringVar(&configFile, "config", defaultConfigFile, "Path to the watcher configuration file")
+	fs.Var(sharedVolumeFlag{cfg}, "shared-volume",
+		"Shared volume containing /watch, /work and /claim directories, sets both -watch-volume and -work-volume")
+	fs.StringVar(&cfg.ConfigVolume, "config-volume", cfg.ConfigVolume, "Volume containing the job templates and presets")
+	fs.StringVar(&cfg.WatchVolume, "watch-volume", cfg.WatchVolume, "Volume containing the watch and fail directories")
+	fs.StringVar(&cfg.WorkVolume, "work-volume", cfg.WorkVolume, "Volume containing the claim and work directories")
+	fs.DurationVar(&cfg.StableThreshold.Duration, "stable-threshold", cfg.StableThreshold.Duration,
+		"How long a video must not change before it is processed")
+	fs.BoolVar(&cfg.Probe.Enabled, "probe", cfg.Probe.Enabled,
+		"Probe each video with ffprobe before it is transcoded, applying the rules in the preset configuration")
+	fs.IntVar(&cfg.Segments.Count, "segments", cfg.Segments.Count,
+		"Split each video at its keyframes into this many segments, which are transcoded in parallel, requires -probe")
+	fs.IntVar(&cfg.Queue.Concurrency, "concurrency", cfg.Queue.Concurrency,
+		"How many videos are transcoded at the same time, the rest wait in the queue by priority, 0 for no limit")
+	fs.StringVar(&cfg.Executor, "executor", cfg.Executor,
+		"Runs the transcode and upload steps: kubernetes creates jobs, and local runs them as subprocesses without a cluster")
+	fs.IntVar(&cfg.Local.Concurrency, "local-concurrency", cfg.Local.Concurrency, "How many steps the local executor runs at the same time")
+	fs.Var(&cfg.ReplacePolicy, "replace-policy",
+		"What to do when a job with the same name exists: replace-if-finished, fail-if-exists or always-replace")
+	fs.StringVar(&cfg.MetricsAddress, "metrics-address", cfg.MetricsAddress, "Address on which to serve Prometheus metrics at /metrics")
+	fs.StringVar(&cfg.DefaultPreset, "default-preset", cfg.DefaultPreset, "HandBrake preset used for every video when the preset config is missing")
+	fs.StringVar(&cfg.PresetConfig, "preset-config", cfg.PresetConfig,
+		"Maps libraries and path patterns to HandBrake presets, defaults to presets/presets.yaml in the config volume")
+	fs.StringVar(&cfg.HandBrakePresets, "handbrake-presets", cfg.HandBrakePresets,
+		"HandBrake presets used by the transcode jobs, defaults to ghb/presets.json in the config volume")
+	fs.StringVar(&cfg.Plex.Server, "plex-server", cfg.Plex.Server,
 		"Base URL of the Plex server, for example http://192.168.0.105:32400")
-	fs.StringVar(&plexCfg.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
-	fs.StringVar(&plexCfg.Share, "plex-share", "", "Location of the Plex share")
-	fs.Parse(os.Args[1:])
+	fs.StringVar(&cfg.Plex.Token, "plex-token", cfg.Plex.Token, "Plex authentication token")
+	fs.StringVar(&cfg.Plex.Share, "plex-share", cfg.Plex.Share, "Location of the Plex share in the upload jobs")
+	api.DefaultClientConfig.AddFlags(fs)
+
+	// Environment variables override the config file, and flags override both
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := envVar(f.Name)
+		if env == "" {
+			return
+		}
+		f.Usage = fmt.Sprintf("%s [%s]", f.Usage, env)
+		if value, ok := os.LookupEnv(env); ok && envErr == nil {
+			envErr = errors.Wrapf(fs.Set(f.Name, value), "invalid value for %s", env)
+		}
+	})
+	if envErr != nil {
+		return configFile, envErr
+	}
+
+	err = fs.Parse(args)
+	return configFile, err
+}
+
+// envVar is the environment variable for a flag. The Plex flags keep the
+// names used by the uploader, e.g. PLEX_TOKEN, and the rest are prefixed
+// with WATCHER_, e.g. WATCHER_SHARED_VOLUME. The kubeconfig flags are
+// skipped, because the client already reads KUBECONFIG.
+func envVar(flagName string) string {
+	if flagName == "kubeconfig" || flagName == "context" {
+		return ""
+	}
+	env := strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+	if strings.HasPrefix(flagName, "plex-") {
+		return env
+	}
+	return "WATCHER_" + env
+}
 
-	cmd.ExitOnMissingFlag(plexCfg.URL, "-plex-server")
-	cmd.ExitOnMissingFlag(plexCfg.Token, "-plex-token")
+// sharedVolumeFlag uses a single volume as both the watch and work volumes.
+type sharedVolumeFlag struct {
+	cfg *watcher.Config
+}
 
-	plexCfg.Share = plexVolume
+func (f sharedVolumeFlag) String() string {
+	if f.cfg == nil || f.cfg.WatchVolume != f.cfg.WorkVolume {
+		return ""
+	}
+	return f.cfg.WatchVolume
+}
 
-	return sharedVolume, plexCfg
+func (f sharedVolumeFlag) Set(value string) error {
+	f.cfg.WatchVolume = value
+	f.cfg.WorkVolume = value
+	return nil
 }
@@ -6,8 +6,10 @@ require (
 	github.com/dustin/go-humanize v0.0.0-20170828050815-79e699ccd02f
 	github.com/imdario/mergo v0.3.6 // indirect
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
 	github.com/radovskyb/watcher v1.0.7
 	k8s.io/api v0.19.3
 	k8s.io/apimachinery v0.19.5-rc.0
 	k8s.io/client-go v0.19.3
+	sigs.k8s.io/yaml v1.2.0
 )
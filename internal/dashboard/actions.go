@@ -0,0 +1,184 @@
+package dashboard
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Actions that can be performed on a video's pipeline.
+const (
+	CancelAction = "cancel"
+	DeleteAction = "delete"
+	RetryAction  = "retry"
+)
+
+// statusError is an error that should be returned to the client with a specific status code.
+type statusError struct {
+	Code int
+	error
+}
+
+// serveVideoAction handles POST /api/v1/videos/{name}/{action}
+func (s *Server) serveVideoAction(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/videos/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found: %s", req.URL.Path))
+		return
+	}
+	video, action := parts[0], parts[1]
+
+	var do func(Pipeline) error
+	switch action {
+	case CancelAction:
+		do = s.cancel
+	case DeleteAction:
+		do = s.deleteFinished
+	case RetryAction:
+		do = s.retry
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action: %s", action))
+		return
+	}
+
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	p, err := s.findPipeline(video)
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+
+	log.Printf("%s %s\n", action, video)
+	err = do(p)
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, struct {
+		Video  string `json:"video"`
+		Action string `json:"action"`
+	}{Video: video, Action: action})
+}
+
+// findPipeline looks up a video's pipeline from the job cache.
+func (s *Server) findPipeline(video string) (Pipeline, error) {
+	data, err := s.buildData()
+	if err != nil {
+		return Pipeline{}, err
+	}
+
+	for _, p := range data.Pipelines {
+		if p.Video == video {
+			return p, nil
+		}
+	}
+	return Pipeline{}, statusError{http.StatusNotFound, errors.Errorf("video not found: %s", video)}
+}
+
+// cancel a pipeline that is in progress, moving the claimed video into the
+// failed directory so that it can be retried later.
+func (s *Server) cancel(p Pipeline) error {
+	if p.Stage == StageDone || p.Stage == StageFailed {
+		return statusError{http.StatusConflict, errors.Errorf("cannot cancel %s, it is already %s", p.Video, p.Stage)}
+	}
+
+	err := s.deleteJobs(p)
+	if err != nil {
+		return err
+	}
+
+	if s.Directories == nil {
+		return nil
+	}
+	pathSuffix, err := videoPath(p)
+	if err != nil {
+		return err
+	}
+	claimPath := filepath.Join(s.Directories.ClaimDir, pathSuffix)
+	if _, err := os.Stat(claimPath); os.IsNotExist(err) {
+		return nil
+	}
+	return s.Directories.Fail(pathSuffix)
+}
+
+// deleteFinished removes the jobs of a pipeline that is done or failed, once
+// every job has finished.
+func (s *Server) deleteFinished(p Pipeline) error {
+	if p.Stage != StageDone && p.Stage != StageFailed {
+		return statusError{http.StatusConflict, errors.Errorf("cannot delete %s while it is %s, cancel it instead", p.Video, p.Stage)}
+	}
+	for _, j := range p.Jobs {
+		if !j.Finished() {
+			return statusError{http.StatusConflict, errors.Errorf("cannot delete %s until %s has finished", p.Video, j.Name)}
+		}
+	}
+	return s.deleteJobs(p)
+}
+
+// retry a failed pipeline by removing its jobs, and moving the video back
+// into the watch directory. The watcher then processes it like a new video.
+// A pipeline has only failed once a job has the JobFailed condition, a job
+// with failed pods may still be retried by Kubernetes.
+func (s *Server) retry(p Pipeline) error {
+	if p.Stage != StageFailed {
+		return statusError{http.StatusConflict, errors.Errorf("cannot retry %s, it has not failed", p.Video)}
+	}
+	if s.Directories == nil {
+		return statusError{http.StatusNotImplemented, errors.New("retry is disabled, the dashboard was started without a shared volume")}
+	}
+
+	pathSuffix, err := videoPath(p)
+	if err != nil {
+		return err
+	}
+
+	err = s.deleteJobs(p)
+	if err != nil {
+		return err
+	}
+	return s.Directories.Requeue(pathSuffix)
+}
+
+func (s *Server) deleteJobs(p Pipeline) error {
+	for _, j := range p.Jobs {
+		err := s.deleteJob(j.Name, j.Namespace)
+		if apierrors.IsForbidden(err) {
+			return statusError{http.StatusForbidden, errors.Wrapf(err,
+				"the dashboard's service account is not allowed to delete jobs in the %s namespace, bind it to the job-creator role in manifests/rbac.yaml", j.Namespace)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// videoPath finds the path of the video, relative to the watch directory,
+// from its job annotations.
+func videoPath(p Pipeline) (string, error) {
+	for _, j := range p.Jobs {
+		if path := j.Annotations[watcher.VideoPathAnnotation]; path != "" {
+			return path, nil
+		}
+	}
+	return "", statusError{http.StatusUnprocessableEntity, errors.Errorf("unable to determine the path of %s, its jobs are missing the %s annotation", p.Video, watcher.VideoPathAnnotation)}
+}
+
+func writeActionError(w http.ResponseWriter, err error) {
+	if serr, ok := err.(statusError); ok {
+		writeError(w, serr.Code, serr.error)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
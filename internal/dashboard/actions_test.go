@@ -0,0 +1,154 @@
+package dashboard
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var failedStatus = batchv1.JobStatus{
+	Failed:     20,
+	Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+}
+
+func buildAnnotatedJob(video, jobType, path string, status batchv1.JobStatus) *batchv1.Job {
+	j := buildTestJob(video, jobType, status)
+	j.Annotations = map[string]string{watcher.VideoPathAnnotation: path}
+	return j
+}
+
+// startActionServer starts a dashboard that records deleted jobs, and has
+// a shared volume in a temporary directory.
+func startActionServer(t *testing.T, jobs ...runtime.Object) (s *Server, deleted *[]string, cleanup func()) {
+	s, done := startTestServerWithJobs(t, jobs...)
+
+	tmpDir, err := ioutil.TempDir("", "dashboard")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	dirs := watcher.NewDirectories(tmpDir, tmpDir)
+	s.Directories = &dirs
+
+	deleted = &[]string{}
+	s.deleteJob = func(name, namespace string) error {
+		*deleted = append(*deleted, name)
+		return nil
+	}
+
+	return s, deleted, func() {
+		close(done)
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func postAction(t *testing.T, s *Server, video, action string, wantCode int) string {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/videos/"+video+"/"+action, nil))
+	if w.Code != wantCode {
+		t.Fatalf("expected %s %s to return %d, got %d: %s", action, video, wantCode, w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func writeVideo(t *testing.T, path string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(path, []byte("video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func assertExists(t *testing.T, path string) {
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+}
+
+func TestServer_CancelAction(t *testing.T) {
+	s, deleted, cleanup := startActionServer(t,
+		buildAnnotatedJob("foo", "transcode", "Movies/foo.mkv", batchv1.JobStatus{Active: 1}),
+		buildAnnotatedJob("foo", "upload", "Movies/foo.mkv", batchv1.JobStatus{Active: 1}),
+		buildAnnotatedJob("bar", "upload", "Movies/bar.mkv", batchv1.JobStatus{Succeeded: 1}),
+	)
+	defer cleanup()
+	writeVideo(t, filepath.Join(s.Directories.ClaimDir, "Movies/foo.mkv"))
+
+	postAction(t, s, "foo", CancelAction, 202)
+	if len(*deleted) != 2 {
+		t.Fatalf("expected the transcode and upload jobs to be deleted, got %v", *deleted)
+	}
+	assertExists(t, filepath.Join(s.Directories.FailedDir, "Movies/foo.mkv"))
+
+	postAction(t, s, "bar", CancelAction, 409)
+	postAction(t, s, "missing", CancelAction, 404)
+}
+
+func TestServer_DeleteAction(t *testing.T) {
+	s, deleted, cleanup := startActionServer(t,
+		buildAnnotatedJob("foo", "transcode", "Movies/foo.mkv", batchv1.JobStatus{Active: 1}),
+		buildAnnotatedJob("bar", "transcode", "Movies/bar.mkv", batchv1.JobStatus{Succeeded: 1}),
+		buildAnnotatedJob("bar", "upload", "Movies/bar.mkv", batchv1.JobStatus{Succeeded: 1}),
+		buildAnnotatedJob("baz", "transcode", "Movies/baz.mkv", failedStatus),
+		buildAnnotatedJob("baz", "segment", "Movies/baz.mkv", batchv1.JobStatus{Active: 1}),
+	)
+	defer cleanup()
+
+	postAction(t, s, "foo", DeleteAction, 409)
+	postAction(t, s, "baz", DeleteAction, 409)
+	postAction(t, s, "bar", DeleteAction, 202)
+	if len(*deleted) != 2 || (*deleted)[0] != "bar-transcode" || (*deleted)[1] != "bar-upload" {
+		t.Fatalf("expected only the finished jobs to be deleted, got %v", *deleted)
+	}
+}
+
+func TestServer_RetryAction(t *testing.T) {
+	s, deleted, cleanup := startActionServer(t,
+		buildAnnotatedJob("foo", "transcode", "TV/Foo/foo.mkv", failedStatus),
+		buildAnnotatedJob("foo", "upload", "TV/Foo/foo.mkv", batchv1.JobStatus{Active: 1}),
+		buildAnnotatedJob("bar", "transcode", "Movies/bar.mkv", batchv1.JobStatus{Active: 1}),
+		// Kubernetes is waiting to retry a failed pod
+		buildAnnotatedJob("baz", "transcode", "Movies/baz.mkv", batchv1.JobStatus{Failed: 2}),
+	)
+	defer cleanup()
+	writeVideo(t, filepath.Join(s.Directories.ClaimDir, "TV/Foo/foo.mkv"))
+
+	postAction(t, s, "bar", RetryAction, 409)
+	postAction(t, s, "baz", RetryAction, 409)
+
+	postAction(t, s, "foo", RetryAction, 202)
+	if len(*deleted) != 2 {
+		t.Fatalf("expected the failed pipeline's jobs to be deleted, got %v", *deleted)
+	}
+	assertExists(t, filepath.Join(s.Directories.WatchDir, "TV/Foo/foo.mkv"))
+
+	s.Directories = nil
+	postAction(t, s, "foo", RetryAction, 501)
+}
+
+func TestServer_ActionForbidden(t *testing.T) {
+	s, _, cleanup := startActionServer(t,
+		buildAnnotatedJob("foo", "transcode", "Movies/foo.mkv", failedStatus),
+	)
+	defer cleanup()
+	s.deleteJob = func(name, namespace string) error {
+		return apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, name, nil)
+	}
+
+	body := postAction(t, s, "foo", DeleteAction, 403)
+	if !strings.Contains(body, "job-creator") {
+		t.Fatalf("expected the error to explain how to grant permission to delete jobs, got %s", body)
+	}
+}
@@ -0,0 +1,218 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// JobSummary is the api representation of a DisplayJob.
+type JobSummary struct {
+	Name           string     `json:"name"`
+	Status         string     `json:"status"`
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	CompletionTime *time.Time `json:"completionTime,omitempty"`
+	Duration       string     `json:"duration"`
+	Video          string     `json:"video"`
+	JobType        string     `json:"jobType"`
+}
+
+// VideoSummary is the api representation of the pipeline processing a video.
+type VideoSummary struct {
+	Name    string         `json:"name"`
+	Stage   Stage          `json:"stage"`
+	Elapsed string         `json:"elapsed"`
+	Stages  []StageSummary `json:"stages"`
+	Jobs    []JobSummary   `json:"jobs"`
+
+	// Progress of the transcode, only available while transcoding.
+	Progress *handbrake.Progress `json:"progress,omitempty"`
+
+	// ETA is the estimated time remaining for the transcode.
+	ETA string `json:"eta,omitempty"`
+}
+
+// StageSummary is the api representation of a StageDuration.
+type StageSummary struct {
+	Stage    Stage  `json:"stage"`
+	Duration string `json:"duration"`
+}
+
+// Summarize converts a job into its api representation.
+func (j DisplayJob) Summarize() JobSummary {
+	s := JobSummary{
+		Name:     j.Name,
+		Status:   j.StatusDescription(),
+		Duration: j.Duration(),
+		Video:    j.Video(),
+		JobType:  j.JobType(),
+	}
+	if j.Status.StartTime != nil {
+		start := j.Status.StartTime.Time
+		s.StartTime = &start
+	}
+	if j.Status.CompletionTime != nil {
+		completed := j.Status.CompletionTime.Time
+		s.CompletionTime = &completed
+	}
+	return s
+}
+
+// Summarize converts a pipeline into its api representation, including
+// only the jobs that match the filter.
+func (p Pipeline) Summarize(filter jobFilter) VideoSummary {
+	v := VideoSummary{
+		Name:    p.Video,
+		Stage:   p.Stage,
+		Elapsed: p.Elapsed.String(),
+		Stages:  make([]StageSummary, len(p.Stages)),
+		Jobs:    []JobSummary{},
+	}
+	if p.Progress != nil {
+		v.Progress = p.Progress
+		if p.Progress.ETA > 0 {
+			v.ETA = p.Progress.ETA.String()
+		}
+	}
+	for i, s := range p.Stages {
+		v.Stages[i] = StageSummary{Stage: s.Stage, Duration: s.Duration.String()}
+	}
+	for _, j := range p.Jobs {
+		if filter.Matches(j) {
+			v.Jobs = append(v.Jobs, j.Summarize())
+		}
+	}
+	return v
+}
+
+// jobFilter selects jobs using the status and job-type query parameters.
+type jobFilter struct {
+	Status, JobType string
+}
+
+func parseJobFilter(req *http.Request) jobFilter {
+	q := req.URL.Query()
+	return jobFilter{
+		Status:  q.Get("status"),
+		JobType: q.Get("job-type"),
+	}
+}
+
+func (f jobFilter) Matches(j DisplayJob) bool {
+	if f.Status != "" && !strings.EqualFold(f.Status, j.StatusDescription()) {
+		return false
+	}
+	if f.JobType != "" && f.JobType != j.JobType() {
+		return false
+	}
+	return true
+}
+
+// serveJobs handles GET /api/v1/jobs
+func (s *Server) serveJobs(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	data, err := s.buildData()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	filter := parseJobFilter(req)
+	result := []JobSummary{}
+	for _, j := range data.Jobs {
+		if filter.Matches(j) {
+			result = append(result, j.Summarize())
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// serveJob handles GET /api/v1/jobs/{name}
+func (s *Server) serveJob(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/api/v1/jobs/")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid job name: %q", name))
+		return
+	}
+
+	job, err := s.lister.Jobs(watcher.Namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job not found: %s", name))
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DisplayJob(*job).Summarize())
+}
+
+// serveVideos handles GET /api/v1/videos
+// Videos are included when any of their jobs match the status and job-type
+// filters, and when their pipeline is in the requested stage.
+func (s *Server) serveVideos(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	data, err := s.buildData()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	filter := parseJobFilter(req)
+	stage := Stage(req.URL.Query().Get("stage"))
+	result := []VideoSummary{}
+	for _, p := range data.Pipelines {
+		if stage != "" && stage != p.Stage {
+			continue
+		}
+
+		v := p.Summarize(filter)
+		if len(v.Jobs) > 0 {
+			result = append(result, v)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", req.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= 500 {
+		fmt.Println(err)
+	}
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
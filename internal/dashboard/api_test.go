@@ -0,0 +1,148 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func buildTestJob(video, jobType string, status batchv1.JobStatus) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      video + "-" + jobType,
+			Namespace: watcher.Namespace,
+			Labels: map[string]string{
+				watcher.VideoLabel:   video,
+				watcher.JobTypeLabel: jobType,
+			},
+		},
+		Status: status,
+	}
+}
+
+// startTestServerWithJobs starts a dashboard with a cache pre-populated with jobs.
+func startTestServerWithJobs(t *testing.T, jobs ...runtime.Object) (*Server, chan struct{}) {
+	s, err := NewServer(fake.NewSimpleClientset(jobs...))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	done := make(chan struct{})
+	err = s.Start(done)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return s, done
+}
+
+func getJSON(t *testing.T, s *Server, url string, wantCode int, result interface{}) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != wantCode {
+		t.Fatalf("expected %s to return %d, got %d: %s", url, wantCode, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a json content type, got %q", ct)
+	}
+
+	err := json.NewDecoder(w.Body).Decode(result)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestServer_ApiJobs(t *testing.T) {
+	start := metav1.Now()
+	s, done := startTestServerWithJobs(t,
+		buildTestJob("foo", "transcode", batchv1.JobStatus{Succeeded: 1, StartTime: &start, CompletionTime: &start}),
+		buildTestJob("foo", "upload", batchv1.JobStatus{Active: 1, StartTime: &start}),
+		buildTestJob("bar", "transcode", batchv1.JobStatus{Failed: 1, StartTime: &start}),
+	)
+	defer close(done)
+
+	testcases := []struct {
+		Name     string
+		URL      string
+		WantJobs []string
+	}{
+		{Name: "all", URL: "/api/v1/jobs", WantJobs: []string{"bar-transcode", "foo-transcode", "foo-upload"}},
+		{Name: "by status", URL: "/api/v1/jobs?status=running", WantJobs: []string{"foo-upload"}},
+		{Name: "by job type", URL: "/api/v1/jobs?job-type=transcode", WantJobs: []string{"bar-transcode", "foo-transcode"}},
+		{Name: "by status and job type", URL: "/api/v1/jobs?status=Failed&job-type=upload", WantJobs: []string{}},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var jobs []JobSummary
+			getJSON(t, s, tc.URL, 200, &jobs)
+
+			if len(jobs) != len(tc.WantJobs) {
+				t.Fatalf("expected %d jobs, got %#v", len(tc.WantJobs), jobs)
+			}
+			for i, j := range jobs {
+				if j.Name != tc.WantJobs[i] {
+					t.Fatalf("expected job %d to be %s, got %s", i, tc.WantJobs[i], j.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ApiJob(t *testing.T) {
+	start := metav1.Now()
+	s, done := startTestServerWithJobs(t,
+		buildTestJob("foo", "transcode", batchv1.JobStatus{Succeeded: 1, StartTime: &start, CompletionTime: &start}),
+	)
+	defer close(done)
+
+	var job JobSummary
+	getJSON(t, s, "/api/v1/jobs/foo-transcode", 200, &job)
+	if job.Status != "Succeeded" {
+		t.Fatalf("expected the job to have succeeded, got %s", job.Status)
+	}
+	if job.Video != "foo" || job.JobType != "transcode" {
+		t.Fatalf("expected the job labels to be included, got %#v", job)
+	}
+	if job.StartTime == nil || job.CompletionTime == nil {
+		t.Fatalf("expected the job start and completion times to be included, got %#v", job)
+	}
+
+	var notFound struct{ Error string }
+	getJSON(t, s, "/api/v1/jobs/missing", 404, &notFound)
+	if notFound.Error == "" {
+		t.Fatal("expected an error message for a missing job")
+	}
+}
+
+func TestServer_ApiVideos(t *testing.T) {
+	s, done := startTestServerWithJobs(t,
+		buildTestJob("foo", "transcode", batchv1.JobStatus{Succeeded: 1}),
+		buildTestJob("foo", "upload", batchv1.JobStatus{Active: 1}),
+		buildTestJob("bar", "transcode", batchv1.JobStatus{Active: 1}),
+	)
+	defer close(done)
+
+	var videos []VideoSummary
+	getJSON(t, s, "/api/v1/videos", 200, &videos)
+	if len(videos) != 2 {
+		t.Fatalf("expected 2 videos, got %#v", videos)
+	}
+	if videos[0].Name != "bar" || len(videos[0].Jobs) != 1 {
+		t.Fatalf("expected bar to have 1 job, got %#v", videos[0])
+	}
+	if videos[1].Name != "foo" || len(videos[1].Jobs) != 2 {
+		t.Fatalf("expected foo to have 2 jobs, got %#v", videos[1])
+	}
+
+	getJSON(t, s, "/api/v1/videos?job-type=upload", 200, &videos)
+	if len(videos) != 1 || videos[0].Name != "foo" {
+		t.Fatalf("expected only foo to have an upload job, got %#v", videos)
+	}
+}
@@ -2,69 +2,183 @@ package dashboard
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"html/template"
 	"net/http"
-	"os"
+	"sort"
+	"time"
 
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
 	"github.com/carolynvs/handbrk8s/internal/watcher"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-func Serve() error {
-	var config *rest.Config
-	var err error
-
-	if kubeconfig, ok := os.LookupEnv("KUBECONFIG"); ok {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return err
-		}
-	} else {
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			return err
-		}
+// Serve the dashboard on port 80. Failed videos can only be retried when the
+// shared volume, containing the watch and work directories, is specified.
+func Serve(sharedVolume string) error {
+	client, err := api.GetClient()
+	if err != nil {
+		return err
 	}
 
-	client, err := kubernetes.NewForConfig(config)
+	s, err := NewServer(client)
 	if err != nil {
 		return err
 	}
+	if sharedVolume != "" {
+		dirs := watcher.NewDirectories(sharedVolume, sharedVolume)
+		s.Directories = &dirs
+	}
 
-	jobs, err := client.BatchV1().Jobs(watcher.Namespace).List(context.TODO(), metav1.ListOptions{})
+	done := make(chan struct{})
+	defer close(done)
+	err = s.Start(done)
 	if err != nil {
 		return err
 	}
 
+	http.Handle("/", s)
+	return http.ListenAndServe(":80", nil)
+}
+
+// Server renders the dashboard from a cache of the jobs in the handbrk8s
+// namespace, which is kept current by watching the cluster.
+type Server struct {
+	informers informers.SharedInformerFactory
+	jobs      cache.SharedIndexInformer
+	lister    batchlisters.JobLister
+	template  *template.Template
+	mux       *http.ServeMux
+	events    *broadcaster
+	progress  *progressTracker
+
+	// deleteJob removes a job and its pods.
+	deleteJob func(name, namespace string) error
+
+	// Directories locates videos on the shared volume.
+	// Retrying failed videos is disabled when it is nil.
+	Directories *watcher.Directories
+}
+
+// NewServer creates a dashboard server backed by the specified client.
+// Call Start to begin watching for jobs before serving any requests.
+func NewServer(client kubernetes.Interface) (*Server, error) {
 	t, err := template.New("dashboard").Parse(dashboardTemplate)
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "unable to parse the dashboard template")
 	}
 
-	data := Data{
-		Jobs: make([]DisplayJob, len(jobs.Items)),
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(watcher.Namespace))
+	jobInformer := factory.Batch().V1().Jobs()
+
+	s := &Server{
+		informers: factory,
+		jobs:      jobInformer.Informer(),
+		lister:    jobInformer.Lister(),
+		template:  t,
+		mux:       http.NewServeMux(),
+		events:    newBroadcaster(),
+		deleteJob: jobs.NewClient(client).Delete,
+	}
+	s.progress = newProgressTracker(client, s.publishProgress)
+	s.jobs.AddEventHandler(s.jobEventHandler())
+
+	s.mux.Handle("/metrics", metrics.Handler())
+	s.mux.HandleFunc("/events", s.serveEvents)
+	s.mux.HandleFunc("/api/v1/jobs", s.serveJobs)
+	s.mux.HandleFunc("/api/v1/jobs/", s.serveJob)
+	s.mux.HandleFunc("/api/v1/videos", s.serveVideos)
+	s.mux.HandleFunc("/api/v1/videos/", s.serveVideoAction)
+	s.mux.HandleFunc("/", s.serveDashboard)
+
+	return s, nil
+}
+
+// Start watching for jobs, blocking until the cache is populated.
+// The watch, and following the logs of running transcode jobs,
+// is stopped when done is closed.
+func (s *Server) Start(done <-chan struct{}) error {
+	go func() {
+		<-done
+		s.progress.stopAll()
+	}()
+
+	s.informers.Start(done)
+	if !cache.WaitForCacheSync(done, s.jobs.HasSynced) {
+		return errors.New("unable to sync the job cache")
 	}
-	for i, j := range jobs.Items {
-		data.Jobs[i] = DisplayJob(j)
+	return nil
+}
+
+// ServeHTTP routes requests to either the dashboard or the api.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mux.ServeHTTP(w, req)
+}
+
+// serveDashboard renders the dashboard from the current contents of the job cache.
+func (s *Server) serveDashboard(w http.ResponseWriter, req *http.Request) {
+	data, err := s.buildData()
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+		return
 	}
 
-	helloHandler := func(w http.ResponseWriter, req *http.Request) {
-		b := &bytes.Buffer{}
-		err := t.Execute(b, data)
-		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(500)
-		} else {
-			w.Write(b.Bytes())
-		}
+	b := &bytes.Buffer{}
+	err = s.template.Execute(b, data)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(b.Bytes())
+}
 
+// buildData snapshots the job cache, ordered by job name, and groups the
+// jobs into pipelines.
+func (s *Server) buildData() (Data, error) {
+	jobs, err := s.lister.Jobs(watcher.Namespace).List(labels.Everything())
+	if err != nil {
+		return Data{}, errors.Wrap(err, "unable to list jobs from the cache")
 	}
 
-	http.HandleFunc("/", helloHandler)
-	return http.ListenAndServe(":80", nil)
+	data := Data{
+		Jobs: make([]DisplayJob, len(jobs)),
+	}
+	for i, j := range jobs {
+		data.Jobs[i] = DisplayJob(*j)
+	}
+	sort.Slice(data.Jobs, func(i, j int) bool {
+		return data.Jobs[i].Name < data.Jobs[j].Name
+	})
+	data.Pipelines = BuildPipelines(data.Jobs, time.Now())
+	for i := range data.Pipelines {
+		s.attachProgress(&data.Pipelines[i])
+	}
+
+	return data, nil
+}
+
+// attachProgress includes the most recent progress of a running transcode
+// job, or of the segments of a split video.
+func (s *Server) attachProgress(p *Pipeline) {
+	if p.Stage != StageTranscoding {
+		return
+	}
+	if p.Transcode == nil {
+		if progress, ok := combineProgress(p.Segments, s.progress.get); ok {
+			p.Progress = &progress
+		}
+		return
+	}
+	if progress, ok := s.progress.get(p.Transcode.Name); ok {
+		p.Progress = &progress
+	}
 }
@@ -0,0 +1,144 @@
+package dashboard
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// startTestServer starts a dashboard backed by a fake clientset, returning
+// only after the dashboard is watching for job changes.
+func startTestServer(t *testing.T) (*Server, *fake.Clientset, chan struct{}) {
+	client := fake.NewSimpleClientset()
+
+	// The fake clientset drops events that occur before the watch is established
+	watching := make(chan struct{})
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		gvr := action.GetResource()
+		ns := action.GetNamespace()
+		w, err := client.Tracker().Watch(gvr, ns)
+		if err != nil {
+			return false, nil, err
+		}
+		close(watching)
+		return true, w, nil
+	})
+
+	s, err := NewServer(client)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	done := make(chan struct{})
+	err = s.Start(done)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	<-watching
+
+	return s, client, done
+}
+
+func render(t *testing.T, s *Server) string {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected a 200, got %d", w.Code)
+	}
+
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return string(body)
+}
+
+// waitForRender polls the dashboard until the condition is met by the rendered output.
+func waitForRender(t *testing.T, s *Server, desc string, condition func(body string) bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		body := render(t, s)
+		if condition(body) {
+			return
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for the dashboard to %s, last rendered:\n%s", desc, body)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_LiveJobs(t *testing.T) {
+	s, client, done := startTestServer(t)
+	defer close(done)
+	jobclient := client.BatchV1().Jobs(watcher.Namespace)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-transcode", Namespace: watcher.Namespace},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	job, err := jobclient.Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForRender(t, s, "show the added job", func(body string) bool {
+		return strings.Contains(body, "foo-transcode") && strings.Contains(body, "Running")
+	})
+
+	job.Status = batchv1.JobStatus{Succeeded: 1}
+	_, err = jobclient.Update(context.TODO(), job, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForRender(t, s, "show the updated job", func(body string) bool {
+		return strings.Contains(body, "foo-transcode") && strings.Contains(body, "Succeeded")
+	})
+
+	err = jobclient.Delete(context.TODO(), job.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForRender(t, s, "remove the deleted job", func(body string) bool {
+		return !strings.Contains(body, "foo-transcode")
+	})
+}
+
+func TestServer_IgnoresOtherNamespaces(t *testing.T) {
+	s, client, done := startTestServer(t)
+	defer close(done)
+
+	other := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-job", Namespace: "default"},
+	}
+	_, err := client.BatchV1().Jobs("default").Create(context.TODO(), other, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	mine := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "mine-transcode", Namespace: watcher.Namespace},
+	}
+	_, err = client.BatchV1().Jobs(watcher.Namespace).Create(context.TODO(), mine, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	waitForRender(t, s, "show the job from the handbrk8s namespace", func(body string) bool {
+		return strings.Contains(body, "mine-transcode")
+	})
+	if body := render(t, s); strings.Contains(body, "other-job") {
+		t.Fatalf("expected jobs outside of the %s namespace to be excluded:\n%s", watcher.Namespace, body)
+	}
+}
@@ -0,0 +1,214 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// EventType is the kind of change made to a job.
+type EventType string
+
+const (
+	JobAdded   EventType = "added"
+	JobUpdated EventType = "updated"
+	JobDeleted EventType = "deleted"
+)
+
+// subscriberBuffer is how many events may be queued for a slow client
+// before it is disconnected.
+const subscriberBuffer = 100
+
+// JobEvent is sent to clients of the /events stream when a job changes.
+type JobEvent struct {
+	Type EventType  `json:"type"`
+	Job  JobSummary `json:"job"`
+
+	// Video is the current state of the job's pipeline, or nil when the
+	// job isn't part of a pipeline or the pipeline no longer has any jobs.
+	Video *VideoSummary `json:"video,omitempty"`
+}
+
+// broadcaster fans out job events to every connected client.
+type broadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan JobEvent]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: map[chan JobEvent]struct{}{}}
+}
+
+// subscribe to job events. Call the returned function to unsubscribe.
+func (b *broadcaster) subscribe() (<-chan JobEvent, func()) {
+	events := make(chan JobEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[events] = struct{}{}
+	b.mu.Unlock()
+
+	return events, func() { b.unsubscribe(events) }
+}
+
+func (b *broadcaster) unsubscribe(events chan JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[events]; ok {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// publish an event without blocking the informer. Subscribers that have
+// fallen behind are disconnected, so that they reconnect and reload.
+func (b *broadcaster) publish(e JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- e:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// jobEventHandler publishes changes from the job informer.
+func (s *Server) jobEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if job, ok := obj.(*batchv1.Job); ok {
+				s.progress.track(job)
+			}
+			s.publishJobEvent(JobAdded, obj)
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			observeJobTransition(old, obj)
+			if job, ok := obj.(*batchv1.Job); ok {
+				s.progress.track(job)
+			}
+			s.publishJobEvent(JobUpdated, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if job, ok := obj.(*batchv1.Job); ok {
+				s.progress.stop(job.Name)
+			}
+			s.publishJobEvent(JobDeleted, obj)
+		},
+	}
+}
+
+func (s *Server) publishJobEvent(eventType EventType, obj interface{}) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		fmt.Printf("job informer returned a non-job:\n%#v\n", obj)
+		return
+	}
+
+	e := JobEvent{
+		Type: eventType,
+		Job:  DisplayJob(*job).Summarize(),
+	}
+	if video := e.Job.Video; video != "" {
+		v, err := s.summarizeVideo(video)
+		if err != nil {
+			fmt.Println(err)
+		}
+		e.Video = v
+	}
+
+	s.events.publish(e)
+}
+
+// publishProgress notifies clients that a transcode job's progress has changed.
+func (s *Server) publishProgress(jobName string) {
+	job, err := s.lister.Jobs(watcher.Namespace).Get(jobName)
+	if err != nil {
+		return
+	}
+	s.publishJobEvent(JobUpdated, job)
+}
+
+// summarizeVideo builds the current pipeline for a video from the job cache,
+// returning nil when the video has no jobs.
+func (s *Server) summarizeVideo(video string) (*VideoSummary, error) {
+	jobs, err := s.lister.Jobs(watcher.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var videoJobs []DisplayJob
+	for _, j := range jobs {
+		if DisplayJob(*j).Video() == video {
+			videoJobs = append(videoJobs, DisplayJob(*j))
+		}
+	}
+	if len(videoJobs) == 0 {
+		return nil, nil
+	}
+
+	p := buildPipeline(video, videoJobs, time.Now())
+	s.attachProgress(&p)
+	v := p.Summarize(jobFilter{})
+	return &v, nil
+}
+
+// serveEvents handles GET /events, streaming job changes as server-sent events.
+func (s *Server) serveEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Let the client know that it is subscribed
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// We fell behind, the client will reconnect and reload
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readEvent reads the next server-sent event from the stream.
+func readEvent(t *testing.T, events chan JobEvent) JobEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a job event")
+	}
+	return JobEvent{}
+}
+
+// streamEvents connects to the /events endpoint, returning only once the
+// client is subscribed.
+func streamEvents(t *testing.T, s *Server) (chan JobEvent, func()) {
+	ts := httptest.NewServer(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	connected := make(chan struct{})
+	events := make(chan JobEvent)
+	go func() {
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var eventType string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == ": connected":
+				close(connected)
+			case strings.HasPrefix(line, "event: "):
+				eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var e JobEvent
+				err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e)
+				if err != nil || string(e.Type) != eventType {
+					t.Errorf("invalid event %s: %s", eventType, line)
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	<-connected
+
+	return events, func() {
+		cancel()
+		ts.Close()
+	}
+}
+
+func TestServer_Events(t *testing.T) {
+	s, client, done := startTestServer(t)
+	defer close(done)
+	events, stop := streamEvents(t, s)
+	defer stop()
+	jobclient := client.BatchV1().Jobs(watcher.Namespace)
+
+	job := buildTestJob("foo", "transcode", batchv1.JobStatus{Active: 1})
+	job, err := jobclient.Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	e := readEvent(t, events)
+	if e.Type != JobAdded || e.Job.Name != "foo-transcode" || e.Job.Status != "Running" {
+		t.Fatalf("expected the job to be added, got %#v", e)
+	}
+	if e.Video == nil || e.Video.Stage != StageTranscoding {
+		t.Fatalf("expected the video pipeline to be transcoding, got %#v", e.Video)
+	}
+
+	job.Status = batchv1.JobStatus{Succeeded: 1}
+	_, err = jobclient.Update(context.TODO(), job, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	e = readEvent(t, events)
+	if e.Type != JobUpdated || e.Job.Status != "Succeeded" {
+		t.Fatalf("expected the job to be updated, got %#v", e)
+	}
+
+	err = jobclient.Delete(context.TODO(), job.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	e = readEvent(t, events)
+	if e.Type != JobDeleted || e.Job.Name != "foo-transcode" {
+		t.Fatalf("expected the job to be deleted, got %#v", e)
+	}
+	if e.Video != nil {
+		t.Fatalf("expected the video pipeline to be removed with its last job, got %#v", e.Video)
+	}
+}
+
+func TestBroadcaster_DisconnectsSlowSubscribers(t *testing.T) {
+	b := newBroadcaster()
+	events, unsubscribe := b.subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.publish(JobEvent{Type: JobUpdated})
+	}
+
+	var got int
+	for range events {
+		got++
+	}
+	if got != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before being disconnected, got %d", subscriberBuffer, got)
+	}
+}
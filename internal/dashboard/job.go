@@ -3,16 +3,28 @@ package dashboard
 import (
 	"time"
 
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
 	"k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type Data struct {
-	Jobs []DisplayJob
+	Pipelines []Pipeline
+	Jobs      []DisplayJob
 }
 type DisplayJob v1.Job
 
 func (j DisplayJob) Duration() string {
-	d := time.Now().Sub(j.Status.StartTime.Time)
+	if j.Status.StartTime == nil {
+		return ""
+	}
+
+	end := time.Now()
+	if j.Status.CompletionTime != nil {
+		end = j.Status.CompletionTime.Time
+	}
+	d := end.Sub(j.Status.StartTime.Time)
 
 	return d.String()
 }
@@ -21,8 +33,75 @@ func (j DisplayJob) StatusDescription() string {
 	if j.Status.Active > 0 {
 		return "Running"
 	}
-	if j.Status.Failed == 0 {
+	if j.Succeeded() {
 		return "Succeeded"
 	}
-	return "Failed"
+	if j.Failed() {
+		return "Failed"
+	}
+	return "Pending"
+}
+
+// Succeeded checks if the job has completed successfully.
+func (j DisplayJob) Succeeded() bool {
+	job := v1.Job(j)
+	return jobs.HasSucceeded(&job)
+}
+
+// Failed checks if the job has failed and will not be retried. A job with
+// failed pods that is still retrying has not failed.
+func (j DisplayJob) Failed() bool {
+	job := v1.Job(j)
+	return jobs.HasFailed(&job)
+}
+
+// Finished checks if the job has completed, or has failed and will not be
+// retried.
+func (j DisplayJob) Finished() bool {
+	return j.Succeeded() || j.Failed()
+}
+
+// Started checks if the job has scheduled any pods.
+func (j DisplayJob) Started() bool {
+	return j.Status.StartTime != nil
+}
+
+// FinishTime is when the job completed or failed, otherwise nil.
+func (j DisplayJob) FinishTime() *time.Time {
+	if j.Status.CompletionTime != nil {
+		t := j.Status.CompletionTime.Time
+		return &t
+	}
+	for _, c := range j.Status.Conditions {
+		if c.Type == v1.JobFailed && c.Status == corev1.ConditionTrue {
+			t := c.LastTransitionTime.Time
+			return &t
+		}
+	}
+	return nil
+}
+
+// Video is the name of the video that the job is processing.
+func (j DisplayJob) Video() string {
+	return j.label(watcher.VideoLabel)
+}
+
+// JobType is the step, such as transcode or upload, that the job performs.
+func (j DisplayJob) JobType() string {
+	return j.label(watcher.JobTypeLabel)
+}
+
+// IsConcat checks if the job joins the segments of a split video, rather
+// than transcoding it.
+func (j DisplayJob) IsConcat() bool {
+	return j.JobType() == transcodeJobType && j.Name == watcher.ConcatJobName(j.Video())
+}
+
+// label looks up a label on the job, falling back to the pod template
+// when the job's labels were not defaulted from it.
+func (j DisplayJob) label(key string) string {
+	if value, ok := j.Labels[key]; ok {
+		return value
+	}
+	return j.Spec.Template.Labels[key]
 }
@@ -0,0 +1,34 @@
+package dashboard
+
+import (
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// observeJobTransition records how long a transcode job, or a segment of a
+// split video, ran when it finishes. The concat job that joins the segments
+// only copies them, so it isn't counted. Only transitions are counted, so that jobs that finished before
+// the dashboard started, and are listed when the cache is populated, are not
+// counted again. The watcher counts the finished pipelines, once per video.
+func observeJobTransition(oldObj, newObj interface{}) {
+	oldJob, ok := oldObj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	newJob, ok := newObj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	prev, cur := DisplayJob(*oldJob), DisplayJob(*newJob)
+
+	if !cur.Succeeded() || prev.Succeeded() {
+		return
+	}
+	if cur.JobType() != transcodeJobType && cur.JobType() != segmentJobType || cur.IsConcat() {
+		return
+	}
+	if cur.Status.StartTime != nil && cur.Status.CompletionTime != nil {
+		d := cur.Status.CompletionTime.Sub(cur.Status.StartTime.Time)
+		metrics.TranscodeDuration.Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,79 @@
+package dashboard
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// transcodesObserved reads how many transcode durations have been recorded.
+// The metrics are global, so tests compare the value before and after.
+func transcodesObserved(t *testing.T) float64 {
+	const name = "handbrk8s_transcode_duration_seconds_count"
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if !strings.HasPrefix(line, name+" ") {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		return value
+	}
+	t.Fatalf("expected the metrics to contain %s:\n%s", name, rec.Body.String())
+	return 0
+}
+
+func TestObserveJobTransition(t *testing.T) {
+	start := metav1.NewTime(time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC))
+	finish := metav1.NewTime(start.Add(30 * time.Minute))
+	running := batchv1.JobStatus{Active: 1, StartTime: &start}
+	succeeded := batchv1.JobStatus{
+		Succeeded:      1,
+		StartTime:      &start,
+		CompletionTime: &finish,
+		Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: "True"}},
+	}
+
+	concat := func(status batchv1.JobStatus) *batchv1.Job {
+		j := buildTestJob("foo", transcodeJobType, status)
+		j.Name = "foo-concat"
+		return j
+	}
+
+	testcases := []struct {
+		name        string
+		old, new    *batchv1.Job
+		wantObserve bool
+	}{
+		{"transcode", buildTestJob("foo", transcodeJobType, running), buildTestJob("foo", transcodeJobType, succeeded), true},
+		{"segment", buildTestJob("foo", segmentJobType, running), buildTestJob("foo", segmentJobType, succeeded), true},
+		{"concat", concat(running), concat(succeeded), false},
+		{"upload", buildTestJob("foo", uploadJobType, running), buildTestJob("foo", uploadJobType, succeeded), false},
+		{"already succeeded", buildTestJob("foo", transcodeJobType, succeeded), buildTestJob("foo", transcodeJobType, succeeded), false},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			before := transcodesObserved(t)
+			observeJobTransition(tc.old, tc.new)
+			got := transcodesObserved(t) - before
+			if tc.wantObserve && got != 1 {
+				t.Fatalf("expected the duration to be recorded once, got %v", got)
+			}
+			if !tc.wantObserve && got != 0 {
+				t.Fatalf("expected the duration to not be recorded, got %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package dashboard
+
+import (
+	"sort"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+)
+
+// Stage of a video as it moves through the transcode and upload jobs.
+type Stage string
+
+const (
+	StageQueued      Stage = "queued"
+	StageTranscoding Stage = "transcoding"
+	StageWaiting     Stage = "waiting to upload"
+	StageUploading   Stage = "uploading"
+	StageDone        Stage = "done"
+	StageFailed      Stage = "failed"
+)
+
+const (
+	transcodeJobType = watcher.TranscodeJobType
+	uploadJobType    = watcher.UploadJobType
+	segmentJobType   = watcher.SegmentJobType
+)
+
+// StageDuration is the amount of time that a pipeline spent in a stage.
+type StageDuration struct {
+	Stage    Stage
+	Duration time.Duration
+}
+
+// Pipeline joins the jobs that process a single video.
+type Pipeline struct {
+	// Video is the value of the video label shared by the jobs.
+	Video string
+
+	// Jobs are all of the jobs for the video, ordered by name.
+	Jobs []DisplayJob
+
+	// Transcode is the job transcoding the video, or nil if it doesn't exist.
+	Transcode *DisplayJob
+
+	// Segments are the jobs transcoding the segments of a split video,
+	// ordered by name. Transcode is the job that joins them.
+	Segments []DisplayJob
+
+	// Upload is the job uploading the video to Plex, or nil if it doesn't exist.
+	Upload *DisplayJob
+
+	// Stage is the current stage of the pipeline.
+	Stage Stage
+
+	// Elapsed is the total time since the pipeline's first job was created,
+	// stopping once the pipeline is done or failed.
+	Elapsed time.Duration
+
+	// Stages is the time spent in each stage that the pipeline has reached, in order.
+	Stages []StageDuration
+
+	// Progress is the most recent progress reported by HandBrake while
+	// transcoding, or nil when it isn't available.
+	Progress *handbrake.Progress
+}
+
+// BuildPipelines groups jobs by their video label, ordered by video name.
+// Jobs without a video label are not included in any pipeline.
+func BuildPipelines(jobs []DisplayJob, now time.Time) []Pipeline {
+	byVideo := map[string][]DisplayJob{}
+	for _, j := range jobs {
+		if j.Video() == "" {
+			continue
+		}
+		byVideo[j.Video()] = append(byVideo[j.Video()], j)
+	}
+
+	pipelines := make([]Pipeline, 0, len(byVideo))
+	for video, videoJobs := range byVideo {
+		pipelines = append(pipelines, buildPipeline(video, videoJobs, now))
+	}
+	sort.Slice(pipelines, func(i, j int) bool {
+		return pipelines[i].Video < pipelines[j].Video
+	})
+	return pipelines
+}
+
+func buildPipeline(video string, jobs []DisplayJob, now time.Time) Pipeline {
+	p := Pipeline{Video: video, Jobs: jobs}
+
+	created := now
+	for i := range jobs {
+		j := jobs[i]
+		switch j.JobType() {
+		case transcodeJobType:
+			p.Transcode = &j
+		case uploadJobType:
+			p.Upload = &j
+		case segmentJobType:
+			p.Segments = append(p.Segments, j)
+		}
+
+		jobCreated := j.CreationTimestamp.Time
+		if jobCreated.IsZero() && j.Status.StartTime != nil {
+			jobCreated = j.Status.StartTime.Time
+		}
+		if !jobCreated.IsZero() && jobCreated.Before(created) {
+			created = jobCreated
+		}
+	}
+
+	p.Stage = p.currentStage()
+	end := p.endTime(now)
+	p.Elapsed = nonNegative(end.Sub(created))
+	p.Stages = p.stageDurations(created, end)
+
+	return p
+}
+
+func (p Pipeline) currentStage() Stage {
+	for _, j := range p.transcodeJobs() {
+		if j.Failed() {
+			return StageFailed
+		}
+	}
+	if p.Upload != nil && p.Upload.Failed() {
+		return StageFailed
+	}
+	if p.Upload != nil && p.Upload.Succeeded() {
+		return StageDone
+	}
+	if p.Transcode != nil && p.Transcode.Succeeded() {
+		if p.Upload != nil && p.Upload.Status.Active > 0 {
+			return StageUploading
+		}
+		return StageWaiting
+	}
+	for _, j := range p.transcodeJobs() {
+		if j.Started() || j.Status.Active > 0 {
+			return StageTranscoding
+		}
+	}
+	return StageQueued
+}
+
+// transcodeJobs are the segment jobs of a split video, and the transcode job.
+func (p Pipeline) transcodeJobs() []*DisplayJob {
+	jobs := make([]*DisplayJob, 0, len(p.Segments)+1)
+	for i := range p.Segments {
+		jobs = append(jobs, &p.Segments[i])
+	}
+	if p.Transcode != nil {
+		jobs = append(jobs, p.Transcode)
+	}
+	return jobs
+}
+
+// transcodeStart is when the first segment, or the transcode job, started.
+func (p Pipeline) transcodeStart() *time.Time {
+	var start *time.Time
+	for _, j := range p.transcodeJobs() {
+		if j.Status.StartTime != nil && (start == nil || j.Status.StartTime.Time.Before(*start)) {
+			t := j.Status.StartTime.Time
+			start = &t
+		}
+	}
+	return start
+}
+
+// endTime is when the pipeline finished, or now when it is still in progress.
+func (p Pipeline) endTime(now time.Time) time.Time {
+	switch p.Stage {
+	case StageDone:
+		if t := p.Upload.FinishTime(); t != nil {
+			return *t
+		}
+	case StageFailed:
+		for _, j := range append(p.transcodeJobs(), p.Upload) {
+			if j != nil && j.Failed() {
+				if t := j.FinishTime(); t != nil {
+					return *t
+				}
+			}
+		}
+	}
+	return now
+}
+
+// stageDurations calculates how long the pipeline spent in each stage.
+// The watcher creates the upload job once the transcode has finished, but
+// older pipelines created it alongside the transcode job, so the upload
+// job's start time is only meaningful when it is after the transcode has finished.
+func (p Pipeline) stageDurations(created, end time.Time) []StageDuration {
+	var stages []StageDuration
+	add := func(stage Stage, from, to time.Time) {
+		stages = append(stages, StageDuration{Stage: stage, Duration: nonNegative(to.Sub(from))})
+	}
+
+	start := p.transcodeStart()
+	if start == nil {
+		add(StageQueued, created, end)
+		return stages
+	}
+	transcodeStart := *start
+	add(StageQueued, created, transcodeStart)
+
+	var transcodeFinish *time.Time
+	if p.Transcode != nil {
+		transcodeFinish = p.Transcode.FinishTime()
+	}
+	if transcodeFinish == nil || !p.Transcode.Succeeded() {
+		add(StageTranscoding, transcodeStart, stopAt(transcodeFinish, end))
+		return stages
+	}
+	add(StageTranscoding, transcodeStart, *transcodeFinish)
+
+	waitFinish := end
+	if p.Upload != nil && (p.Upload.Status.Active > 0 || (p.Upload.Started() && p.Upload.FinishTime() != nil)) {
+		waitFinish = *transcodeFinish
+		if uploadStart := p.Upload.Status.StartTime; uploadStart != nil && uploadStart.Time.After(waitFinish) {
+			waitFinish = uploadStart.Time
+		}
+	}
+	add(StageWaiting, *transcodeFinish, waitFinish)
+
+	if p.Stage == StageWaiting || p.Upload == nil {
+		return stages
+	}
+	add(StageUploading, waitFinish, stopAt(p.Upload.FinishTime(), end))
+
+	return stages
+}
+
+func stopAt(t *time.Time, fallback time.Time) time.Time {
+	if t != nil {
+		return *t
+	}
+	return fallback
+}
+
+// nonNegative rounds a duration to the second for display, ignoring clock skew
+// between the job timestamps.
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,247 @@
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testEpoch = time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// at is a timestamp relative to testEpoch.
+func at(minutes int) *metav1.Time {
+	t := metav1.NewTime(testEpoch.Add(time.Duration(minutes) * time.Minute))
+	return &t
+}
+
+func buildPipelineJob(jobType string, created int, status batchv1.JobStatus) DisplayJob {
+	j := buildTestJob("foo", jobType, status)
+	j.CreationTimestamp = *at(created)
+	return DisplayJob(*j)
+}
+
+func failedCondition(minutes int) []batchv1.JobCondition {
+	return []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: *at(minutes)},
+	}
+}
+
+func TestBuildPipelines(t *testing.T) {
+	now := testEpoch.Add(60 * time.Minute)
+
+	testcases := []struct {
+		Name        string
+		Jobs        []DisplayJob
+		WantStage   Stage
+		WantElapsed time.Duration
+		WantStages  []StageDuration
+	}{
+		{
+			Name: "queued",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{}),
+				buildPipelineJob(uploadJobType, 0, batchv1.JobStatus{}),
+			},
+			WantStage:   StageQueued,
+			WantElapsed: 60 * time.Minute,
+			WantStages:  []StageDuration{{StageQueued, 60 * time.Minute}},
+		},
+		{
+			Name: "transcoding",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(5)}),
+				buildPipelineJob(uploadJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(1)}),
+			},
+			WantStage:   StageTranscoding,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 55 * time.Minute},
+			},
+		},
+		{
+			Name: "waiting for the upload job to be created",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(50)}),
+			},
+			WantStage:   StageWaiting,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 45 * time.Minute},
+				{StageWaiting, 10 * time.Minute},
+			},
+		},
+		{
+			Name: "waiting to upload",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(50)}),
+				buildPipelineJob(uploadJobType, 50, batchv1.JobStatus{}),
+			},
+			WantStage:   StageWaiting,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 45 * time.Minute},
+				{StageWaiting, 10 * time.Minute},
+			},
+		},
+		{
+			Name: "uploading",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(50)}),
+				buildPipelineJob(uploadJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(52)}),
+			},
+			WantStage:   StageUploading,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 45 * time.Minute},
+				{StageWaiting, 2 * time.Minute},
+				{StageUploading, 8 * time.Minute},
+			},
+		},
+		{
+			Name: "done",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(50)}),
+				buildPipelineJob(uploadJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(1), CompletionTime: at(55)}),
+			},
+			WantStage:   StageDone,
+			WantElapsed: 55 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 45 * time.Minute},
+				{StageWaiting, 0},
+				{StageUploading, 5 * time.Minute},
+			},
+		},
+		{
+			Name: "transcode failed",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Failed: 20, StartTime: at(5), Conditions: failedCondition(30)}),
+				buildPipelineJob(uploadJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(1)}),
+			},
+			WantStage:   StageFailed,
+			WantElapsed: 30 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 25 * time.Minute},
+			},
+		},
+		{
+			Name: "retrying transcode",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Active: 1, Failed: 2, StartTime: at(5)}),
+			},
+			WantStage:   StageTranscoding,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 55 * time.Minute},
+			},
+		},
+		{
+			Name: "transcode in backoff between retries",
+			Jobs: []DisplayJob{
+				buildPipelineJob(transcodeJobType, 0, batchv1.JobStatus{Failed: 3, StartTime: at(5)}),
+			},
+			WantStage:   StageTranscoding,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 55 * time.Minute},
+			},
+		},
+		{
+			Name: "transcoding segments",
+			Jobs: []DisplayJob{
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(20)}),
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(3)}),
+			},
+			WantStage:   StageTranscoding,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 3 * time.Minute},
+				{StageTranscoding, 57 * time.Minute},
+			},
+		},
+		{
+			Name: "concatenating segments",
+			Jobs: []DisplayJob{
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(5), CompletionTime: at(20)}),
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Succeeded: 1, StartTime: at(3), CompletionTime: at(25)}),
+				buildPipelineJob(transcodeJobType, 25, batchv1.JobStatus{Succeeded: 1, StartTime: at(26), CompletionTime: at(30)}),
+			},
+			WantStage:   StageWaiting,
+			WantElapsed: 60 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 3 * time.Minute},
+				{StageTranscoding, 27 * time.Minute},
+				{StageWaiting, 30 * time.Minute},
+			},
+		},
+		{
+			Name: "segment failed",
+			Jobs: []DisplayJob{
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Active: 1, StartTime: at(5)}),
+				buildPipelineJob(segmentJobType, 0, batchv1.JobStatus{Failed: 20, StartTime: at(5), Conditions: failedCondition(15)}),
+			},
+			WantStage:   StageFailed,
+			WantElapsed: 15 * time.Minute,
+			WantStages: []StageDuration{
+				{StageQueued, 5 * time.Minute},
+				{StageTranscoding, 10 * time.Minute},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			pipelines := BuildPipelines(tc.Jobs, now)
+			if len(pipelines) != 1 {
+				t.Fatalf("expected the jobs to be joined into 1 pipeline, got %d", len(pipelines))
+			}
+			p := pipelines[0]
+
+			if p.Stage != tc.WantStage {
+				t.Fatalf("expected stage %q, got %q", tc.WantStage, p.Stage)
+			}
+			if p.Elapsed != tc.WantElapsed {
+				t.Fatalf("expected %s elapsed, got %s", tc.WantElapsed, p.Elapsed)
+			}
+			if len(p.Stages) != len(tc.WantStages) {
+				t.Fatalf("expected stages %v, got %v", tc.WantStages, p.Stages)
+			}
+			for i := range p.Stages {
+				if p.Stages[i] != tc.WantStages[i] {
+					t.Fatalf("expected stages %v, got %v", tc.WantStages, p.Stages)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPipelines_GroupsByVideo(t *testing.T) {
+	jobs := []DisplayJob{
+		DisplayJob(*buildTestJob("foo", transcodeJobType, batchv1.JobStatus{})),
+		DisplayJob(*buildTestJob("bar", transcodeJobType, batchv1.JobStatus{})),
+		DisplayJob(*buildTestJob("foo", uploadJobType, batchv1.JobStatus{})),
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	}
+
+	pipelines := BuildPipelines(jobs, testEpoch)
+	if len(pipelines) != 2 {
+		t.Fatalf("expected 2 pipelines, got %d", len(pipelines))
+	}
+	if pipelines[0].Video != "bar" || pipelines[0].Transcode == nil || pipelines[0].Upload != nil {
+		t.Fatalf("expected bar to only have a transcode job, got %#v", pipelines[0])
+	}
+	if pipelines[1].Video != "foo" || pipelines[1].Transcode == nil || pipelines[1].Upload == nil {
+		t.Fatalf("expected foo to have a transcode and upload job, got %#v", pipelines[1])
+	}
+}
@@ -0,0 +1,174 @@
+package dashboard
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// progressRetryInterval is how long to wait before following the logs again,
+// for example when the transcode pod hasn't started yet or was restarted.
+const progressRetryInterval = 5 * time.Second
+
+// progressTracker follows the logs of running transcode jobs, keeping the
+// most recent progress reported by HandBrake.
+type progressTracker struct {
+	client kubernetes.Interface
+
+	// onChange is called when a job's progress has changed enough to display.
+	onChange func(jobName string)
+
+	mu        sync.Mutex
+	following map[string]chan struct{}
+	latest    map[string]handbrake.Progress
+}
+
+func newProgressTracker(client kubernetes.Interface, onChange func(jobName string)) *progressTracker {
+	return &progressTracker{
+		client:    client,
+		onChange:  onChange,
+		following: map[string]chan struct{}{},
+		latest:    map[string]handbrake.Progress{},
+	}
+}
+
+// track starts following the logs of a running transcode or segment job,
+// and stops following them once the job is no longer running.
+func (t *progressTracker) track(job *batchv1.Job) {
+	j := DisplayJob(*job)
+	transcoding := j.JobType() == transcodeJobType || j.JobType() == segmentJobType
+	running := transcoding && j.Status.Active > 0 && !j.Succeeded()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, following := t.following[j.Name]
+	if running && !following {
+		done := make(chan struct{})
+		t.following[j.Name] = done
+		go t.follow(done, j.Name)
+	} else if !running && following {
+		t.stopLocked(j.Name)
+	}
+}
+
+// stop following a job's logs and forget its progress.
+func (t *progressTracker) stop(jobName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopLocked(jobName)
+}
+
+func (t *progressTracker) stopLocked(jobName string) {
+	if done, ok := t.following[jobName]; ok {
+		close(done)
+		delete(t.following, jobName)
+	}
+	delete(t.latest, jobName)
+}
+
+// stopAll stops following the logs of every job.
+func (t *progressTracker) stopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for jobName := range t.following {
+		t.stopLocked(jobName)
+	}
+}
+
+// get the most recent progress of a job.
+func (t *progressTracker) get(jobName string) (handbrake.Progress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.latest[jobName]
+	return p, ok
+}
+
+func (t *progressTracker) follow(done chan struct{}, jobName string) {
+	for {
+		progressChan, errChan := handbrake.FollowProgress(done, t.client, watcher.Namespace, jobName)
+		for p := range progressChan {
+			t.set(done, jobName, p)
+		}
+		if err := <-errChan; err != nil {
+			log.Println(err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(progressRetryInterval):
+		}
+	}
+}
+
+// set the latest progress of a job, notifying when the percent complete changes
+// by at least a whole percent, so that clients aren't flooded with updates.
+func (t *progressTracker) set(done chan struct{}, jobName string, p handbrake.Progress) {
+	t.mu.Lock()
+	if t.following[jobName] != done {
+		// The job stopped being tracked while we were reading its logs
+		t.mu.Unlock()
+		return
+	}
+	prev, ok := t.latest[jobName]
+	t.latest[jobName] = p
+	t.mu.Unlock()
+
+	if !ok || int(prev.Percent) != int(p.Percent) || prev.Done != p.Done {
+		t.onChange(jobName)
+	}
+}
+
+// combineProgress is the progress of a split video, from the progress of
+// each segment. The segments are transcoded in parallel, so the video is done
+// when the slowest segment is done, and the encode rate is their total. A
+// segment that has succeeded is complete, and one that hasn't reported any
+// progress yet hasn't started. It returns false when no segment has
+// reported progress.
+func combineProgress(segments []DisplayJob, get func(jobName string) (handbrake.Progress, bool)) (handbrake.Progress, bool) {
+	var combined handbrake.Progress
+	var complete float64
+	reported := false
+	for _, s := range segments {
+		if s.Succeeded() {
+			complete++
+			continue
+		}
+		p, ok := get(s.Name)
+		if !ok {
+			continue
+		}
+		reported = true
+
+		// Include the tasks that already finished, e.g. the first pass
+		taskCount := p.TaskCount
+		if taskCount < 1 {
+			taskCount = 1
+		}
+		task := p.Task
+		if task < 1 {
+			task = 1
+		}
+		complete += (float64(task-1) + p.Percent/100) / float64(taskCount)
+
+		combined.FPS += p.FPS
+		combined.AvgFPS += p.AvgFPS
+		if p.ETA > combined.ETA {
+			combined.ETA = p.ETA
+		}
+	}
+	if !reported {
+		return handbrake.Progress{}, false
+	}
+
+	combined.Task = 1
+	combined.TaskCount = 1
+	combined.Percent = 100 * complete / float64(len(segments))
+	return combined, true
+}
@@ -0,0 +1,84 @@
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProgressTracker_FollowsRunningTranscodes(t *testing.T) {
+	tracker := newProgressTracker(fake.NewSimpleClientset(), func(string) {})
+	defer tracker.stopAll()
+
+	isFollowing := func(jobName string) bool {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		_, ok := tracker.following[jobName]
+		return ok
+	}
+
+	upload := buildTestJob("foo", uploadJobType, batchv1.JobStatus{Active: 1})
+	tracker.track(upload)
+	if isFollowing(upload.Name) {
+		t.Fatal("expected upload jobs to be ignored")
+	}
+
+	transcode := buildTestJob("foo", transcodeJobType, batchv1.JobStatus{Active: 1})
+	tracker.track(transcode)
+	if !isFollowing(transcode.Name) {
+		t.Fatal("expected the logs of a running transcode job to be followed")
+	}
+
+	segment := buildTestJob("bar", segmentJobType, batchv1.JobStatus{Active: 1})
+	tracker.track(segment)
+	if !isFollowing(segment.Name) {
+		t.Fatal("expected the logs of a running segment job to be followed")
+	}
+
+	transcode.Status = batchv1.JobStatus{Succeeded: 1}
+	tracker.track(transcode)
+	if isFollowing(transcode.Name) {
+		t.Fatal("expected to stop following the logs once the transcode job finished")
+	}
+}
+
+func TestCombineProgress(t *testing.T) {
+	segment := func(name string, status batchv1.JobStatus) DisplayJob {
+		j := buildTestJob("foo", segmentJobType, status)
+		j.Name = name
+		return DisplayJob(*j)
+	}
+	segments := []DisplayJob{
+		segment("foo-part-000", batchv1.JobStatus{Succeeded: 1}),
+		segment("foo-part-001", batchv1.JobStatus{Active: 1}),
+		segment("foo-part-002", batchv1.JobStatus{Active: 1}),
+		segment("foo-part-003", batchv1.JobStatus{}),
+	}
+	progress := map[string]handbrake.Progress{
+		"foo-part-001": {Task: 1, TaskCount: 1, Percent: 50, FPS: 20, ETA: 10 * time.Minute},
+		// The second pass of a two pass encode
+		"foo-part-002": {Task: 2, TaskCount: 2, Percent: 50, FPS: 30, ETA: 5 * time.Minute},
+	}
+	get := func(jobName string) (handbrake.Progress, bool) {
+		p, ok := progress[jobName]
+		return p, ok
+	}
+
+	got, ok := combineProgress(segments, get)
+	if !ok {
+		t.Fatal("expected the progress of the segments to be combined")
+	}
+	// (1 + 0.5 + 0.75 + 0) / 4 segments
+	if got.Percent != 56.25 || got.FPS != 50 || got.ETA != 10*time.Minute {
+		t.Fatalf("expected the segments to be combined, got %#v", got)
+	}
+
+	_, ok = combineProgress(segments[3:], get)
+	if ok {
+		t.Fatal("expected no progress until a segment reports it")
+	}
+}
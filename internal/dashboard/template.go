@@ -2,10 +2,143 @@ package dashboard
 
 const dashboardTemplate = `<html>
 <body>
-<ul>
+<table>
+<thead>
+<tr><th>Video</th><th>Stage</th><th>Elapsed</th><th>Stages</th><th></th></tr>
+</thead>
+<tbody id="videos">
+{{range .Pipelines}}
+<tr id="video-{{.Video}}">
+<td>{{.Video}}</td>
+<td>{{.Stage}}{{with .Progress}}<br/><progress max="100" value="{{.Percent}}"></progress> {{printf "%.1f" .Percent}}%{{if .ETA}} ETA {{.ETA}}{{end}}{{end}}</td>
+<td>{{.Elapsed}}</td>
+<td>{{range .Stages}}{{.Stage}}: {{.Duration}}<br/>{{end}}</td>
+<td>
+<button data-video="{{.Video}}" data-action="cancel">Cancel</button>
+<button data-video="{{.Video}}" data-action="retry">Retry</button>
+<button data-video="{{.Video}}" data-action="delete">Delete</button>
+</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+<ul id="jobs">
 {{range .Jobs}}
-<li>{{.Name}} ({{ .Duration }}) - {{ .StatusDescription }}</li>
+<li id="job-{{.Name}}">{{.Name}} ({{ .Duration }}) - {{ .StatusDescription }}</li>
 {{end}}
 </ul>
+<script>
+// Update the rows in place as jobs change, instead of reloading the page
+(function() {
+  function replaceOrAppend(parentId, id, tagName, render) {
+    var el = document.getElementById(id);
+    if (!el) {
+      el = document.createElement(tagName);
+      el.id = id;
+      document.getElementById(parentId).appendChild(el);
+    }
+    render(el);
+  }
+
+  function remove(id) {
+    var el = document.getElementById(id);
+    if (el) {
+      el.parentNode.removeChild(el);
+    }
+  }
+
+  function cell(row, text) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    row.appendChild(td);
+    return td;
+  }
+
+  function updateJob(type, job) {
+    var id = "job-" + job.name;
+    if (type === "deleted") {
+      remove(id);
+      return;
+    }
+    replaceOrAppend("jobs", id, "li", function(li) {
+      li.textContent = job.name + " (" + job.duration + ") - " + job.status;
+    });
+  }
+
+  function updateVideo(name, video) {
+    var id = "video-" + name;
+    if (!video) {
+      remove(id);
+      return;
+    }
+    replaceOrAppend("videos", id, "tr", function(tr) {
+      tr.innerHTML = "";
+      cell(tr, video.name);
+      var stage = cell(tr, video.stage);
+      if (video.progress) {
+        var bar = document.createElement("progress");
+        bar.max = 100;
+        bar.value = video.progress.percent;
+        stage.appendChild(document.createElement("br"));
+        stage.appendChild(bar);
+        var text = " " + video.progress.percent.toFixed(1) + "%";
+        if (video.eta) {
+          text += " ETA " + video.eta;
+        }
+        stage.appendChild(document.createTextNode(text));
+      }
+      cell(tr, video.elapsed);
+      var stages = cell(tr, "");
+      video.stages.forEach(function(s) {
+        stages.appendChild(document.createTextNode(s.stage + ": " + s.duration));
+        stages.appendChild(document.createElement("br"));
+      });
+      var actions = cell(tr, "");
+      [["cancel", "Cancel"], ["retry", "Retry"], ["delete", "Delete"]].forEach(function(a) {
+        var button = document.createElement("button");
+        button.setAttribute("data-video", video.name);
+        button.setAttribute("data-action", a[0]);
+        button.textContent = a[1];
+        actions.appendChild(button);
+      });
+    });
+  }
+
+  document.getElementById("videos").addEventListener("click", function(e) {
+    var video = e.target.getAttribute("data-video");
+    var action = e.target.getAttribute("data-action");
+    if (!video || !action) {
+      return;
+    }
+    fetch("/api/v1/videos/" + encodeURIComponent(video) + "/" + action, {method: "POST"})
+      .then(function(resp) {
+        if (!resp.ok) {
+          return resp.json().then(function(body) { alert(body.error); });
+        }
+      });
+  });
+
+  function handle(e) {
+    var evt = JSON.parse(e.data);
+    updateJob(evt.type, evt.job);
+    if (evt.job.video) {
+      updateVideo(evt.job.video, evt.video);
+    }
+  }
+
+  var source = new EventSource("/events");
+  // Reload when reconnecting, since events may have been missed while disconnected
+  var opened = false;
+  source.addEventListener("open", function() {
+    if (opened) {
+      window.location.reload();
+    }
+    opened = true;
+  });
+  ["added", "updated", "deleted"].forEach(function(type) {
+    source.addEventListener(type, handle);
+  });
+})();
+</script>
 </body>
 </html>`
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/carolynvs/handbrk8s/internal/metrics"
 	"github.com/pkg/errors"
 )
 
@@ -35,6 +36,7 @@ func CopyFile(src, dest string) error {
 	defer destFile.Close()
 
 	written, err := io.Copy(destFile, srcFile)
+	metrics.BytesCopied.Add(float64(written))
 	if err != nil {
 		return errors.Wrapf(err, "unable to copy %s to %s", src, dest)
 	}
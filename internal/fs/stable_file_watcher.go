@@ -18,9 +18,11 @@ type StableFileWatcher struct {
 	pollingPeriod time.Duration
 	done          chan struct{}
 	unstableFiles sync.Map
+	thresholdMu   sync.RWMutex
 
 	// StableThreshold is the duration that a file must not change
-	// before a signaling an event for the file.
+	// before a signaling an event for the file. Use SetStableThreshold
+	// to change it once the watcher has started.
 	StableThreshold time.Duration
 
 	// Events signal when a file has stabilized.
@@ -102,6 +104,21 @@ func (w *StableFileWatcher) Close() {
 	close(w.done)
 }
 
+// SetStableThreshold changes the duration that a file must not change before
+// signaling an event. Files that are already waiting to stabilize use the new
+// threshold the next time that they change.
+func (w *StableFileWatcher) SetStableThreshold(threshold time.Duration) {
+	w.thresholdMu.Lock()
+	defer w.thresholdMu.Unlock()
+	w.StableThreshold = threshold
+}
+
+func (w *StableFileWatcher) stableThreshold() time.Duration {
+	w.thresholdMu.RLock()
+	defer w.thresholdMu.RUnlock()
+	return w.StableThreshold
+}
+
 // waitUntilFileIsStable waits until the file doesn't change for a set amount of
 // time. This prevents acting on a file that is still copying, being written.
 func (w *StableFileWatcher) waitUntilFileIsStable(path string) {
@@ -120,7 +137,7 @@ func (w *StableFileWatcher) waitUntilFileIsStable(path string) {
 	}
 	go fw.Start(w.pollingPeriod)
 
-	timer := time.NewTimer(w.StableThreshold)
+	timer := time.NewTimer(w.stableThreshold())
 	defer timer.Stop()
 
 	for {
@@ -138,7 +155,7 @@ func (w *StableFileWatcher) waitUntilFileIsStable(path string) {
 			if !timer.Stop() {
 				<-timer.C
 			}
-			timer.Reset(w.StableThreshold)
+			timer.Reset(w.stableThreshold())
 		case <-timer.C:
 			// Make sure the file is still present
 			_, err := os.Stat(path)
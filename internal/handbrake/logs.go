@@ -0,0 +1,92 @@
+package handbrake
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ContainerName is the name of the transcoder container in the transcode job
+// template. Progress is only reported when the transcoder is HandBrakeCLI.
+const ContainerName = "transcoder"
+
+// FollowProgress follows the HandBrakeCLI container logs of a transcode job's
+// running pod, sending each progress update. The channels are closed when the
+// logs end, or done is closed.
+func FollowProgress(done <-chan struct{}, client kubernetes.Interface, namespace, jobName string) (<-chan Progress, <-chan error) {
+	progressChan := make(chan Progress)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(progressChan)
+		defer close(errChan)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		pod, err := findRunningPod(ctx, client, namespace, jobName)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		opts := &corev1.PodLogOptions{
+			Container: ContainerName,
+			Follow:    true,
+		}
+		logs, err := client.CoreV1().Pods(namespace).GetLogs(pod.Name, opts).Stream(ctx)
+		if err != nil {
+			errChan <- errors.Wrapf(err, "unable to follow the logs of %s/%s", namespace, pod.Name)
+			return
+		}
+		defer logs.Close()
+
+		err = ReadProgress(logs, func(p Progress) {
+			select {
+			case progressChan <- p:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			errChan <- errors.Wrapf(err, "unable to read the logs of %s/%s", namespace, pod.Name)
+		}
+	}()
+
+	return progressChan, errChan
+}
+
+// findRunningPod finds the most recently created running pod for a job.
+func findRunningPod(ctx context.Context, client kubernetes.Interface, namespace, jobName string) (*corev1.Pod, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{"job-name": jobName}).String(),
+	}
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the pods for %s/%s", namespace, jobName)
+	}
+
+	var running *corev1.Pod
+	for i, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		if running == nil || running.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			running = &pods.Items[i]
+		}
+	}
+	if running == nil {
+		return nil, errors.Errorf("%s/%s does not have a running pod", namespace, jobName)
+	}
+	return running, nil
+}
@@ -0,0 +1,49 @@
+package handbrake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// presetFile is the format of a HandBrake preset export, e.g. presets.json.
+type presetFile struct {
+	PresetList []preset
+}
+
+type preset struct {
+	PresetName    string
+	Folder        bool
+	ChildrenArray []preset
+}
+
+// LoadPresetNames reads the names of the presets defined in a HandBrake
+// preset file, including presets nested in folders.
+func LoadPresetNames(path string) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", path)
+	}
+
+	var f presetFile
+	err = json.Unmarshal(contents, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse the HandBrake presets in %s", path)
+	}
+
+	var names []string
+	var walk func([]preset)
+	walk = func(presets []preset) {
+		for _, p := range presets {
+			if p.Folder {
+				walk(p.ChildrenArray)
+				continue
+			}
+			names = append(names, p.PresetName)
+		}
+	}
+	walk(f.PresetList)
+
+	return names, nil
+}
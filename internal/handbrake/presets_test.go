@@ -0,0 +1,14 @@
+package handbrake
+
+import "testing"
+
+func TestLoadPresetNames(t *testing.T) {
+	names, err := LoadPresetNames("../../cmd/handbrakecli/presets.json")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if len(names) != 1 || names[0] != "tivo" {
+		t.Fatalf("expected the tivo preset, got %v", names)
+	}
+}
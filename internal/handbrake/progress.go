@@ -0,0 +1,97 @@
+package handbrake
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress of an encode, as reported by HandBrakeCLI.
+type Progress struct {
+	// Task is the current task, starting at 1.
+	Task int `json:"task"`
+
+	// TaskCount is the total number of tasks in the encode.
+	TaskCount int `json:"taskCount"`
+
+	// Percent complete of the current task.
+	Percent float64 `json:"percent"`
+
+	// FPS is the current frames encoded per second.
+	FPS float64 `json:"fps,omitempty"`
+
+	// AvgFPS is the average frames encoded per second.
+	AvgFPS float64 `json:"avgFps,omitempty"`
+
+	// ETA is the estimated time remaining in the current task.
+	ETA time.Duration `json:"eta,omitempty"`
+
+	// Done is set when the encode has finished.
+	Done bool `json:"done,omitempty"`
+}
+
+// Example: Encoding: task 1 of 1, 42.17 % (87.3 fps, avg 90.1 fps, ETA 00h12m03s)
+// The fps and ETA are omitted until HandBrake has enough samples to calculate them.
+var progressRegex = regexp.MustCompile(`Encoding: task (\d+) of (\d+), (\d+(?:\.\d+)?) %(?: \((\d+(?:\.\d+)?) fps, avg (\d+(?:\.\d+)?) fps, ETA (\d+)h(\d+)m(\d+)s\))?`)
+
+const encodeDoneMessage = "Encode done!"
+
+// ParseProgress reads the progress from a line of HandBrakeCLI output.
+func ParseProgress(line string) (Progress, bool) {
+	if strings.Contains(line, encodeDoneMessage) {
+		return Progress{Percent: 100, Done: true}, true
+	}
+
+	match := progressRegex.FindStringSubmatch(line)
+	if match == nil {
+		return Progress{}, false
+	}
+
+	var p Progress
+	p.Task, _ = strconv.Atoi(match[1])
+	p.TaskCount, _ = strconv.Atoi(match[2])
+	p.Percent, _ = strconv.ParseFloat(match[3], 64)
+	if match[4] != "" {
+		p.FPS, _ = strconv.ParseFloat(match[4], 64)
+		p.AvgFPS, _ = strconv.ParseFloat(match[5], 64)
+
+		hours, _ := strconv.Atoi(match[6])
+		minutes, _ := strconv.Atoi(match[7])
+		seconds, _ := strconv.Atoi(match[8])
+		p.ETA = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+	}
+
+	return p, true
+}
+
+// ReadProgress parses HandBrakeCLI output, calling onProgress for each
+// progress update, until the reader is exhausted.
+func ReadProgress(r io.Reader, onProgress func(Progress)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanOutputLines)
+	for scanner.Scan() {
+		if p, ok := ParseProgress(scanner.Text()); ok {
+			onProgress(p)
+		}
+	}
+	return scanner.Err()
+}
+
+// scanOutputLines splits on both carriage returns and newlines, since
+// HandBrakeCLI overwrites its progress line with a carriage return.
+func scanOutputLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[0:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
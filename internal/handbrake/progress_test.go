@@ -0,0 +1,87 @@
+package handbrake
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Recorded from HandBrakeCLI 1.2.0, which overwrites the progress line with a carriage return.
+const recordedOutput = "[15:06:21] starting job\n" +
+	"Encoding: task 1 of 1, 0.01 %\r" +
+	"Encoding: task 1 of 1, 0.52 %\r" +
+	"Encoding: task 1 of 1, 1.04 % (92.4 fps, avg 95.0 fps, ETA 00h21m18s)\r" +
+	"Encoding: task 1 of 1, 42.17 % (87.3 fps, avg 90.1 fps, ETA 00h12m03s)\r" +
+	"Encoding: task 1 of 1, 99.98 % (101.2 fps, avg 91.7 fps, ETA 00h00m00s)\r\n" +
+	"[15:28:45] work: average encoding speed for job is 91.713425 fps\n" +
+	"[15:28:45] mux: track 0, 131130 frames, 1416357520 bytes, 3429.91 kbps, fifo 256\n" +
+	"[15:28:46] libhb: work result = 0\n" +
+	"\n" +
+	"Encode done!\n" +
+	"\n" +
+	"HandBrake has exited.\n"
+
+func TestParseProgress(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Line   string
+		WantOK bool
+		Want   Progress
+	}{
+		{
+			Name:   "full",
+			Line:   "Encoding: task 1 of 1, 42.17 % (87.3 fps, avg 90.1 fps, ETA 00h12m03s)",
+			WantOK: true,
+			Want:   Progress{Task: 1, TaskCount: 1, Percent: 42.17, FPS: 87.3, AvgFPS: 90.1, ETA: 12*time.Minute + 3*time.Second},
+		},
+		{
+			Name:   "no estimate yet",
+			Line:   "Encoding: task 2 of 2, 0.52 %",
+			WantOK: true,
+			Want:   Progress{Task: 2, TaskCount: 2, Percent: 0.52},
+		},
+		{
+			Name:   "done",
+			Line:   "Encode done!",
+			WantOK: true,
+			Want:   Progress{Percent: 100, Done: true},
+		},
+		{
+			Name: "log message",
+			Line: "[15:28:46] libhb: work result = 0",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			got, ok := ParseProgress(tc.Line)
+			if ok != tc.WantOK {
+				t.Fatalf("expected ok to be %v, got %v", tc.WantOK, ok)
+			}
+			if got != tc.Want {
+				t.Fatalf("expected %#v, got %#v", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	var got []Progress
+	err := ReadProgress(strings.NewReader(recordedOutput), func(p Progress) {
+		got = append(got, p)
+	})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if len(got) != 6 {
+		t.Fatalf("expected 6 progress updates, got %d: %#v", len(got), got)
+	}
+	if got[3].Percent != 42.17 || got[3].ETA != 12*time.Minute+3*time.Second {
+		t.Fatalf("expected the progress to be parsed, got %#v", got[3])
+	}
+	if !got[5].Done {
+		t.Fatalf("expected the last update to be done, got %#v", got[5])
+	}
+}
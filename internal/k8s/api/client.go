@@ -1,17 +1,68 @@
 package api
 
 import (
+	"flag"
+	"os"
+
 	"github.com/pkg/errors"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// GetCurrentClusterClient gets a client for the current cluster upon which
-// we are currently executing upon. Only works when running in a cluster.
-func GetCurrentClusterClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+// ClientConfig selects the cluster that a client connects to.
+type ClientConfig struct {
+	// Kubeconfig is the path to a kubeconfig file. Defaults to the KUBECONFIG
+	// environment variable, and then ~/.kube/config when not running in a cluster.
+	Kubeconfig string
+
+	// Context is the kubeconfig context to use, defaults to the current context.
+	Context string
+}
+
+// DefaultClientConfig is used by GetClient, and is set by the flags
+// registered with AddFlags.
+var DefaultClientConfig ClientConfig
+
+// AddFlags registers the -kubeconfig and -context flags.
+func (c *ClientConfig) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig,
+		"Path to a kubeconfig, only required when running outside of a cluster and not using KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&c.Context, "context", c.Context, "Kubeconfig context to use, defaults to the current context")
+}
+
+// RESTConfig builds the configuration for a client. When a kubeconfig or
+// context is specified, either by the flags or the KUBECONFIG environment
+// variable, the kubeconfig is used. Otherwise the in-cluster configuration
+// is used, falling back to ~/.kube/config when not running in a cluster.
+func (c ClientConfig) RESTConfig() (*rest.Config, error) {
+	_, hasKubeconfigEnv := os.LookupEnv(clientcmd.RecommendedConfigPathEnvVar)
+	if c.Kubeconfig == "" && c.Context == "" && !hasKubeconfigEnv {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			return config, nil
+		}
+		if err != rest.ErrNotInCluster {
+			return nil, errors.Wrap(err, "unable to retrieve the current cluster's configuration")
+		}
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: c.Context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load the kubeconfig")
+	}
+	return config, nil
+}
+
+// NewClient creates a client for the configured cluster.
+func (c ClientConfig) NewClient() (*kubernetes.Clientset, error) {
+	config, err := c.RESTConfig()
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to retrieve the current cluster's configuration")
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
@@ -21,3 +72,30 @@ func GetCurrentClusterClient() (*kubernetes.Clientset, error) {
 
 	return clientset, nil
 }
+
+// NewDynamicClient creates a client for custom resources on the configured cluster.
+func (c ClientConfig) NewDynamicClient() (dynamic.Interface, error) {
+	config, err := c.RESTConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create a kubernetes dynamic client")
+	}
+
+	return client, nil
+}
+
+// GetClient creates a client for the cluster selected by DefaultClientConfig,
+// which is the current cluster when running in a cluster.
+func GetClient() (*kubernetes.Clientset, error) {
+	return DefaultClientConfig.NewClient()
+}
+
+// GetDynamicClient creates a client for custom resources on the cluster
+// selected by DefaultClientConfig.
+func GetDynamicClient() (dynamic.Interface, error) {
+	return DefaultClientConfig.NewDynamicClient()
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: laptop
+  cluster:
+    server: https://127.0.0.1:6443
+- name: remote
+  cluster:
+    server: https://k8s.example.com:6443
+users:
+- name: admin
+  user:
+    token: abc123
+contexts:
+- name: laptop
+  context:
+    cluster: laptop
+    user: admin
+- name: remote
+  context:
+    cluster: remote
+    user: admin
+current-context: laptop
+`
+
+func writeTestKubeconfig(t *testing.T) (path string, cleanup func()) {
+	tmpDir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	path = filepath.Join(tmpDir, "config")
+	err = ioutil.WriteFile(path, []byte(testKubeconfig), 0600)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return path, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestClientConfig_RESTConfig(t *testing.T) {
+	kubeconfig, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	testcases := []struct {
+		name     string
+		cfg      ClientConfig
+		env      string
+		wantHost string
+	}{
+		{"explicit kubeconfig", ClientConfig{Kubeconfig: kubeconfig}, "", "https://127.0.0.1:6443"},
+		{"explicit context", ClientConfig{Kubeconfig: kubeconfig, Context: "remote"}, "", "https://k8s.example.com:6443"},
+		{"KUBECONFIG", ClientConfig{}, kubeconfig, "https://127.0.0.1:6443"},
+		{"KUBECONFIG with context", ClientConfig{Context: "remote"}, kubeconfig, "https://k8s.example.com:6443"},
+	}
+
+	original, hadEnv := os.LookupEnv("KUBECONFIG")
+	defer func() {
+		if hadEnv {
+			os.Setenv("KUBECONFIG", original)
+		} else {
+			os.Unsetenv("KUBECONFIG")
+		}
+	}()
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env == "" {
+				os.Unsetenv("KUBECONFIG")
+			} else {
+				os.Setenv("KUBECONFIG", tc.env)
+			}
+
+			config, err := tc.cfg.RESTConfig()
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if config.Host != tc.wantHost {
+				t.Fatalf("expected %s, got %s", tc.wantHost, config.Host)
+			}
+		})
+	}
+}
+
+func TestClientConfig_RESTConfig_MissingContext(t *testing.T) {
+	kubeconfig, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	cfg := ClientConfig{Kubeconfig: kubeconfig, Context: "missing"}
+	_, err := cfg.RESTConfig()
+	if err == nil {
+		t.Fatal("expected an error for a context that isn't in the kubeconfig")
+	}
+}
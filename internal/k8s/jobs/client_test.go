@@ -0,0 +1,546 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "handbrk8s"
+
+func buildTestJob(name string, labels map[string]string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+			Labels:    labels,
+		},
+	}
+}
+
+// newWatchedClient creates a fake clientset, and a channel that is closed
+// once a watch is established, so that tests don't update a job before the
+// client is watching for the change.
+func newWatchedClient(objects ...runtime.Object) (*fake.Clientset, <-chan struct{}) {
+	client := fake.NewSimpleClientset(objects...)
+	watching := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		once.Do(func() { close(watching) })
+		return true, w, nil
+	})
+	return client, watching
+}
+
+func waitFor(t *testing.T, c <-chan struct{}, what string) {
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestClient_CreateFromTemplate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := NewClient(client)
+
+	template := `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: "{{.Name}}-transcode"
+  namespace: handbrk8s
+spec:
+  template:
+    spec:
+      containers:
+      - name: handbrake
+        image: handbrake
+`
+	name, err := c.CreateFromTemplate(template, struct{ Name string }{"foo"})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if name != "foo-transcode" {
+		t.Fatalf("expected foo-transcode, got %s", name)
+	}
+
+	_, err = client.BatchV1().Jobs(testNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the job to be created: %v", err)
+	}
+}
+
+func TestClient_CreateOrReplace_Conflict(t *testing.T) {
+	existing := buildTestJob("foo", map[string]string{"version": "1"})
+	client := fake.NewSimpleClientset(existing)
+	c := NewClient(client)
+
+	_, err := c.CreateOrReplace(buildTestJob("foo", map[string]string{"version": "2"}), CreateOptions{Policy: AlwaysReplace})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j, err := client.BatchV1().Jobs(testNamespace).Get(context.TODO(), "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if j.Labels["version"] != "2" {
+		t.Fatalf("expected the existing job to be replaced, got version %s", j.Labels["version"])
+	}
+
+	var deletes int
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "delete" {
+			deletes++
+		}
+	}
+	if deletes != 1 {
+		t.Fatalf("expected the existing job to be deleted once, got %d deletes", deletes)
+	}
+}
+
+func TestClient_CreateOrReplace_Policy(t *testing.T) {
+	running := batchv1.JobStatus{Active: 1}
+	finished := batchv1.JobStatus{
+		Failed:     1,
+		Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+	}
+
+	testcases := []struct {
+		name        string
+		policy      ReplacePolicy
+		status      batchv1.JobStatus
+		wantReplace bool
+	}{
+		{"default policy leaves running job", "", running, false},
+		{"replace finished job", ReplaceIfFinished, finished, true},
+		{"leave running job", ReplaceIfFinished, running, false},
+		{"fail if finished job exists", FailIfExists, finished, false},
+		{"fail if running job exists", FailIfExists, running, false},
+		{"always replace running job", AlwaysReplace, running, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			existing := buildTestJob("foo", map[string]string{"version": "1"})
+			existing.Status = tc.status
+			client := fake.NewSimpleClientset(existing)
+			c := NewClient(client)
+
+			_, err := c.CreateOrReplace(buildTestJob("foo", map[string]string{"version": "2"}), CreateOptions{Policy: tc.policy})
+			if tc.wantReplace && err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if !tc.wantReplace && !IsJobExists(err) {
+				t.Fatalf("expected a JobExistsError, got %#v", err)
+			}
+
+			j, err := client.BatchV1().Jobs(testNamespace).Get(context.TODO(), "foo", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if replaced := j.Labels["version"] == "2"; replaced != tc.wantReplace {
+				t.Fatalf("expected replaced to be %t, got version %s", tc.wantReplace, j.Labels["version"])
+			}
+		})
+	}
+}
+
+func TestClient_CreateOrReplace_Retries(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var creates int32
+	client.PrependReactor("create", "jobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&creates, 1)
+		return true, nil, apierrors.NewAlreadyExists(schema.GroupResource{Group: "batch", Resource: "jobs"}, "foo")
+	})
+	c := NewClient(client)
+
+	_, err := c.CreateOrReplace(buildTestJob("foo", nil), CreateOptions{Policy: AlwaysReplace, Retries: 2})
+	if err == nil {
+		t.Fatal("expected CreateOrReplace to give up")
+	}
+	if got := atomic.LoadInt32(&creates); got != 3 {
+		t.Fatalf("expected the job to be created once and retried twice, got %d creates", got)
+	}
+}
+
+func TestReplacePolicy_Set(t *testing.T) {
+	var p ReplacePolicy
+	err := p.Set("fail-if-exists")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if p != FailIfExists {
+		t.Fatalf("expected fail-if-exists, got %s", p)
+	}
+
+	err = p.Set("replace-sometimes")
+	if err == nil {
+		t.Fatal("expected an invalid policy to be rejected")
+	}
+}
+
+func TestClient_CreateOrReplace_NameConflict(t *testing.T) {
+	existing := buildTestJob("foo", nil)
+	existing.Annotations = map[string]string{SourceAnnotation: "TV/Show A/foo.mkv"}
+	client := fake.NewSimpleClientset(existing)
+	c := NewClient(client)
+
+	j := buildTestJob("foo", nil)
+	j.Annotations = map[string]string{SourceAnnotation: "TV/Show-A/foo.mkv"}
+	// Even when replacing running jobs is allowed
+	_, err := c.CreateOrReplace(j, CreateOptions{Policy: AlwaysReplace})
+	if !IsNameConflict(err) {
+		t.Fatalf("expected a NameConflictError, got %#v", err)
+	}
+
+	got, err := client.BatchV1().Jobs(testNamespace).Get(context.TODO(), "foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if got.Annotations[SourceAnnotation] != "TV/Show A/foo.mkv" {
+		t.Fatalf("expected the existing job to be kept, got %#v", got.Annotations)
+	}
+}
+
+func TestClient_Delete(t *testing.T) {
+	client := fake.NewSimpleClientset(buildTestJob("foo", nil))
+	c := NewClient(client)
+
+	err := c.Delete("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = client.BatchV1().Jobs(testNamespace).Get(context.TODO(), "foo", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the job to be deleted, got %v", err)
+	}
+
+	// Deleting a missing job is not an error
+	err = c.Delete("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestClient_WaitUntilDeleted(t *testing.T) {
+	client, watching := newWatchedClient(buildTestJob("foo", nil))
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	errChan := c.WaitUntilDeleted(done, testNamespace, "foo")
+	waitFor(t, watching, "the watch")
+
+	select {
+	case err := <-errChan:
+		t.Fatalf("expected to wait until the job was deleted, got %v", err)
+	default:
+	}
+
+	err := client.BatchV1().Jobs(testNamespace).Delete(context.TODO(), "foo", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case err, ok := <-errChan:
+		if ok {
+			t.Fatalf("%#v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to be deleted")
+	}
+}
+
+func TestClient_WaitUntilDeleted_AlreadyDeleted(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset())
+
+	select {
+	case err, ok := <-c.WaitUntilDeleted(nil, testNamespace, "foo"):
+		if ok {
+			t.Fatalf("%#v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a missing job to already be deleted")
+	}
+}
+
+func TestClient_WaitUntilComplete(t *testing.T) {
+	client, watching := newWatchedClient(buildTestJob("foo", nil))
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+	waitFor(t, watching, "the watch")
+
+	j := buildTestJob("foo", nil)
+	j.Status.Active = 1
+	_, err := client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j.Status.Active = 0
+	j.Status.Succeeded = 1
+	_, err = client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case result := <-resultChan:
+		if !result.Succeeded() || result.Job.Status.Succeeded != 1 {
+			t.Fatalf("expected the job to be sent once it succeeded, got %#v", result)
+		}
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to complete")
+	}
+}
+
+func TestClient_WaitUntilComplete_Failed(t *testing.T) {
+	client, watching := newWatchedClient(buildTestJob("foo", nil))
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+	waitFor(t, watching, "the watch")
+
+	// A failed pod is retried, so the job isn't finished until it has the failed condition
+	j := buildTestJob("foo", nil)
+	j.Status.Failed = 1
+	_, err := client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j.Status.Failed = 2
+	j.Status.Conditions = []batchv1.JobCondition{{
+		Type:    batchv1.JobFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	}}
+	_, err = client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.Outcome != Failed || result.Reason != "BackoffLimitExceeded" {
+			t.Fatalf("expected the job to fail with BackoffLimitExceeded, got %#v", result)
+		}
+		if result.Error() == nil {
+			t.Fatal("expected the failed result to explain why the job failed")
+		}
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to fail")
+	}
+}
+
+func TestResultOf(t *testing.T) {
+	condition := func(conditionType batchv1.JobConditionType) []batchv1.JobCondition {
+		return []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+	}
+
+	testcases := []struct {
+		name         string
+		status       batchv1.JobStatus
+		wantFinished bool
+		wantOutcome  Outcome
+	}{
+		{"pending", batchv1.JobStatus{}, false, ""},
+		{"running", batchv1.JobStatus{Active: 1, Failed: 3}, false, ""},
+		{"complete", batchv1.JobStatus{Succeeded: 1, Conditions: condition(batchv1.JobComplete)}, true, Succeeded},
+		{"failed", batchv1.JobStatus{Failed: 4, Conditions: condition(batchv1.JobFailed)}, true, Failed},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			j := buildTestJob("foo", nil)
+			j.Status = tc.status
+
+			result, finished := ResultOf(j)
+			if finished != tc.wantFinished {
+				t.Fatalf("expected finished to be %t", tc.wantFinished)
+			}
+			if result.Outcome != tc.wantOutcome {
+				t.Fatalf("expected %q, got %q", tc.wantOutcome, result.Outcome)
+			}
+		})
+	}
+}
+
+func waitForResult(t *testing.T, resultChan <-chan Result, errChan <-chan error) Result {
+	select {
+	case result := <-resultChan:
+		return result
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to finish")
+	}
+	return Result{}
+}
+
+func TestClient_WaitUntilComplete_AlreadyFinished(t *testing.T) {
+	j := buildTestJob("foo", nil)
+	j.Status.Succeeded = 1
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	c := NewClient(fake.NewSimpleClientset(j))
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+	result := waitForResult(t, resultChan, errChan)
+	if !result.Succeeded() {
+		t.Fatalf("expected the job to have already succeeded, got %#v", result)
+	}
+}
+
+func TestClient_WaitUntilComplete_Deleted(t *testing.T) {
+	client, watching := newWatchedClient(buildTestJob("foo", nil))
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+	waitFor(t, watching, "the watch")
+
+	err := client.BatchV1().Jobs(testNamespace).Delete(context.TODO(), "foo", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	result := waitForResult(t, resultChan, errChan)
+	if result.Outcome != Deleted || result.Job == nil || result.Job.Name != "foo" {
+		t.Fatalf("expected the deleted job to be reported, got %#v", result)
+	}
+}
+
+func TestClient_WaitUntilComplete_WatchClosed(t *testing.T) {
+	client := fake.NewSimpleClientset(buildTestJob("foo", nil))
+	closedWatch := watch.NewFake()
+	firstWatch := make(chan struct{})
+	rewatched := make(chan struct{})
+	var watches int32
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		switch atomic.AddInt32(&watches, 1) {
+		case 1:
+			close(firstWatch)
+			return true, closedWatch, nil
+		case 2:
+			defer close(rewatched)
+		}
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		return true, w, err
+	})
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+	waitFor(t, firstWatch, "the first watch")
+
+	// The API server periodically ends watches
+	closedWatch.Stop()
+	waitFor(t, rewatched, "the watch to be resumed")
+
+	j := buildTestJob("foo", nil)
+	j.Status.Succeeded = 1
+	_, err := client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	result := waitForResult(t, resultChan, errChan)
+	if !result.Succeeded() {
+		t.Fatalf("expected the job to succeed after the watch was resumed, got %#v", result)
+	}
+}
+
+func TestClient_WaitUntilComplete_Forbidden(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "jobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "batch", Resource: "jobs"}, "", nil)
+	})
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	_, errChan := c.WaitUntilComplete(done, testNamespace, "foo", WaitOptions{})
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("expected an error when we aren't allowed to watch jobs")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the forbidden error")
+	}
+}
+
+func TestClient_WaitUntilComplete_NotFound(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset())
+
+	done := make(chan struct{})
+	defer close(done)
+	opts := WaitOptions{RequireCreatedWithin: 100 * time.Millisecond}
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", opts)
+
+	result := waitForResult(t, resultChan, errChan)
+	if result.Outcome != NotFound || result.Name != "foo" || result.Job != nil {
+		t.Fatalf("expected the job to not be found, got %#v", result)
+	}
+}
+
+func TestClient_WaitUntilComplete_CreatedWithin(t *testing.T) {
+	client, watching := newWatchedClient()
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	opts := WaitOptions{RequireCreatedWithin: 500 * time.Millisecond, PollInterval: time.Second}
+	resultChan, errChan := c.WaitUntilComplete(done, testNamespace, "foo", opts)
+	waitFor(t, watching, "the watch")
+
+	_, err := client.BatchV1().Jobs(testNamespace).Create(context.TODO(), buildTestJob("foo", nil), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Keep waiting after the deadline, now that the job exists
+	select {
+	case result := <-resultChan:
+		t.Fatalf("expected to wait for the job to finish, got %#v", result)
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(2 * opts.RequireCreatedWithin):
+	}
+}
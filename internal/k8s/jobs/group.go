@@ -0,0 +1,367 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WaitMode determines when a wait on several jobs is finished.
+type WaitMode string
+
+const (
+	// WaitForAll finishes once every job has succeeded, or as soon as any job fails.
+	WaitForAll WaitMode = "all"
+
+	// WaitForAny finishes as soon as any job succeeds, or once every job has failed.
+	WaitForAny WaitMode = "any"
+)
+
+// JobSet selects the jobs to wait on, by name, label selector, or both.
+type JobSet struct {
+	// Names of the jobs, every named job is expected to be created.
+	Names []string
+
+	// Selector matches jobs by their labels. At least one job is expected
+	// to match the selector.
+	Selector labels.Selector
+}
+
+// Validate checks that the set selects at least one job.
+func (s JobSet) Validate() error {
+	if len(s.Names) == 0 && (s.Selector == nil || s.Selector.Empty()) {
+		return errors.New("at least one job name or a label selector is required")
+	}
+	return nil
+}
+
+// Matches checks if a job is in the set.
+func (s JobSet) Matches(j *batchv1.Job) bool {
+	return s.hasName(j.Name) || s.matchesSelector(j)
+}
+
+func (s JobSet) hasName(name string) bool {
+	for _, n := range s.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s JobSet) matchesSelector(j *batchv1.Job) bool {
+	return s.Selector != nil && !s.Selector.Empty() && s.Selector.Matches(labels.Set(j.Labels))
+}
+
+func (s JobSet) String() string {
+	var parts []string
+	if len(s.Names) > 0 {
+		parts = append(parts, "jobs "+strings.Join(s.Names, ", "))
+	}
+	if s.Selector != nil && !s.Selector.Empty() {
+		parts = append(parts, "jobs matching "+s.Selector.String())
+	}
+	return strings.Join(parts, " and ")
+}
+
+// jobFilter limits the jobs that are listed and watched.
+type jobFilter struct {
+	FieldSelector, LabelSelector string
+}
+
+// listOptions filters the jobs on the server when possible. Otherwise every
+// job in the namespace is watched, and filtered with Matches.
+func (s JobSet) listOptions() jobFilter {
+	hasSelector := s.Selector != nil && !s.Selector.Empty()
+	switch {
+	case len(s.Names) == 1 && !hasSelector:
+		return jobFilter{FieldSelector: fields.OneTermEqualSelector("metadata.name", s.Names[0]).String()}
+	case len(s.Names) == 0 && hasSelector:
+		return jobFilter{LabelSelector: s.Selector.String()}
+	default:
+		return jobFilter{}
+	}
+}
+
+// GroupResult describes a finished wait on a set of jobs.
+type GroupResult struct {
+	Outcome Outcome
+
+	// Reason and Message explain why the wait failed, and are copied from
+	// the result that decided the outcome.
+	Reason, Message string
+
+	// Results of each job that finished, sorted by name. Jobs that were not
+	// created in time are included with the NotFound outcome.
+	Results []Result
+}
+
+// Succeeded checks if the wait completed successfully.
+func (r GroupResult) Succeeded() bool {
+	return r.Outcome == Succeeded
+}
+
+// Error explains why the wait failed, and is nil when it succeeded.
+func (r GroupResult) Error() error {
+	if r.Succeeded() {
+		return nil
+	}
+	for _, result := range r.Results {
+		if result.Outcome == r.Outcome && result.Reason == r.Reason {
+			return result.Error()
+		}
+	}
+	return errors.Errorf("%s: %s %s", r.Outcome, r.Reason, r.Message)
+}
+
+// WaitForJobs sends the result of waiting on a set of jobs once the wait
+// mode is satisfied. The jobs don't need to exist yet, unless
+// opts.RequireCreatedWithin is set. An error is sent when we aren't allowed
+// to watch the jobs.
+func (c Client) WaitForJobs(done <-chan struct{}, namespace string, set JobSet, mode WaitMode, opts WaitOptions) (<-chan GroupResult, <-chan error) {
+	resultChan := make(chan GroupResult)
+	errChan := make(chan error)
+
+	go func() {
+		defer close(resultChan)
+		defer close(errChan)
+
+		sendErr := func(err error) {
+			select {
+			case <-done:
+			case errChan <- err:
+			}
+		}
+
+		if err := set.Validate(); err != nil {
+			sendErr(err)
+			return
+		}
+		if mode != WaitForAll && mode != WaitForAny {
+			sendErr(errors.Errorf("invalid wait mode %q, must be %s or %s", mode, WaitForAll, WaitForAny))
+			return
+		}
+
+		stop := make(chan struct{})
+		defer close(stop)
+		w := c.watchJobs(stop, namespace, set, 0)
+
+		// Read the jobs from the API server in case a change is missed
+		var poll <-chan time.Time
+		if opts.PollInterval > 0 {
+			ticker := time.NewTicker(opts.PollInterval)
+			defer ticker.Stop()
+			poll = ticker.C
+		}
+
+		// Give up on jobs that aren't created in time
+		var notCreated <-chan time.Time
+		if opts.RequireCreatedWithin > 0 {
+			timer := time.NewTimer(opts.RequireCreatedWithin)
+			defer timer.Stop()
+			notCreated = timer.C
+		}
+
+		// Don't decide anything until every existing job is known, otherwise
+		// a selector could be satisfied by the first job that we are told about
+		synced := make(chan struct{})
+		go func() {
+			defer close(synced)
+			cache.WaitForCacheSync(stop, w.informer.HasSynced)
+		}()
+
+		g := jobGroup{
+			namespace:     namespace,
+			set:           set,
+			mode:          mode,
+			createdWithin: opts.RequireCreatedWithin,
+			states:        make(map[string]jobChange),
+		}
+		store := w.informer.GetStore()
+		for {
+			select {
+			case <-done:
+				return
+			case err := <-w.errs:
+				sendErr(err)
+				return
+			case <-notCreated:
+				notCreated = nil
+				g.deadlinePassed = true
+			case <-synced:
+				synced = nil
+				for _, obj := range store.List() {
+					if j, ok := obj.(*batchv1.Job); ok && set.Matches(j) {
+						g.states[j.Name] = jobChange{job: j}
+					}
+				}
+			case change := <-w.changes:
+				g.observe(store, change)
+			case <-poll:
+				if synced != nil {
+					continue
+				}
+				current, err := c.listJobs(namespace, set)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				for i := range current {
+					if set.Matches(&current[i]) {
+						g.states[current[i].Name] = jobChange{job: &current[i]}
+					}
+				}
+			}
+			if synced != nil {
+				continue
+			}
+
+			result, finished, waiting := g.evaluate()
+			if finished {
+				select {
+				case <-done:
+				case resultChan <- result:
+				}
+				return
+			}
+			log.Printf("waiting on %d of %s to finish", waiting, set)
+		}
+	}()
+
+	return resultChan, errChan
+}
+
+// listJobs reads the jobs in the set from the API server, rather than from
+// the informer's cache.
+func (c Client) listJobs(namespace string, set JobSet) ([]batchv1.Job, error) {
+	filter := set.listOptions()
+	list, err := c.clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: filter.FieldSelector,
+		LabelSelector: filter.LabelSelector,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list %s in %s", set, namespace)
+	}
+	return list.Items, nil
+}
+
+// jobGroup tracks the state of each job in a set.
+type jobGroup struct {
+	namespace     string
+	set           JobSet
+	mode          WaitMode
+	createdWithin time.Duration
+
+	// deadlinePassed is set once the jobs were required to be created.
+	deadlinePassed bool
+
+	// states is the latest state of each job, by name.
+	states map[string]jobChange
+}
+
+// observe records a change to a job. Notifications can lag behind the
+// informer's store, so the store is preferred, and a job that is no longer
+// in the store is left alone until its deletion is observed.
+func (g jobGroup) observe(store cache.Store, change jobChange) {
+	if change.deleted {
+		g.states[change.job.Name] = change
+		return
+	}
+	obj, exists, _ := store.Get(change.job)
+	if j, ok := obj.(*batchv1.Job); exists && ok {
+		g.states[j.Name] = jobChange{job: j}
+	}
+}
+
+// evaluate determines if the wait is finished, and if not, how many jobs
+// we are waiting on.
+func (g jobGroup) evaluate() (result GroupResult, finished bool, waiting int) {
+	var results []Result
+	classify := func(change jobChange) {
+		if change.deleted {
+			results = append(results, deletedResult(change.job))
+			return
+		}
+		if r, ok := ResultOf(change.job); ok {
+			results = append(results, r)
+			return
+		}
+		waiting++
+	}
+	notFound := func(name string) {
+		if g.deadlinePassed {
+			results = append(results, notFoundResult(g.namespace, name, g.createdWithin))
+			return
+		}
+		waiting++
+	}
+
+	for _, name := range g.set.Names {
+		if change, ok := g.states[name]; ok {
+			classify(change)
+		} else {
+			notFound(name)
+		}
+	}
+
+	names := make([]string, 0, len(g.states))
+	for name := range g.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selectorMatched := false
+	for _, name := range names {
+		change := g.states[name]
+		if g.set.matchesSelector(change.job) {
+			selectorMatched = true
+		}
+		if !g.set.hasName(name) {
+			classify(change)
+		}
+	}
+	if g.set.Selector != nil && !g.set.Selector.Empty() && !selectorMatched {
+		notFound(g.set.Selector.String())
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	result = GroupResult{Results: results}
+
+	decide := func(decisive Result) (GroupResult, bool, int) {
+		result.Outcome = decisive.Outcome
+		result.Reason = decisive.Reason
+		result.Message = decisive.Message
+		return result, true, waiting
+	}
+
+	switch g.mode {
+	case WaitForAll:
+		for _, r := range results {
+			if !r.Succeeded() {
+				return decide(r)
+			}
+		}
+		if waiting == 0 && len(results) > 0 {
+			return decide(Result{Outcome: Succeeded})
+		}
+	case WaitForAny:
+		for _, r := range results {
+			if r.Succeeded() {
+				return decide(Result{Outcome: Succeeded})
+			}
+		}
+		if waiting == 0 && len(results) > 0 {
+			return decide(results[0])
+		}
+	}
+
+	return GroupResult{}, false, waiting
+}
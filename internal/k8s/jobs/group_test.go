@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func buildFinishedJob(name string, conditionType batchv1.JobConditionType) *batchv1.Job {
+	j := buildTestJob(name, map[string]string{"video": "foo"})
+	j.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+	return j
+}
+
+func TestJobGroup_Evaluate(t *testing.T) {
+	running := jobChange{job: buildTestJob("running", map[string]string{"video": "foo"})}
+	succeeded := jobChange{job: buildFinishedJob("succeeded", batchv1.JobComplete)}
+	failed := jobChange{job: buildFinishedJob("failed", batchv1.JobFailed)}
+	deleted := jobChange{job: buildTestJob("deleted", nil), deleted: true}
+	selector := labels.SelectorFromSet(labels.Set{"video": "foo"})
+
+	testcases := []struct {
+		name           string
+		set            JobSet
+		mode           WaitMode
+		states         []jobChange
+		deadlinePassed bool
+		wantFinished   bool
+		wantOutcome    Outcome
+		wantWaiting    int
+	}{
+		{"all: still running", JobSet{Names: []string{"running", "succeeded"}}, WaitForAll, []jobChange{running, succeeded}, false, false, "", 1},
+		{"all: succeeded", JobSet{Names: []string{"succeeded"}}, WaitForAll, []jobChange{succeeded}, false, true, Succeeded, 0},
+		{"all: one failed", JobSet{Names: []string{"running", "failed"}}, WaitForAll, []jobChange{running, failed}, false, true, Failed, 1},
+		{"all: one deleted", JobSet{Names: []string{"succeeded", "deleted"}}, WaitForAll, []jobChange{succeeded, deleted}, false, true, Deleted, 0},
+		{"all: not created yet", JobSet{Names: []string{"succeeded", "missing"}}, WaitForAll, []jobChange{succeeded}, false, false, "", 1},
+		{"all: not created in time", JobSet{Names: []string{"succeeded", "missing"}}, WaitForAll, []jobChange{succeeded}, true, true, NotFound, 0},
+		{"any: one succeeded", JobSet{Names: []string{"running", "succeeded"}}, WaitForAny, []jobChange{running, succeeded}, false, true, Succeeded, 1},
+		{"any: one failed", JobSet{Names: []string{"running", "failed"}}, WaitForAny, []jobChange{running, failed}, false, false, "", 1},
+		{"any: all failed", JobSet{Names: []string{"deleted", "failed"}}, WaitForAny, []jobChange{deleted, failed}, false, true, Deleted, 0},
+		{"selector: nothing matched yet", JobSet{Selector: selector}, WaitForAll, nil, false, false, "", 1},
+		{"selector: nothing matched in time", JobSet{Selector: selector}, WaitForAll, nil, true, true, NotFound, 0},
+		{"selector: all succeeded", JobSet{Selector: selector}, WaitForAll, []jobChange{succeeded}, false, true, Succeeded, 0},
+		{"selector: still running", JobSet{Selector: selector}, WaitForAll, []jobChange{running, succeeded}, false, false, "", 1},
+		{"selector and names", JobSet{Names: []string{"deleted"}, Selector: selector}, WaitForAny, []jobChange{deleted, running}, false, false, "", 1},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := jobGroup{
+				namespace:      testNamespace,
+				set:            tc.set,
+				mode:           tc.mode,
+				deadlinePassed: tc.deadlinePassed,
+				states:         make(map[string]jobChange),
+			}
+			for _, change := range tc.states {
+				g.states[change.job.Name] = change
+			}
+
+			result, finished, waiting := g.evaluate()
+			if finished != tc.wantFinished {
+				t.Fatalf("expected finished to be %t, got %#v", tc.wantFinished, result)
+			}
+			if result.Outcome != tc.wantOutcome {
+				t.Fatalf("expected %q, got %q", tc.wantOutcome, result.Outcome)
+			}
+			if waiting != tc.wantWaiting {
+				t.Fatalf("expected to be waiting on %d jobs, got %d", tc.wantWaiting, waiting)
+			}
+		})
+	}
+}
+
+func TestClient_WaitForJobs_Selector(t *testing.T) {
+	transcode := buildTestJob("foo-transcode", map[string]string{"video": "foo"})
+	subtitles := buildTestJob("foo-subtitles", map[string]string{"video": "foo"})
+	other := buildTestJob("bar-transcode", map[string]string{"video": "bar"})
+	client, watching := newWatchedClient(transcode, subtitles, other)
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	set := JobSet{Selector: labels.SelectorFromSet(labels.Set{"video": "foo"})}
+	resultChan, errChan := c.WaitForJobs(done, testNamespace, set, WaitForAll, WaitOptions{})
+	waitFor(t, watching, "the watch")
+
+	for _, j := range []*batchv1.Job{transcode, subtitles} {
+		j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		_, err := client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	select {
+	case result := <-resultChan:
+		if !result.Succeeded() || len(result.Results) != 2 {
+			t.Fatalf("expected both jobs for the video to succeed, got %#v", result)
+		}
+		if result.Results[0].Name != "foo-subtitles" || result.Results[1].Name != "foo-transcode" {
+			t.Fatalf("expected the results to be sorted by name, got %#v", result.Results)
+		}
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the jobs to finish")
+	}
+}
+
+func TestClient_WaitForJobs_Poll(t *testing.T) {
+	client := fake.NewSimpleClientset(buildTestJob("foo", nil))
+	// The watch never reports a change, so only polling notices that the job finished
+	watching := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		once.Do(func() { close(watching) })
+		return true, watch.NewFake(), nil
+	})
+	c := NewClient(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	resultChan, errChan := c.WaitForJobs(done, testNamespace, JobSet{Names: []string{"foo"}}, WaitForAll, WaitOptions{PollInterval: 10 * time.Millisecond})
+	waitFor(t, watching, "the watch")
+
+	j := buildFinishedJob("foo", batchv1.JobComplete)
+	_, err := client.BatchV1().Jobs(testNamespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case result := <-resultChan:
+		if !result.Succeeded() {
+			t.Fatalf("expected the job to succeed, got %#v", result)
+		}
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out polling the job")
+	}
+}
+
+func TestClient_WaitForJobs_Invalid(t *testing.T) {
+	c := NewClient(nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	_, errChan := c.WaitForJobs(done, testNamespace, JobSet{}, WaitForAll, WaitOptions{})
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("expected an empty job set to be rejected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the validation error")
+	}
+}
@@ -2,40 +2,174 @@ package jobs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 	"github.com/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
-// SanitizeJobName replaces characters that aren't allowed in a k8s name with dashes.
+// MaxNameLength is the longest job name that can be used as a label value,
+// which the job controller does when it labels the job's pods.
+const MaxNameLength = 63
+
+// SourceAnnotation identifies what a job is processing, such as the path of
+// a video. CreateOrReplace only replaces an existing job with the same
+// source, so that sources whose names collide don't replace each other's jobs.
+const SourceAnnotation = "video-path"
+
+// hashLength is the number of characters of the hash suffix added to names
+// that are too long.
+const hashLength = 8
+
+var (
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+	repeatedDashes   = regexp.MustCompile(`-{2,}`)
+)
+
+// SanitizeJobName converts name into a valid job name, see SanitizeName.
 func SanitizeJobName(name string) string {
+	return SanitizeName(name, MaxNameLength)
+}
+
+// SanitizeName converts name into a valid k8s name of at most maxLength
+// characters. Characters that aren't allowed are replaced with dashes, and
+// names that are too long are truncated and suffixed with a hash of the
+// original name, so that they stay unique and are the same every time.
+func SanitizeName(name string, maxLength int) string {
+	sanitized := sanitize(name)
+	if sanitized != "" && len(sanitized) <= maxLength {
+		return sanitized
+	}
+	return HashedName(name, maxLength)
+}
+
+// HashedName converts name into a valid k8s name of at most maxLength
+// characters, like SanitizeName, but always suffixed with a hash of the
+// original name. Use it when the sanitized name is already taken by a
+// different name, e.g. "Show A" and "Show-A". When maxLength is shorter than
+// the hash, the hash is truncated to fit, and the name is empty when
+// maxLength isn't positive.
+func HashedName(name string, maxLength int) string {
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+	if maxLength <= 0 {
+		return ""
+	}
+	if maxLength < hashLength {
+		return hash[:maxLength]
+	}
+
+	prefix := sanitize(name)
+	if maxPrefix := maxLength - hashLength - 1; maxPrefix <= 0 {
+		prefix = ""
+	} else if len(prefix) > maxPrefix {
+		prefix = strings.TrimRight(prefix[:maxPrefix], "-")
+	}
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
 
+// sanitize lowercases a name, and replaces the characters that aren't allowed
+// in a k8s name with a single dash, for example TV/Show A/S01E01.mkv
+// becomes tv-show-a-s01e01-mkv.
+func sanitize(name string) string {
 	name = strings.ToLower(name)
-	re := regexp.MustCompile(`[^a-z0-9-]`)
-	return re.ReplaceAllString(name, "-")
+	name = invalidNameChars.ReplaceAllString(name, "-")
+	name = repeatedDashes.ReplaceAllString(name, "-")
+	return strings.Trim(name, "-")
 }
 
-// Delete a job.
-func Delete(name, namespace string) error {
-	log.Printf("deleting job: %s/%s", namespace, name)
-	clusterClient, err := api.GetCurrentClusterClient()
+// NameConflictError is returned when a resource, such as a job, can't be
+// created because its name is used by a resource for a different source.
+type NameConflictError struct {
+	Namespace, Name string
+
+	// Source of the existing resource.
+	Existing string
+
+	// Source of the resource that could not be created.
+	Requested string
+}
+
+func (e NameConflictError) Error() string {
+	return fmt.Sprintf("%s/%s is already used for %s, refusing to replace it with %s",
+		e.Namespace, e.Name, e.Existing, e.Requested)
+}
+
+// IsNameConflict checks if an error is a NameConflictError.
+func IsNameConflict(err error) bool {
+	_, ok := errors.Cause(err).(NameConflictError)
+	return ok
+}
+
+// HasSucceeded checks if a job has completed successfully.
+func HasSucceeded(j *batchv1.Job) bool {
+	return hasCondition(j, batchv1.JobComplete) || j.Status.Succeeded > 0
+}
+
+// HasFailed checks if a job has failed, and will not be retried.
+func HasFailed(j *batchv1.Job) bool {
+	return hasCondition(j, batchv1.JobFailed)
+}
+
+func hasCondition(j *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	return findCondition(j, conditionType) != nil
+}
+
+// findCondition returns the condition of the specified type when it is true.
+func findCondition(j *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i, c := range j.Status.Conditions {
+		if c.Type == conditionType && c.Status == corev1.ConditionTrue {
+			return &j.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// Client manages jobs on a cluster.
+type Client struct {
+	clientset kubernetes.Interface
+}
+
+// NewClient creates a job client backed by the specified clientset.
+func NewClient(clientset kubernetes.Interface) Client {
+	return Client{clientset: clientset}
+}
+
+// NewDefaultClient creates a job client for the cluster selected by
+// api.DefaultClientConfig.
+func NewDefaultClient() (Client, error) {
+	clientset, err := api.GetClient()
 	if err != nil {
-		return err
+		return Client{}, err
 	}
-	jobclient := clusterClient.BatchV1().Jobs(namespace)
+	return NewClient(clientset), nil
+}
+
+// Delete a job.
+func (c Client) Delete(name, namespace string) error {
+	log.Printf("deleting job: %s/%s", namespace, name)
+	jobclient := c.clientset.BatchV1().Jobs(namespace)
 
 	// Wait for the associated pods to delete
 	foreground := v1.DeletePropagationForeground
 	opts := v1.DeleteOptions{
 		PropagationPolicy: &foreground,
 	}
-	err = jobclient.Delete(context.TODO(), name, opts)
+	err := jobclient.Delete(context.TODO(), name, opts)
 	if !apierrors.IsNotFound(err) {
 		return errors.Wrapf(err, "unable to delete %s/%s", namespace, name)
 	}
@@ -43,47 +177,97 @@ func Delete(name, namespace string) error {
 	return nil
 }
 
-// CreateFromTemplate creates a job on the current cluster from a template
-// and set of replacement values.
-func CreateFromTemplate(yamlTemplate string, values interface{}) (jobName string, err error) {
+// CreateFromTemplate creates a job from a template and set of replacement
+// values, replacing an existing job according to DefaultReplacePolicy.
+func (c Client) CreateFromTemplate(yamlTemplate string, values interface{}) (jobName string, err error) {
 	j, err := BuildFromTemplate(yamlTemplate, values)
 	if err != nil {
 		return "", err
 	}
 
-	return CreateOrReplace(j)
+	return c.CreateOrReplace(j, CreateOptions{})
 }
 
-func CreateOrReplace(j *batchv1.Job) (jobName string, err error) {
-	clusterClient, err := api.GetCurrentClusterClient()
+// CreateOrReplace creates a job. When a job with the same name exists,
+// opts.Policy decides if it is deleted and the job created again, otherwise a
+// JobExistsError is returned. A NameConflictError is returned, instead of
+// replacing the existing job, when the jobs have different sources, see
+// SourceAnnotation.
+func (c Client) CreateOrReplace(j *batchv1.Job, opts CreateOptions) (jobName string, err error) {
+	opts = opts.withDefaults()
+	err = opts.Policy.Validate()
 	if err != nil {
 		return "", err
 	}
-	jobclient := clusterClient.BatchV1().Jobs(j.Namespace)
 
-	result, err := jobclient.Create(context.TODO(), j, v1.CreateOptions{})
-	if apierrors.IsAlreadyExists(err) {
-		delerr := Delete(j.Name, j.Namespace)
-		if delerr != nil {
-			return "", errors.Wrapf(delerr, "unable to delete existing job %s so that it can be recreated", j.Name)
+	jobclient := c.clientset.BatchV1().Jobs(j.Namespace)
+	for attempt := 0; ; attempt++ {
+		result, err := jobclient.Create(context.TODO(), j, v1.CreateOptions{})
+		if err == nil {
+			log.Printf("created job: %s", result.Name)
+			return result.Name, nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			yaml, _ := api.SerializeObject(j)
+			return "", errors.Wrapf(err, "unable to create job from:\n%s", yaml)
+		}
+		if attempt >= opts.Retries {
+			return "", errors.Errorf("unable to create %s/%s, it was recreated by someone else each of the %d times that it was replaced", j.Namespace, j.Name, opts.Retries)
 		}
 
-		errChan := WaitUntilDeleted(nil, j.Namespace, j.Name)
-		select {
-		case delerr, waiting := <-errChan:
-			if waiting && delerr != nil {
-				return "", errors.Wrapf(delerr, "unable to wait for the %s job to be deleted", j.Name)
-			}
+		err = c.replace(j, opts.Policy)
+		if err != nil {
+			return "", err
 		}
+	}
+}
+
+// replace deletes the existing job with the same name as j, when the policy
+// allows it, and waits for it to be deleted.
+func (c Client) replace(j *batchv1.Job, policy ReplacePolicy) error {
+	jobclient := c.clientset.BatchV1().Jobs(j.Namespace)
+	existing, err := jobclient.Get(context.TODO(), j.Name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// It was deleted after we tried to create the job, try again
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to check the existing job %s", j.Name)
+	}
 
-		return CreateOrReplace(j)
-	} else if err != nil {
-		yaml, _ := api.SerializeObject(j)
-		return "", errors.Wrapf(err, "unable to create job from:\n%s", yaml)
+	existingSource := existing.Annotations[SourceAnnotation]
+	source := j.Annotations[SourceAnnotation]
+	if existingSource != "" && source != "" && existingSource != source {
+		return NameConflictError{Namespace: j.Namespace, Name: j.Name, Existing: existingSource, Requested: source}
 	}
 
-	log.Printf("created job: %s", result.Name)
-	return result.Name, nil
+	existsErr := JobExistsError{Policy: policy, Existing: existing}
+	switch policy {
+	case FailIfExists:
+		return existsErr
+	case ReplaceIfFinished:
+		// A job that is being deleted is going away regardless
+		if existing.DeletionTimestamp == nil && !existsErr.Finished() {
+			return existsErr
+		}
+	}
+
+	err = c.Delete(j.Name, j.Namespace)
+	if err != nil {
+		return errors.Wrapf(err, "unable to delete existing job %s so that it can be recreated", j.Name)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	select {
+	case err, waiting := <-c.WaitUntilDeleted(done, j.Namespace, j.Name):
+		if waiting && err != nil {
+			return errors.Wrapf(err, "unable to wait for the %s job to be deleted", j.Name)
+		}
+	case <-time.After(deleteTimeout):
+		return errors.Errorf("timed out waiting for the %s job to be deleted", j.Name)
+	}
+	return nil
 }
 
 // BuildFromTemplate builds a job definition from a template
@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -28,3 +29,64 @@ spec:
 		t.Fatal("didn't deserialize into a job instance")
 	}
 }
+
+func TestSanitizeJobName(t *testing.T) {
+	longPath := "Movies/" + strings.Repeat("a", 100) + ".mkv"
+
+	testcases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "full path", in: "TV/ShowA/S01E01.mkv", want: "tv-showa-s01e01-mkv"},
+		{name: "different directory", in: "TV/ShowB/S01E01.mkv", want: "tv-showb-s01e01-mkv"},
+		{name: "repeated invalid characters", in: "Movies/Foo  (2017)/foo.mkv", want: "movies-foo-2017-foo-mkv"},
+		{name: "only invalid characters", in: "...", want: HashedName("...", MaxNameLength)},
+		{name: "too long", in: longPath, want: HashedName(longPath, MaxNameLength)},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := SanitizeJobName(tc.in)
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+			if len(got) > MaxNameLength {
+				t.Fatalf("expected at most %d characters, got %d", MaxNameLength, len(got))
+			}
+		})
+	}
+}
+
+func TestHashedName(t *testing.T) {
+	a := HashedName("TV/Show A/S01E01.mkv", MaxNameLength)
+	b := HashedName("TV/Show-A/S01E01.mkv", MaxNameLength)
+	if a == b {
+		t.Fatalf("expected names that sanitize the same to have different hashes, got %s", a)
+	}
+	if !strings.HasPrefix(a, "tv-show-a-s01e01-mkv-") {
+		t.Fatalf("expected the sanitized name to prefix the hash, got %s", a)
+	}
+	if a != HashedName("TV/Show A/S01E01.mkv", MaxNameLength) {
+		t.Fatal("expected the hashed name to be the same every time")
+	}
+
+	long := strings.Repeat("a", 100)
+	truncated := HashedName(long, 20)
+	if len(truncated) != 20 || !strings.HasPrefix(truncated, strings.Repeat("a", 11)+"-") {
+		t.Fatalf("expected the name to be truncated to fit the hash, got %s", truncated)
+	}
+	if HashedName(long+"1", 20) == HashedName(long+"2", 20) {
+		t.Fatal("expected truncated names to stay unique")
+	}
+
+	// The hash is truncated when it doesn't fit
+	short := HashedName(long, hashLength-3)
+	if len(short) != hashLength-3 || !strings.HasPrefix(HashedName(long, hashLength), short) {
+		t.Fatalf("expected the hash to be truncated to %d characters, got %s", hashLength-3, short)
+	}
+	if got := HashedName(long, 0); got != "" {
+		t.Fatalf("expected an empty name when there is no room, got %s", got)
+	}
+}
@@ -2,101 +2,339 @@ package jobs
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"time"
 
-	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 	"github.com/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 )
 
-func WaitUntilComplete(done <-chan struct{}, namespace, name string) (<-chan *batchv1.Job, <-chan error) {
-	jobChan := make(chan *batchv1.Job)
+// Outcome is how a job finished.
+type Outcome string
+
+const (
+	// Succeeded indicates that the job completed successfully.
+	Succeeded Outcome = "Succeeded"
+
+	// Failed indicates that the job failed, and will not be retried.
+	Failed Outcome = "Failed"
+
+	// Deleted indicates that the job was deleted before it finished.
+	Deleted Outcome = "Deleted"
+
+	// NotFound indicates that the job wasn't created in time.
+	NotFound Outcome = "NotFound"
+)
+
+// WaitOptions control how long, and how closely, a job is watched.
+type WaitOptions struct {
+	// PollInterval is how often the jobs are read again from the API server,
+	// in addition to when a change is observed, in case a change is missed.
+	// Defaults to only checking when a change is observed.
+	PollInterval time.Duration
+
+	// RequireCreatedWithin is how long to wait for the job to be created,
+	// before giving up with the NotFound outcome. Defaults to waiting forever.
+	RequireCreatedWithin time.Duration
+}
+
+// Result describes a finished job.
+type Result struct {
+	Namespace, Name string
+	Outcome         Outcome
+
+	// Job is the last known state of the job, and is nil when it was not found.
+	Job *batchv1.Job
+
+	// Reason is a brief, machine readable, explanation of why the job failed,
+	// for example BackoffLimitExceeded or DeadlineExceeded.
+	Reason string
+
+	// Message is a human readable explanation of why the job failed.
+	Message string
+}
+
+// Succeeded checks if the job completed successfully.
+func (r Result) Succeeded() bool {
+	return r.Outcome == Succeeded
+}
+
+// Error explains why the job failed, and is nil when the job succeeded.
+func (r Result) Error() error {
+	if r.Succeeded() {
+		return nil
+	}
+	if r.Message == "" {
+		return errors.Errorf("%s/%s failed: %s", r.Namespace, r.Name, r.Reason)
+	}
+	return errors.Errorf("%s/%s failed: %s %s", r.Namespace, r.Name, r.Reason, r.Message)
+}
+
+// ResultOf determines the result of a job, returning false when the job
+// hasn't finished yet.
+func ResultOf(j *batchv1.Job) (Result, bool) {
+	result := Result{Namespace: j.Namespace, Name: j.Name, Job: j}
+	if cond := findCondition(j, batchv1.JobFailed); cond != nil {
+		result.Outcome = Failed
+		result.Reason = cond.Reason
+		result.Message = cond.Message
+		return result, true
+	}
+	if HasSucceeded(j) {
+		result.Outcome = Succeeded
+		return result, true
+	}
+	return Result{}, false
+}
+
+// deletedResult is the result of a job that was deleted before it finished.
+func deletedResult(j *batchv1.Job) Result {
+	return Result{
+		Namespace: j.Namespace,
+		Name:      j.Name,
+		Job:       j,
+		Outcome:   Deleted,
+		Reason:    string(Deleted),
+		Message:   "the job was deleted before it finished",
+	}
+}
+
+// notFoundResult is the result of a job that wasn't created in time.
+func notFoundResult(namespace, name string, createdWithin time.Duration) Result {
+	return Result{
+		Namespace: namespace,
+		Name:      name,
+		Outcome:   NotFound,
+		Reason:    string(NotFound),
+		Message:   fmt.Sprintf("the job was not created within %s", createdWithin),
+	}
+}
+
+// WaitUntilComplete sends the result of the job once it has either succeeded,
+// failed and will not be retried, or was deleted. The job doesn't need to
+// exist yet, unless opts.RequireCreatedWithin is set. An error is sent when
+// we aren't allowed to watch the job.
+func (c Client) WaitUntilComplete(done <-chan struct{}, namespace, name string, opts WaitOptions) (<-chan Result, <-chan error) {
+	groupChan, groupErrChan := c.WaitForJobs(done, namespace, JobSet{Names: []string{name}}, WaitForAll, opts)
+	resultChan := make(chan Result)
 	errChan := make(chan error)
 
 	go func() {
-		defer close(jobChan)
+		defer close(resultChan)
 		defer close(errChan)
 
-		clusterClient, err := api.GetCurrentClusterClient()
-		if err != nil {
-			errChan <- err
-			return
+		select {
+		case <-done:
+		case err, ok := <-groupErrChan:
+			if ok {
+				select {
+				case <-done:
+				case errChan <- err:
+				}
+			}
+		case group, ok := <-groupChan:
+			if ok {
+				select {
+				case <-done:
+				case resultChan <- group.Results[0]:
+				}
+			}
 		}
+	}()
+
+	return resultChan, errChan
+}
 
-		jobclient := clusterClient.BatchV1().Jobs(namespace)
+// WaitUntilDeleted closes the returned channel once the job no longer exists.
+// An error is sent when we aren't allowed to watch the job.
+func (c Client) WaitUntilDeleted(done <-chan struct{}, namespace, name string) <-chan error {
+	errChan := make(chan error)
 
-		opts := metav1.ListOptions{
-			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
-		}
-		watch, err := jobclient.Watch(context.TODO(), opts)
-		if err != nil {
-			errChan <- errors.Wrapf(err, "Unable to watch %v:jobs for %#v", namespace, opts)
-			return
-		}
-		defer watch.Stop()
-		events := watch.ResultChan()
+	go func() {
+		defer close(errChan)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		w := c.watchJob(stop, namespace, name, 0)
+
+		synced := make(chan struct{})
+		go func() {
+			defer close(synced)
+			cache.WaitForCacheSync(stop, w.informer.HasSynced)
+		}()
 
 		for {
 			select {
 			case <-done:
 				return
-			case e := <-events:
-				job, ok := e.Object.(*batchv1.Job)
-				if !ok {
-					errChan <- errors.Errorf("watch returned a non-job:\n%#v", e.Object)
-					continue
+			case err := <-w.errs:
+				select {
+				case <-done:
+				case errChan <- err:
 				}
-				if job.Status.Succeeded > 0 {
-					jobChan <- job
-				} else {
-					log.Printf("job hasn't suceeded yet, current status is %#v", job.Status)
+				return
+			case <-synced:
+				// The job may have been deleted before we started waiting
+				synced = nil
+				_, exists, _ := w.informer.GetStore().GetByKey(namespace + "/" + name)
+				if !exists {
+					return
+				}
+			case change := <-w.changes:
+				if change.deleted {
+					return
 				}
 			}
 		}
 	}()
 
-	return jobChan, errChan
+	return errChan
 }
 
-func WaitUntilDeleted(done <-chan struct{}, namespace, name string) <-chan error {
+// Follow sends the current state of each job in the set, and then every
+// change to them, until done is closed. Deleted jobs are not sent. When
+// resync is set, the current state of the jobs is sent again at that
+// interval. An error is sent when we aren't allowed to watch the jobs.
+func (c Client) Follow(done <-chan struct{}, namespace string, set JobSet, resync time.Duration) (<-chan *batchv1.Job, <-chan error) {
+	jobChan := make(chan *batchv1.Job)
 	errChan := make(chan error)
 
 	go func() {
+		defer close(jobChan)
 		defer close(errChan)
 
-		clusterClient, err := api.GetCurrentClusterClient()
-		if err != nil {
-			errChan <- err
+		if err := set.Validate(); err != nil {
+			select {
+			case <-done:
+			case errChan <- err:
+			}
 			return
 		}
 
-		jobclient := clusterClient.BatchV1().Jobs(namespace)
-
-		opts := metav1.ListOptions{
-			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
-		}
-		watch, err := jobclient.Watch(context.TODO(), opts)
-		if err != nil {
-			errChan <- errors.Wrapf(err, "Unable to watch %v:jobs for %#v", namespace, opts)
-			return
-		}
-		defer watch.Stop()
-		events := watch.ResultChan()
+		stop := make(chan struct{})
+		defer close(stop)
+		w := c.watchJobs(stop, namespace, set, resync)
 
 		for {
 			select {
 			case <-done:
 				return
-			case e := <-events:
-				if e.Type == watchapi.Deleted {
+			case err := <-w.errs:
+				select {
+				case <-done:
+				case errChan <- err:
+				}
+				return
+			case change := <-w.changes:
+				if change.deleted {
+					continue
+				}
+				select {
+				case <-done:
 					return
+				case jobChan <- change.job:
 				}
 			}
 		}
 	}()
 
-	return errChan
+	return jobChan, errChan
+}
+
+// jobChange is the latest state of a watched job.
+type jobChange struct {
+	job *batchv1.Job
+
+	// deleted is set when the job was removed, and job is its last known state.
+	deleted bool
+}
+
+// jobWatch follows the changes to a set of jobs.
+type jobWatch struct {
+	informer cache.SharedIndexInformer
+
+	// changes receives the current state of each job, then every change to them.
+	changes <-chan jobChange
+
+	// errs receives errors that will not go away by retrying, such as
+	// not having permission to watch jobs.
+	errs <-chan error
+}
+
+// watchJob follows the changes to a job until stop is closed.
+func (c Client) watchJob(stop <-chan struct{}, namespace, name string, resync time.Duration) jobWatch {
+	return c.watchJobs(stop, namespace, JobSet{Names: []string{name}}, resync)
+}
+
+// watchJobs follows the changes to a set of jobs until stop is closed. The
+// watch is resumed from the last resourceVersion when the API server closes
+// it, and the jobs are listed again when the watch cannot be resumed, so
+// that changes, including deletion, are not missed. When resync is set, the
+// current state of the jobs is sent again at that interval.
+func (c Client) watchJobs(stop <-chan struct{}, namespace string, set JobSet, resync time.Duration) jobWatch {
+	// Report errors that will not go away by retrying, the informer
+	// retries everything else
+	errs := make(chan error, 1)
+	checkErr := func(err error) {
+		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+			select {
+			case errs <- errors.Wrapf(err, "unable to watch %s in %s", set, namespace):
+			default:
+			}
+		}
+	}
+
+	jobclient := c.clientset.BatchV1().Jobs(namespace)
+	filter := set.listOptions()
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = filter.FieldSelector
+			opts.LabelSelector = filter.LabelSelector
+			list, err := jobclient.List(context.TODO(), opts)
+			checkErr(err)
+			return list, err
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watchapi.Interface, error) {
+			opts.FieldSelector = filter.FieldSelector
+			opts.LabelSelector = filter.LabelSelector
+			w, err := jobclient.Watch(context.TODO(), opts)
+			checkErr(err)
+			return w, err
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &batchv1.Job{}, resync, cache.Indexers{})
+
+	changes := make(chan jobChange)
+	send := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		j, ok := obj.(*batchv1.Job)
+		if !ok || !set.Matches(j) {
+			return
+		}
+		select {
+		case <-stop:
+		case changes <- jobChange{job: j, deleted: deleted}:
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { send(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { send(obj, false) },
+		DeleteFunc: func(obj interface{}) { send(obj, true) },
+	})
+
+	informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		log.Println(errors.Wrapf(err, "the watch on %s in %s failed, retrying", set, namespace))
+	})
+
+	go informer.Run(stop)
+
+	return jobWatch{informer: informer, changes: changes, errs: errs}
 }
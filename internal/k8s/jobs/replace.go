@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// ReplacePolicy decides what CreateOrReplace does when a job with the same
+// name already exists.
+type ReplacePolicy string
+
+const (
+	// ReplaceIfFinished replaces the existing job once it has succeeded or
+	// failed, and leaves a job that is still running alone.
+	ReplaceIfFinished ReplacePolicy = "replace-if-finished"
+
+	// FailIfExists never replaces the existing job.
+	FailIfExists ReplacePolicy = "fail-if-exists"
+
+	// AlwaysReplace deletes the existing job, even when it is still running.
+	AlwaysReplace ReplacePolicy = "always-replace"
+)
+
+// DefaultReplacePolicy is used when CreateOptions.Policy is not set.
+const DefaultReplacePolicy = ReplaceIfFinished
+
+// DefaultRetries is how many times CreateOrReplace replaces a job, when
+// CreateOptions.Retries is not set, before giving up.
+const DefaultRetries = 3
+
+// deleteTimeout is how long CreateOrReplace waits for a replaced job to be deleted.
+const deleteTimeout = 2 * time.Minute
+
+var replacePolicies = []ReplacePolicy{ReplaceIfFinished, FailIfExists, AlwaysReplace}
+
+// String returns the name of the policy.
+func (p *ReplacePolicy) String() string {
+	if p == nil {
+		return ""
+	}
+	return string(*p)
+}
+
+// Set the policy from its name, so that it can be used as a flag.
+func (p *ReplacePolicy) Set(value string) error {
+	policy := ReplacePolicy(value)
+	err := policy.Validate()
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// Validate checks that the policy is one of the supported policies.
+func (p ReplacePolicy) Validate() error {
+	names := make([]string, len(replacePolicies))
+	for i, policy := range replacePolicies {
+		if p == policy {
+			return nil
+		}
+		names[i] = string(policy)
+	}
+	return errors.Errorf("invalid replace policy %q, must be one of %s", p, strings.Join(names, ", "))
+}
+
+// CreateOptions control how CreateOrReplace handles an existing job.
+type CreateOptions struct {
+	// Policy decides if an existing job with the same name is replaced,
+	// defaults to DefaultReplacePolicy.
+	Policy ReplacePolicy
+
+	// Retries is how many times the job is replaced, when it keeps being
+	// recreated by someone else, defaults to DefaultRetries.
+	Retries int
+}
+
+func (o CreateOptions) withDefaults() CreateOptions {
+	if o.Policy == "" {
+		o.Policy = DefaultReplacePolicy
+	}
+	if o.Retries <= 0 {
+		o.Retries = DefaultRetries
+	}
+	return o
+}
+
+// JobExistsError is returned by CreateOrReplace when a job with the same name
+// exists, and the ReplacePolicy doesn't allow it to be replaced.
+type JobExistsError struct {
+	Policy ReplacePolicy
+
+	// Existing is the job that was not replaced.
+	Existing *batchv1.Job
+}
+
+// Finished checks if the existing job has succeeded or failed.
+func (e JobExistsError) Finished() bool {
+	return HasSucceeded(e.Existing) || HasFailed(e.Existing)
+}
+
+func (e JobExistsError) Error() string {
+	state := "has finished"
+	if !e.Finished() {
+		state = "is still running"
+	}
+	return fmt.Sprintf("%s/%s already exists and %s, not replacing it with the %s policy",
+		e.Existing.Namespace, e.Existing.Name, state, e.Policy)
+}
+
+// IsJobExists checks if an error is a JobExistsError.
+func IsJobExists(err error) bool {
+	_, ok := errors.Cause(err).(JobExistsError)
+	return ok
+}
@@ -0,0 +1,233 @@
+package transcodes
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Client manages VideoTranscode resources on a cluster.
+type Client struct {
+	dynamic dynamic.Interface
+}
+
+// NewClient creates a VideoTranscode client backed by the specified dynamic client.
+func NewClient(client dynamic.Interface) Client {
+	return Client{dynamic: client}
+}
+
+// NewDefaultClient creates a VideoTranscode client for the cluster selected
+// by api.DefaultClientConfig.
+func NewDefaultClient() (Client, error) {
+	client, err := api.GetDynamicClient()
+	if err != nil {
+		return Client{}, err
+	}
+	return NewClient(client), nil
+}
+
+func (c Client) resource(namespace string) dynamic.ResourceInterface {
+	return c.dynamic.Resource(GroupVersionResource).Namespace(namespace)
+}
+
+// Get a VideoTranscode.
+func (c Client) Get(name, namespace string) (*VideoTranscode, error) {
+	u, err := c.resource(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get %s/%s", namespace, name)
+	}
+	return FromUnstructured(u)
+}
+
+// List the VideoTranscodes in a namespace.
+func (c Client) List(namespace string) ([]*VideoTranscode, error) {
+	list, err := c.resource(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list %s in %s", Resource, namespace)
+	}
+
+	results := make([]*VideoTranscode, 0, len(list.Items))
+	for i := range list.Items {
+		vt, err := FromUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, vt)
+	}
+	return results, nil
+}
+
+// CreateOrReplace creates a VideoTranscode. An existing VideoTranscode with
+// the same name is returned unchanged while it is still in progress, and
+// once it has finished it is deleted, along with its jobs, and recreated
+// when the policy allows it. A jobs.NameConflictError is returned, instead
+// of replacing the existing VideoTranscode, when it is for a different
+// source path, and an ExistsError when the policy doesn't allow it to be
+// replaced.
+func (c Client) CreateOrReplace(vt *VideoTranscode, policy jobs.ReplacePolicy) (*VideoTranscode, error) {
+	err := vt.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := ToUnstructured(vt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.resource(vt.Namespace).Create(context.TODO(), u, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, geterr := c.Get(vt.Name, vt.Namespace)
+		if geterr != nil && !apierrors.IsNotFound(errors.Cause(geterr)) {
+			return nil, errors.Wrapf(geterr, "unable to check the existing %s", vt.Name)
+		}
+		if geterr == nil {
+			replace, err := shouldReplace(existing, vt, policy)
+			if err != nil {
+				return nil, err
+			}
+			if !replace {
+				return existing, nil
+			}
+		}
+
+		delerr := c.Delete(vt.Name, vt.Namespace)
+		if delerr != nil {
+			return nil, errors.Wrapf(delerr, "unable to delete existing %s so that it can be recreated", vt.Name)
+		}
+		result, err = c.resource(vt.Namespace).Create(context.TODO(), u, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create %s/%s", vt.Namespace, vt.Name)
+	}
+
+	log.Printf("created %s: %s", Resource, result.GetName())
+	return FromUnstructured(result)
+}
+
+// UpdateStatus saves the status of a VideoTranscode.
+func (c Client) UpdateStatus(vt *VideoTranscode) (*VideoTranscode, error) {
+	u, err := ToUnstructured(vt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.resource(vt.Namespace).UpdateStatus(context.TODO(), u, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to update the status of %s/%s", vt.Namespace, vt.Name)
+	}
+	return FromUnstructured(result)
+}
+
+// Delete a VideoTranscode, along with the jobs that it owns.
+func (c Client) Delete(name, namespace string) error {
+	log.Printf("deleting %s: %s/%s", Resource, namespace, name)
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+	err := c.resource(namespace).Delete(context.TODO(), name, opts)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "unable to delete %s/%s", namespace, name)
+	}
+	return nil
+}
+
+// Follow sends the current state of each VideoTranscode in the namespace,
+// and then every change to them, until done is closed. Deleted resources are
+// not sent. When resync is set, the current state is sent again at that
+// interval. An error is sent when we aren't allowed to watch the resources,
+// or the custom resource definition isn't installed.
+func (c Client) Follow(done <-chan struct{}, namespace string, resync time.Duration) (<-chan *VideoTranscode, <-chan error) {
+	vtChan := make(chan *VideoTranscode)
+	errChan := make(chan error)
+
+	go func() {
+		defer close(vtChan)
+		defer close(errChan)
+
+		// Report errors that will not go away by retrying, the informer
+		// retries everything else
+		errs := make(chan error, 1)
+		checkErr := func(err error) {
+			if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) || apierrors.IsNotFound(err) {
+				select {
+				case errs <- errors.Wrapf(err, "unable to watch %s in %s", Resource, namespace):
+				default:
+				}
+			}
+		}
+
+		resource := c.resource(namespace)
+		lw := &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				list, err := resource.List(context.TODO(), opts)
+				checkErr(err)
+				return list, err
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watchapi.Interface, error) {
+				w, err := resource.Watch(context.TODO(), opts)
+				checkErr(err)
+				return w, err
+			},
+		}
+		informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, resync, cache.Indexers{})
+
+		stop := make(chan struct{})
+		defer close(stop)
+		changes := make(chan *VideoTranscode)
+		send := func(obj interface{}) {
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			vt, err := FromUnstructured(u)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			select {
+			case <-stop:
+			case changes <- vt:
+			}
+		}
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    send,
+			UpdateFunc: func(_, obj interface{}) { send(obj) },
+		})
+		informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			log.Println(errors.Wrapf(err, "the watch on %s in %s failed, retrying", Resource, namespace))
+		})
+		go informer.Run(stop)
+
+		for {
+			select {
+			case <-done:
+				return
+			case err := <-errs:
+				select {
+				case <-done:
+				case errChan <- err:
+				}
+				return
+			case vt := <-changes:
+				select {
+				case <-done:
+					return
+				case vtChan <- vt:
+				}
+			}
+		}
+	}()
+
+	return vtChan, errChan
+}
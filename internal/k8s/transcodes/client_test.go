@@ -0,0 +1,249 @@
+package transcodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testNamespace = "handbrk8s"
+
+func buildTestTranscode(name string) *VideoTranscode {
+	return New(name, testNamespace, VideoTranscodeSpec{
+		SourcePath:  "Movies/" + name + ".mkv",
+		Library:     "Movies",
+		Preset:      "tivo",
+		Destination: "Movies/" + name + ".mkv",
+	})
+}
+
+func TestVideoTranscode_Validate(t *testing.T) {
+	vt := buildTestTranscode("foo")
+	err := vt.Validate()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	vt.Spec.Library = ""
+	err = vt.Validate()
+	if err == nil {
+		t.Fatal("expected a missing library to be rejected")
+	}
+}
+
+func TestUnstructured_RoundTrip(t *testing.T) {
+	vt := buildTestTranscode("foo")
+	vt.Status = VideoTranscodeStatus{
+		Phase:        Uploading,
+		TranscodeJob: "foo-transcode",
+		OutputSize:   1024,
+		Conditions: []metav1.Condition{{
+			Type:               Transcoded,
+			Status:             metav1.ConditionTrue,
+			Reason:             "JobSucceeded",
+			LastTransitionTime: metav1.NewTime(time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)),
+		}},
+	}
+
+	u, err := ToUnstructured(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if u.GetKind() != Kind || u.GetAPIVersion() != "handbrk8s.carolynvs.io/v1alpha1" {
+		t.Fatalf("expected the kind and apiVersion to be set, got %s %s", u.GetKind(), u.GetAPIVersion())
+	}
+
+	got, err := FromUnstructured(u)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if got.Spec != vt.Spec || got.Status.Phase != Uploading || got.Status.OutputSize != 1024 || len(got.Status.Conditions) != 1 {
+		t.Fatalf("expected the VideoTranscode to survive the round trip, got %#v", got)
+	}
+}
+
+func TestClient_CreateOrReplace(t *testing.T) {
+	c := NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+
+	_, err := c.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Replacing starts the video over
+	vt, err := c.Get("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	vt.Status.Phase = Failed
+	_, err = c.UpdateStatus(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	replacement := buildTestTranscode("foo")
+	replacement.Spec.Preset = "fast"
+	_, err = c.CreateOrReplace(replacement, jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	vt, err = c.Get("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if vt.Spec.Preset != "fast" || vt.Status.Phase != "" {
+		t.Fatalf("expected the existing VideoTranscode to be replaced, got %#v", vt)
+	}
+}
+
+func TestStore_CreateOrReplace_Policy(t *testing.T) {
+	testcases := []struct {
+		name        string
+		phase       Phase
+		policy      jobs.ReplacePolicy
+		wantReplace bool
+		wantExists  bool
+	}{
+		{"pending", "", jobs.AlwaysReplace, false, false},
+		{"transcoding", Transcoding, jobs.ReplaceIfFinished, false, false},
+		{"uploading", Uploading, jobs.AlwaysReplace, false, false},
+		{"succeeded", Succeeded, jobs.ReplaceIfFinished, true, false},
+		{"failed", Failed, jobs.AlwaysReplace, true, false},
+		{"fail if exists", Failed, jobs.FailIfExists, false, true},
+	}
+
+	stores := map[string]func() Store{
+		"client": func() Store { return NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())) },
+		"memory": func() Store { return NewMemoryStore() },
+	}
+
+	for storeName, newStore := range stores {
+		for _, tc := range testcases {
+			newStore, tc := newStore, tc
+			t.Run(storeName+"/"+tc.name, func(t *testing.T) {
+				s := newStore()
+				vt, err := s.CreateOrReplace(buildTestTranscode("foo"), tc.policy)
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+				vt.Status.Phase = tc.phase
+				vt.Status.TranscodeJob = "foo-transcode"
+				_, err = s.UpdateStatus(vt)
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+
+				_, err = s.CreateOrReplace(buildTestTranscode("foo"), tc.policy)
+				if tc.wantExists != IsExists(err) {
+					t.Fatalf("expected an ExistsError to be %t, got %#v", tc.wantExists, err)
+				}
+				if err != nil && !tc.wantExists {
+					t.Fatalf("%#v", err)
+				}
+
+				vt, err = s.Get("foo", testNamespace)
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+				if replaced := vt.Status.TranscodeJob == ""; replaced != tc.wantReplace {
+					t.Fatalf("expected replaced to be %t, got %#v", tc.wantReplace, vt.Status)
+				}
+			})
+		}
+	}
+}
+
+func TestClient_CreateOrReplace_NameConflict(t *testing.T) {
+	c := NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+
+	_, err := c.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	other := buildTestTranscode("foo")
+	other.Spec.SourcePath = "TV/foo.mkv"
+	_, err = c.CreateOrReplace(other, jobs.DefaultReplacePolicy)
+	if !jobs.IsNameConflict(err) {
+		t.Fatalf("expected a NameConflictError, got %#v", err)
+	}
+
+	vt, err := c.Get("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if vt.Spec.SourcePath != "Movies/foo.mkv" {
+		t.Fatalf("expected the existing VideoTranscode to be kept, got %s", vt.Spec.SourcePath)
+	}
+}
+
+func TestClient_UpdateStatus(t *testing.T) {
+	c := NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+
+	vt, err := c.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	vt.Status.Phase = Transcoding
+	vt.Status.TranscodeJob = "foo-transcode"
+	_, err = c.UpdateStatus(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	vt, err = c.Get("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if vt.Status.Phase != Transcoding || vt.Status.TranscodeJob != "foo-transcode" {
+		t.Fatalf("expected the status to be saved, got %#v", vt.Status)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	c := NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+
+	for _, name := range []string{"foo", "bar"} {
+		_, err := c.CreateOrReplace(buildTestTranscode(name), jobs.DefaultReplacePolicy)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	vts, err := c.List(testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(vts) != 2 {
+		t.Fatalf("expected 2 VideoTranscodes, got %#v", vts)
+	}
+}
+
+func TestClient_Follow(t *testing.T) {
+	c := NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+	_, err := c.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	vtChan, errChan := c.Follow(done, testNamespace, 0)
+
+	select {
+	case vt := <-vtChan:
+		if vt.Name != "foo" || vt.Spec.Library != "Movies" {
+			t.Fatalf("expected the existing VideoTranscode to be sent, got %#v", vt)
+		}
+	case err := <-errChan:
+		t.Fatalf("%#v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the existing VideoTranscode")
+	}
+}
@@ -0,0 +1,243 @@
+package transcodes
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Store saves VideoTranscode resources. Client stores them on a cluster, and
+// MemoryStore keeps them in memory when running without Kubernetes.
+type Store interface {
+	Get(name, namespace string) (*VideoTranscode, error)
+	List(namespace string) ([]*VideoTranscode, error)
+	CreateOrReplace(vt *VideoTranscode, policy jobs.ReplacePolicy) (*VideoTranscode, error)
+	UpdateStatus(vt *VideoTranscode) (*VideoTranscode, error)
+	Delete(name, namespace string) error
+	Follow(done <-chan struct{}, namespace string, resync time.Duration) (<-chan *VideoTranscode, <-chan error)
+}
+
+var (
+	_ Store = Client{}
+	_ Store = &MemoryStore{}
+)
+
+// MemoryStore keeps VideoTranscode resources in memory, so they are lost
+// when the process exits. It follows the same rules as Client, returning the
+// same errors, e.g. apierrors.IsNotFound, when a resource doesn't exist.
+type MemoryStore struct {
+	mu        sync.Mutex
+	resources map[string]*VideoTranscode
+	nextUID   int
+
+	// followers are notified of the keys of the resources that change.
+	followers map[*follower]bool
+}
+
+// follower collects the changes for a call to Follow, so that a slow reader
+// doesn't block changes to the store.
+type follower struct {
+	namespace string
+	changed   map[string]bool
+	notify    chan struct{}
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		resources: make(map[string]*VideoTranscode),
+		followers: make(map[*follower]bool),
+	}
+}
+
+func storeKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Get a VideoTranscode.
+func (s *MemoryStore) Get(name, namespace string) (*VideoTranscode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vt, ok := s.resources[storeKey(namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(GroupVersionResource.GroupResource(), name)
+	}
+	return vt.DeepCopy(), nil
+}
+
+// List the VideoTranscodes in a namespace.
+func (s *MemoryStore) List(namespace string) ([]*VideoTranscode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []*VideoTranscode
+	for _, vt := range s.resources {
+		if vt.Namespace == namespace {
+			results = append(results, vt.DeepCopy())
+		}
+	}
+	return results, nil
+}
+
+// CreateOrReplace creates a VideoTranscode, following the same rules as
+// Client.CreateOrReplace for an existing VideoTranscode with the same name.
+func (s *MemoryStore) CreateOrReplace(vt *VideoTranscode, policy jobs.ReplacePolicy) (*VideoTranscode, error) {
+	err := vt.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := storeKey(vt.Namespace, vt.Name)
+	if existing, ok := s.resources[key]; ok {
+		replace, err := shouldReplace(existing, vt, policy)
+		if err != nil {
+			return nil, err
+		}
+		if !replace {
+			return existing.DeepCopy(), nil
+		}
+	}
+
+	// Like the cluster, a new resource starts without a status
+	created := vt.DeepCopy()
+	created.Status = VideoTranscodeStatus{}
+	s.nextUID++
+	created.UID = types.UID(fmt.Sprintf("memory-%d", s.nextUID))
+	s.resources[key] = created
+	s.changed(created)
+
+	log.Printf("created %s: %s", Resource, created.Name)
+	return created.DeepCopy(), nil
+}
+
+// UpdateStatus saves the status of a VideoTranscode.
+func (s *MemoryStore) UpdateStatus(vt *VideoTranscode) (*VideoTranscode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.resources[storeKey(vt.Namespace, vt.Name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(GroupVersionResource.GroupResource(), vt.Name)
+	}
+	existing.Status = *vt.Status.DeepCopy()
+	s.changed(existing)
+	return existing.DeepCopy(), nil
+}
+
+// Delete a VideoTranscode.
+func (s *MemoryStore) Delete(name, namespace string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Printf("deleting %s: %s/%s", Resource, namespace, name)
+	delete(s.resources, storeKey(namespace, name))
+	return nil
+}
+
+// changed notifies the followers of a change to a resource, s.mu must be held.
+func (s *MemoryStore) changed(vt *VideoTranscode) {
+	for f := range s.followers {
+		if f.namespace != vt.Namespace {
+			continue
+		}
+		f.changed[vt.Name] = true
+		select {
+		case f.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Follow sends the current state of each VideoTranscode in the namespace,
+// and then every change to them, until done is closed. Deleted resources are
+// not sent. When resync is set, the current state is sent again at that
+// interval. A change that is made while the previous change is being sent
+// is only sent once, with the latest state.
+func (s *MemoryStore) Follow(done <-chan struct{}, namespace string, resync time.Duration) (<-chan *VideoTranscode, <-chan error) {
+	vtChan := make(chan *VideoTranscode)
+	errChan := make(chan error)
+
+	f := &follower{
+		namespace: namespace,
+		changed:   make(map[string]bool),
+		notify:    make(chan struct{}, 1),
+	}
+
+	s.mu.Lock()
+	for _, vt := range s.resources {
+		if vt.Namespace == namespace {
+			f.changed[vt.Name] = true
+		}
+	}
+	f.notify <- struct{}{}
+	s.followers[f] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer close(vtChan)
+		defer close(errChan)
+		defer func() {
+			s.mu.Lock()
+			delete(s.followers, f)
+			s.mu.Unlock()
+		}()
+
+		var resyncChan <-chan time.Time
+		if resync > 0 {
+			ticker := time.NewTicker(resync)
+			defer ticker.Stop()
+			resyncChan = ticker.C
+		}
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-resyncChan:
+				s.mu.Lock()
+				for _, vt := range s.resources {
+					if vt.Namespace == namespace {
+						f.changed[vt.Name] = true
+					}
+				}
+				s.mu.Unlock()
+			case <-f.notify:
+			}
+
+			for _, vt := range s.takeChanges(f) {
+				select {
+				case <-done:
+					return
+				case vtChan <- vt:
+				}
+			}
+		}
+	}()
+
+	return vtChan, errChan
+}
+
+// takeChanges returns the latest state of the resources that changed since
+// they were last sent to the follower.
+func (s *MemoryStore) takeChanges(f *follower) []*VideoTranscode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []*VideoTranscode
+	for name := range f.changed {
+		if vt, ok := s.resources[storeKey(f.namespace, name)]; ok {
+			changes = append(changes, vt.DeepCopy())
+		}
+	}
+	f.changed = make(map[string]bool)
+	return changes
+}
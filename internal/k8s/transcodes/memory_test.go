@@ -0,0 +1,91 @@
+package transcodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestMemoryStore_CreateOrReplace(t *testing.T) {
+	s := NewMemoryStore()
+
+	_, err := s.Get("foo", testNamespace)
+	if !apierrors.IsNotFound(errors.Cause(err)) {
+		t.Fatalf("expected a missing VideoTranscode to be not found, got %#v", err)
+	}
+
+	vt, err := s.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	vt.Status.Phase = Failed
+	_, err = s.UpdateStatus(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Replacing starts the video over
+	_, err = s.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	vt, err = s.Get("foo", testNamespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if vt.Status.Phase != "" {
+		t.Fatalf("expected the existing VideoTranscode to be replaced, got %#v", vt.Status)
+	}
+
+	other := buildTestTranscode("foo")
+	other.Spec.SourcePath = "TV/foo.mkv"
+	_, err = s.CreateOrReplace(other, jobs.DefaultReplacePolicy)
+	if !jobs.IsNameConflict(err) {
+		t.Fatalf("expected a NameConflictError, got %#v", err)
+	}
+}
+
+func TestMemoryStore_Follow(t *testing.T) {
+	s := NewMemoryStore()
+	vt, err := s.CreateOrReplace(buildTestTranscode("foo"), jobs.DefaultReplacePolicy)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	vtChan, _ := s.Follow(done, testNamespace, 0)
+
+	receive := func(what string) *VideoTranscode {
+		select {
+		case vt := <-vtChan:
+			return vt
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+			return nil
+		}
+	}
+
+	got := receive("the existing VideoTranscode")
+	if got.Name != "foo" {
+		t.Fatalf("expected foo, got %s", got.Name)
+	}
+
+	// Changes made after the VideoTranscode was sent don't affect it
+	vt.Status.Phase = Transcoding
+	_, err = s.UpdateStatus(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if got.Status.Phase != "" {
+		t.Fatalf("expected the sent VideoTranscode to be a copy, got %s", got.Status.Phase)
+	}
+
+	got = receive("the status change")
+	if got.Status.Phase != Transcoding {
+		t.Fatalf("expected the status change to be sent, got %#v", got.Status)
+	}
+}
@@ -0,0 +1,60 @@
+package transcodes
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+)
+
+// ExistsError is returned by CreateOrReplace when a VideoTranscode for the
+// same video has finished, and the ReplacePolicy doesn't allow it to be
+// replaced.
+type ExistsError struct {
+	Policy jobs.ReplacePolicy
+
+	// Existing is the VideoTranscode that was not replaced.
+	Existing *VideoTranscode
+}
+
+func (e ExistsError) Error() string {
+	return fmt.Sprintf("%s/%s already exists and has %s, not replacing it with the %s policy",
+		e.Existing.Namespace, e.Existing.Name, e.Existing.Status.Phase, e.Policy)
+}
+
+// IsExists checks if an error is an ExistsError.
+func IsExists(err error) bool {
+	_, ok := errors.Cause(err).(ExistsError)
+	return ok
+}
+
+// shouldReplace decides if an existing VideoTranscode with the same name is
+// replaced by vt. A VideoTranscode that is still in progress is never
+// replaced, because deleting it deletes its running jobs, so the video is
+// skipped instead. A finished VideoTranscode is replaced according to the
+// policy, which defaults to jobs.DefaultReplacePolicy.
+func shouldReplace(existing, vt *VideoTranscode, policy jobs.ReplacePolicy) (bool, error) {
+	if existing.Spec.SourcePath != vt.Spec.SourcePath {
+		return false, jobs.NameConflictError{
+			Namespace: vt.Namespace,
+			Name:      vt.Name,
+			Existing:  existing.Spec.SourcePath,
+			Requested: vt.Spec.SourcePath,
+		}
+	}
+
+	if !existing.Finished() {
+		phase := existing.Status.Phase
+		if phase == "" {
+			phase = Pending
+		}
+		log.Printf("skipping %s, %s/%s is still %s\n", vt.Spec.SourcePath, existing.Namespace, existing.Name, phase)
+		return false, nil
+	}
+
+	if policy == jobs.FailIfExists {
+		return false, ExistsError{Policy: policy, Existing: existing}
+	}
+	return true, nil
+}
@@ -0,0 +1,227 @@
+package transcodes
+
+import (
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Group of the VideoTranscode custom resource.
+	Group = "handbrk8s.carolynvs.io"
+
+	// Version of the VideoTranscode custom resource.
+	Version = "v1alpha1"
+
+	// Kind of the VideoTranscode custom resource.
+	Kind = "VideoTranscode"
+
+	// Resource is the plural name of the VideoTranscode custom resource,
+	// e.g. kubectl get videotranscodes.
+	Resource = "videotranscodes"
+)
+
+// GroupVersionResource identifies the VideoTranscode custom resource.
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// Phase is how far a video has progressed through the transcode and upload jobs.
+type Phase string
+
+const (
+	// Pending indicates that the transcode job has not been created yet.
+	Pending Phase = "Pending"
+
+	// Transcoding indicates that the transcode job is running.
+	Transcoding Phase = "Transcoding"
+
+	// Uploading indicates that the video was transcoded, and the upload job is running.
+	Uploading Phase = "Uploading"
+
+	// Succeeded indicates that the video was transcoded and uploaded to Plex.
+	Succeeded Phase = "Succeeded"
+
+	// Failed indicates that a job failed, and the video was moved to the failed directory.
+	Failed Phase = "Failed"
+)
+
+// Condition types set on a VideoTranscode.
+const (
+	// Transcoded is true once the transcode job succeeds, and false when it fails.
+	Transcoded = "Transcoded"
+
+	// Uploaded is true once the upload job succeeds, and false when it fails.
+	Uploaded = "Uploaded"
+)
+
+// VideoTranscode requests that a video is transcoded and uploaded to Plex.
+type VideoTranscode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VideoTranscodeSpec   `json:"spec"`
+	Status VideoTranscodeStatus `json:"status,omitempty"`
+}
+
+// VideoTranscodeSpec is the video to transcode, and where to upload it.
+type VideoTranscodeSpec struct {
+	// SourcePath is the path of the video, relative to the watch directory,
+	// for example Movies/Foo/bar.mkv.
+	SourcePath string `json:"sourcePath"`
+
+	// Library is the Plex library where the video is uploaded.
+	Library string `json:"library"`
+
+	// Preset is the encode profile or HandBrake preset used to transcode the video.
+	Preset string `json:"preset"`
+
+	// Destination is the path of the video, relative to the Plex share.
+	Destination string `json:"destination"`
+
+	// Priority orders the videos waiting to be transcoded, higher first.
+	Priority int32 `json:"priority,omitempty"`
+
+	// PriorityClassName is set on the pods of the jobs for the video.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// VideoTranscodeStatus is the progress of the jobs processing a video.
+type VideoTranscodeStatus struct {
+	Phase Phase `json:"phase,omitempty"`
+
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// TranscodeJob is the name of the transcode job, once it is created.
+	TranscodeJob string `json:"transcodeJob,omitempty"`
+
+	// Segments are the parts of the video that are transcoded in parallel,
+	// when the video is split. TranscodeJob is not used for a split video.
+	Segments []Segment `json:"segments,omitempty"`
+
+	// ConcatJob is the name of the job that joins the transcoded segments,
+	// once every segment is transcoded.
+	ConcatJob string `json:"concatJob,omitempty"`
+
+	// UploadJob is the name of the upload job, once it is created.
+	UploadJob string `json:"uploadJob,omitempty"`
+
+	// OutputSize is the size of the transcoded video in bytes.
+	OutputSize int64 `json:"outputSize,omitempty"`
+
+	// Action is what the probe rules decided to do with the video.
+	Action probe.Action `json:"action,omitempty"`
+
+	// Media is the media info of the original video, when probing is enabled.
+	Media *probe.MediaInfo `json:"media,omitempty"`
+}
+
+// Segment is a part of a split video, transcoded by its own job.
+type Segment struct {
+	// Start is the time of the keyframe where the segment starts, in seconds.
+	Start float64 `json:"start"`
+
+	// End is the time where the segment ends, in seconds. The last segment
+	// has no end, and runs to the end of the video.
+	End float64 `json:"end,omitempty"`
+
+	// Job is the name of the job transcoding the segment.
+	Job string `json:"job,omitempty"`
+}
+
+// New creates a VideoTranscode for a video.
+func New(name, namespace string, spec VideoTranscodeSpec) *VideoTranscode {
+	return &VideoTranscode{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: Group + "/" + Version,
+			Kind:       Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: spec,
+	}
+}
+
+// Validate checks that the spec has the required fields.
+func (vt *VideoTranscode) Validate() error {
+	required := []struct{ field, value string }{
+		{"spec.sourcePath", vt.Spec.SourcePath},
+		{"spec.library", vt.Spec.Library},
+		{"spec.destination", vt.Spec.Destination},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return errors.Errorf("%s is required on %s/%s", r.field, vt.Namespace, vt.Name)
+		}
+	}
+	return nil
+}
+
+// Finished checks if the video has succeeded or failed, and its jobs are no
+// longer running.
+func (vt *VideoTranscode) Finished() bool {
+	return vt.Status.Phase == Succeeded || vt.Status.Phase == Failed
+}
+
+// OwnerReference makes a VideoTranscode the owner of a job, so that the
+// jobs are removed along with the VideoTranscode.
+func (vt *VideoTranscode) OwnerReference() metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: Group + "/" + Version,
+		Kind:       Kind,
+		Name:       vt.Name,
+		UID:        vt.UID,
+		Controller: &controller,
+	}
+}
+
+// DeepCopy returns a copy of the VideoTranscode that shares no memory with it.
+func (vt *VideoTranscode) DeepCopy() *VideoTranscode {
+	c := *vt
+	vt.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Status = *vt.Status.DeepCopy()
+	return &c
+}
+
+// DeepCopy returns a copy of the status that shares no memory with it.
+func (s *VideoTranscodeStatus) DeepCopy() *VideoTranscodeStatus {
+	c := *s
+	if s.Media != nil {
+		media := *s.Media
+		c.Media = &media
+	}
+	if s.Segments != nil {
+		c.Segments = make([]Segment, len(s.Segments))
+		copy(c.Segments, s.Segments)
+	}
+	if s.Conditions != nil {
+		c.Conditions = make([]metav1.Condition, len(s.Conditions))
+		for i := range s.Conditions {
+			s.Conditions[i].DeepCopyInto(&c.Conditions[i])
+		}
+	}
+	return &c
+}
+
+// FromUnstructured converts the object returned by the dynamic client.
+func FromUnstructured(u *unstructured.Unstructured) (*VideoTranscode, error) {
+	var vt VideoTranscode
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &vt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s/%s as a %s", u.GetNamespace(), u.GetName(), Kind)
+	}
+	return &vt, nil
+}
+
+// ToUnstructured converts a VideoTranscode for the dynamic client.
+func ToUnstructured(vt *VideoTranscode) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to convert %s/%s", vt.Namespace, vt.Name)
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
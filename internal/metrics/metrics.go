@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "handbrk8s"
+
+// Labels used to partition the metrics.
+const (
+	ResultLabel  = "result"
+	JobTypeLabel = "job_type"
+)
+
+// Values of the result label.
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+var (
+	// VideosDetected counts the videos found in the watch directory.
+	VideosDetected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "videos_detected_total",
+		Help:      "Number of videos detected in the watch directory.",
+	})
+
+	// Claims counts attempts to claim a video, by result.
+	Claims = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "claims_total",
+		Help:      "Number of attempts to claim a video, by result.",
+	}, []string{ResultLabel})
+
+	// JobsCreated counts the jobs created by the watcher, by job type.
+	JobsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_created_total",
+		Help:      "Number of jobs created, by job type.",
+	}, []string{JobTypeLabel})
+
+	// PipelinesFinished counts the videos that finished processing, by result.
+	PipelinesFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelines_finished_total",
+		Help:      "Number of video pipelines that finished, by result.",
+	}, []string{ResultLabel})
+
+	// VideosQueued is the number of videos waiting to be transcoded.
+	VideosQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "videos_queued",
+		Help:      "Number of videos waiting to be transcoded.",
+	})
+
+	// TranscodeDuration measures how long successful transcode jobs ran. A
+	// split video is measured by each of its segments.
+	TranscodeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transcode_duration_seconds",
+		Help:      "Duration of successful transcode jobs, and of each segment of a split video.",
+		// 1 minute to ~17 hours
+		Buckets: prometheus.ExponentialBuckets(60, 2, 11),
+	})
+
+	// BytesCopied counts the bytes copied by fs.CopyFile.
+	BytesCopied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_copied_total",
+		Help:      "Number of bytes copied between volumes.",
+	})
+
+	// PlexRefreshLatency measures how long it takes for an uploaded video to
+	// show up in the Plex library after requesting a refresh.
+	PlexRefreshLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "plex_refresh_latency_seconds",
+		Help:      "Time between refreshing a Plex library and the video appearing in it.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// Registry contains every handbrk8s metric.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		VideosDetected,
+		Claims,
+		JobsCreated,
+		PipelinesFinished,
+		VideosQueued,
+		TranscodeDuration,
+		BytesCopied,
+		PlexRefreshLatency,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve the metrics on /metrics at the specified address, for example :9090.
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	err := http.ListenAndServe(address, mux)
+	return errors.Wrapf(err, "unable to serve metrics on %s", address)
+}
+
+// WriteFile saves the metrics in the Prometheus text format, for processes that
+// exit before they can be scraped. The file can be collected by the node exporter's
+// textfile collector, or sent to a Pushgateway. The directory is created if it
+// doesn't exist.
+func WriteFile(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create the metrics directory for %s", path)
+	}
+	err = prometheus.WriteToTextfile(path, Registry)
+	return errors.Wrapf(err, "unable to write metrics to %s", path)
+}
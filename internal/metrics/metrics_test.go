@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readMetric writes the metrics to a file, and returns the value of a sample.
+// The metrics are global, so tests compare the value before and after.
+func readMetric(t *testing.T, path, name string) float64 {
+	err := WriteFile(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if !strings.HasPrefix(line, name+" ") {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		return value
+	}
+	t.Fatalf("expected the metrics file to contain %s:\n%s", name, contents)
+	return 0
+}
+
+func TestWriteFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The directory doesn't exist until the first upload writes its metrics
+	path := filepath.Join(tmpDir, "metrics", "upload.prom")
+	copied := readMetric(t, path, "handbrk8s_bytes_copied_total")
+	refreshes := readMetric(t, path, "handbrk8s_plex_refresh_latency_seconds_count")
+
+	BytesCopied.Add(1024)
+	PlexRefreshLatency.Observe(2.5)
+
+	if got := readMetric(t, path, "handbrk8s_bytes_copied_total"); got != copied+1024 {
+		t.Fatalf("expected %v bytes copied, got %v", copied+1024, got)
+	}
+	if got := readMetric(t, path, "handbrk8s_plex_refresh_latency_seconds_count"); got != refreshes+1 {
+		t.Fatalf("expected %v plex refreshes, got %v", refreshes+1, got)
+	}
+}
@@ -0,0 +1,216 @@
+// Package probe reads the container, codec, resolution, duration and audio
+// tracks of a video, and decides what to do with it using rules.
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MediaInfo describes a video.
+type MediaInfo struct {
+	// Container is the file format, e.g. mkv, mp4 or avi.
+	Container string `json:"container"`
+
+	// VideoCodec is the codec of the first video stream, e.g. h264 or h265.
+	VideoCodec string `json:"videoCodec"`
+
+	// Width and Height are the resolution of the first video stream, in pixels.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// DurationSeconds is the length of the video.
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// AudioTracks is the number of audio streams.
+	AudioTracks int `json:"audioTracks"`
+}
+
+// Prober reads the media info of a video.
+type Prober interface {
+	// Probe a video. An InvalidMediaError is returned when the file is not a
+	// playable video, for example when it is corrupt.
+	Probe(path string) (MediaInfo, error)
+
+	// Keyframes returns the time of each keyframe of the first video stream,
+	// in seconds from the start of the video and in order. A video can only
+	// be split at a keyframe without encoding it again.
+	Keyframes(path string) ([]float64, error)
+}
+
+// InvalidMediaError is returned when a file is not a playable video.
+type InvalidMediaError struct {
+	Path   string
+	Reason string
+}
+
+func (e InvalidMediaError) Error() string {
+	return fmt.Sprintf("%s is not a playable video: %s", e.Path, e.Reason)
+}
+
+// IsInvalidMedia checks if an error is an InvalidMediaError.
+func IsInvalidMedia(err error) bool {
+	_, ok := errors.Cause(err).(InvalidMediaError)
+	return ok
+}
+
+// DefaultFFprobeCommand is used when FFprobe.Command is not set.
+const DefaultFFprobeCommand = "ffprobe"
+
+// FFprobe probes videos with ffprobe.
+type FFprobe struct {
+	// Command is the path to ffprobe, defaults to DefaultFFprobeCommand.
+	Command string
+}
+
+// Probe a video with ffprobe.
+func (p FFprobe) Probe(path string) (MediaInfo, error) {
+	command := p.Command
+	if command == "" {
+		command = DefaultFFprobeCommand
+	}
+
+	cmd := exec.Command(command, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// ffprobe exits with an error when it can't read the file
+		return MediaInfo{}, InvalidMediaError{Path: path, Reason: strings.TrimSpace(string(exitErr.Stderr))}
+	}
+	if err != nil {
+		return MediaInfo{}, errors.Wrapf(err, "unable to run %s", command)
+	}
+
+	info, err := ParseFFprobe(output)
+	if ime, ok := err.(InvalidMediaError); ok {
+		ime.Path = path
+		return info, ime
+	}
+	return info, err
+}
+
+// Keyframes lists the keyframes of a video with ffprobe. Only the packets
+// are read, the video isn't decoded.
+func (p FFprobe) Keyframes(path string) ([]float64, error) {
+	command := p.Command
+	if command == "" {
+		command = DefaultFFprobeCommand
+	}
+
+	cmd := exec.Command(command, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags", "-print_format", "csv=print_section=0", path)
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, InvalidMediaError{Path: path, Reason: strings.TrimSpace(string(exitErr.Stderr))}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to run %s", command)
+	}
+
+	return ParseKeyframes(output)
+}
+
+// ParseKeyframes reads the output of ffprobe -show_entries packet=pts_time,flags
+// -print_format csv=print_section=0, where each line is the time and flags of a
+// packet, e.g. 10.010000,K_. Keyframes have the K flag.
+func ParseKeyframes(output []byte) ([]float64, error) {
+	var keyframes []float64
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || !strings.Contains(fields[1], "K") {
+			continue
+		}
+		// Packets without a timestamp are reported as N/A
+		if fields[0] == "N/A" {
+			continue
+		}
+		t, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid packet time %q in the ffprobe output", fields[0])
+		}
+		keyframes = append(keyframes, t)
+	}
+	sort.Float64s(keyframes)
+	return keyframes, nil
+}
+
+// ffprobeOutput is the part of the ffprobe json output that we use.
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+}
+
+// containers maps the format names reported by ffprobe to the file format.
+var containers = map[string]string{
+	"matroska": "mkv",
+	"mov":      "mp4",
+}
+
+// codecs maps the codec names reported by ffprobe to the names used by the
+// encode profiles.
+var codecs = map[string]string{
+	"hevc": "h265",
+}
+
+// ParseFFprobe reads the output of ffprobe -print_format json -show_format -show_streams.
+func ParseFFprobe(output []byte) (MediaInfo, error) {
+	var out ffprobeOutput
+	err := json.Unmarshal(output, &out)
+	if err != nil {
+		return MediaInfo{}, errors.Wrap(err, "unable to parse the ffprobe output")
+	}
+
+	var info MediaInfo
+	// The format name lists the formats that match, e.g. matroska,webm
+	info.Container = strings.Split(out.Format.FormatName, ",")[0]
+	if c, ok := containers[info.Container]; ok {
+		info.Container = c
+	}
+
+	foundVideo := false
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			info.VideoCodec = s.CodecName
+			if c, ok := codecs[s.CodecName]; ok {
+				info.VideoCodec = c
+			}
+			info.Width = s.Width
+			info.Height = s.Height
+		case "audio":
+			info.AudioTracks++
+		}
+	}
+	if !foundVideo {
+		return info, InvalidMediaError{Reason: "it has no video stream"}
+	}
+
+	if out.Format.Duration != "" {
+		info.DurationSeconds, err = strconv.ParseFloat(out.Format.Duration, 64)
+		if err != nil {
+			return info, InvalidMediaError{Reason: fmt.Sprintf("invalid duration %q", out.Format.Duration)}
+		}
+	}
+	if info.DurationSeconds <= 0 {
+		return info, InvalidMediaError{Reason: "it has no duration"}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testFFprobeOutput = `{
+  "streams": [
+    {"index": 0, "codec_name": "hevc", "codec_type": "video", "width": 3840, "height": 2160},
+    {"index": 1, "codec_name": "aac", "codec_type": "audio"},
+    {"index": 2, "codec_name": "ac3", "codec_type": "audio"},
+    {"index": 3, "codec_name": "subrip", "codec_type": "subtitle"}
+  ],
+  "format": {"format_name": "matroska,webm", "duration": "5400.250000"}
+}`
+
+func TestParseFFprobe(t *testing.T) {
+	info, err := ParseFFprobe([]byte(testFFprobeOutput))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	want := MediaInfo{Container: "mkv", VideoCodec: "h265", Width: 3840, Height: 2160, DurationSeconds: 5400.25, AudioTracks: 2}
+	if info != want {
+		t.Fatalf("expected %#v, got %#v", want, info)
+	}
+}
+
+func TestParseFFprobe_Invalid(t *testing.T) {
+	testcases := []struct {
+		name   string
+		output string
+	}{
+		{"no video", `{"streams": [{"codec_type": "audio", "codec_name": "aac"}], "format": {"format_name": "mp3", "duration": "60"}}`},
+		{"no duration", `{"streams": [{"codec_type": "video", "codec_name": "h264"}], "format": {"format_name": "mov,mp4"}}`},
+		{"invalid duration", `{"streams": [{"codec_type": "video", "codec_name": "h264"}], "format": {"format_name": "avi", "duration": "N/A"}}`},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseFFprobe([]byte(tc.output))
+			if !IsInvalidMedia(err) {
+				t.Fatalf("expected an InvalidMediaError, got %#v", err)
+			}
+		})
+	}
+}
+
+func TestFFprobe_Probe(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Stand in for ffprobe, failing the way it does for a corrupt file
+	output := filepath.Join(tmpDir, "output.json")
+	err = ioutil.WriteFile(output, []byte(testFFprobeOutput), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	script := filepath.Join(tmpDir, "ffprobe")
+	err = ioutil.WriteFile(script, []byte(`#!/bin/sh
+for arg; do path="$arg"; done
+if [ "$path" = "corrupt.mkv" ]; then
+  echo "corrupt.mkv: Invalid data found when processing input" >&2
+  exit 1
+fi
+cat `+output+`
+`), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	p := FFprobe{Command: script}
+
+	info, err := p.Probe("movie.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if info.Height != 2160 {
+		t.Fatalf("expected the ffprobe output to be parsed, got %#v", info)
+	}
+
+	_, err = p.Probe("corrupt.mkv")
+	if !IsInvalidMedia(err) {
+		t.Fatalf("expected a corrupt file to be invalid media, got %#v", err)
+	}
+
+	_, err = FFprobe{Command: filepath.Join(tmpDir, "missing")}.Probe("movie.mkv")
+	if err == nil || IsInvalidMedia(err) {
+		t.Fatalf("expected a missing ffprobe to fail without blaming the video, got %#v", err)
+	}
+}
+
+func TestParseKeyframes(t *testing.T) {
+	output := "0.000000,K_\n0.041708,__\n10.010000,K_\nN/A,K_\n5.005000,K__\n20.020000,_D\n"
+	got, err := ParseKeyframes([]byte(output))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	want := []float64{0, 5.005, 10.01}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
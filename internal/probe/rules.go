@@ -0,0 +1,129 @@
+package probe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Action is what to do with a video.
+type Action string
+
+const (
+	// Transcode the video, the default.
+	Transcode Action = "transcode"
+
+	// Remux copies the video and audio streams into a new file, without
+	// encoding them again.
+	Remux Action = "remux"
+
+	// Upload the original video, without transcoding it.
+	Upload Action = "upload"
+
+	// Reject the video, moving it to the failed directory.
+	Reject Action = "reject"
+)
+
+var actions = []Action{Transcode, Remux, Upload, Reject}
+
+// Rule decides what to do with the videos that match it. Every condition
+// that is set must match.
+type Rule struct {
+	// Name identifies the rule in the logs and status.
+	Name string `json:"name"`
+
+	// VideoCodecs matches videos with one of the codecs, e.g. h264.
+	VideoCodecs []string `json:"videoCodecs,omitempty"`
+
+	// Containers matches videos in one of the file formats, e.g. mkv.
+	Containers []string `json:"containers,omitempty"`
+
+	// MinHeight and MaxHeight match videos within the vertical resolution,
+	// inclusive, e.g. a MinHeight of 2160 matches 4K videos.
+	MinHeight int `json:"minHeight,omitempty"`
+	MaxHeight int `json:"maxHeight,omitempty"`
+
+	// Action is what to do with the video, defaults to transcode.
+	Action Action `json:"action,omitempty"`
+
+	// Preset transcodes the video, instead of the preset selected by its
+	// path. Only used by the transcode action.
+	Preset string `json:"preset,omitempty"`
+}
+
+// String describes the rule in messages.
+func (r Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s %s", r.action(), r.Preset)
+}
+
+func (r Rule) action() Action {
+	if r.Action == "" {
+		return Transcode
+	}
+	return r.Action
+}
+
+// Decision is the action for a video, returned by Decide.
+type Decision struct {
+	Action Action
+
+	// Preset overrides the preset of the video, when set.
+	Preset string
+
+	// Rule is the rule that matched, and is empty when no rule matched.
+	Rule string
+}
+
+// Matches checks if a video matches the rule.
+func (r Rule) Matches(info MediaInfo) bool {
+	if len(r.VideoCodecs) > 0 && !containsFold(r.VideoCodecs, info.VideoCodec) {
+		return false
+	}
+	if len(r.Containers) > 0 && !containsFold(r.Containers, info.Container) {
+		return false
+	}
+	if r.MinHeight > 0 && info.Height < r.MinHeight {
+		return false
+	}
+	if r.MaxHeight > 0 && info.Height > r.MaxHeight {
+		return false
+	}
+	return true
+}
+
+// Validate checks that the action is supported.
+func (r Rule) Validate() error {
+	for _, a := range actions {
+		if r.action() == a {
+			if r.Preset != "" && a != Transcode {
+				return errors.Errorf("rule %s sets a preset, which is only used by the %s action", r, Transcode)
+			}
+			return nil
+		}
+	}
+	return errors.Errorf("rule %s has an invalid action %q, must be one of transcode, remux, upload or reject", r, r.Action)
+}
+
+// Decide checks the rules in order, returning the action of the first rule
+// that matches the video. Videos that don't match a rule are transcoded.
+func Decide(rules []Rule, info MediaInfo) Decision {
+	for _, r := range rules {
+		if r.Matches(info) {
+			return Decision{Action: r.action(), Preset: r.Preset, Rule: r.String()}
+		}
+	}
+	return Decision{Action: Transcode}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package probe
+
+import (
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	rules := []Rule{
+		{Name: "already compatible", VideoCodecs: []string{"h264"}, Containers: []string{"mkv", "mp4"}, MaxHeight: 1080, Action: Upload},
+		{Name: "4k", MinHeight: 2160, Preset: "4k"},
+		{Name: "legacy container", Containers: []string{"avi"}, Action: Remux},
+		{Name: "sd", MaxHeight: 576, Preset: "sd"},
+	}
+
+	testcases := []struct {
+		name string
+		info MediaInfo
+		want Decision
+	}{
+		{"h264 1080p", MediaInfo{Container: "mkv", VideoCodec: "h264", Height: 1080}, Decision{Action: Upload, Rule: "already compatible"}},
+		{"h265 4k", MediaInfo{Container: "mkv", VideoCodec: "h265", Height: 2160}, Decision{Action: Transcode, Preset: "4k", Rule: "4k"}},
+		{"avi", MediaInfo{Container: "avi", VideoCodec: "h264", Height: 720}, Decision{Action: Remux, Rule: "legacy container"}},
+		{"mpeg2 sd", MediaInfo{Container: "mpegts", VideoCodec: "mpeg2video", Height: 480}, Decision{Action: Transcode, Preset: "sd", Rule: "sd"}},
+		{"no match", MediaInfo{Container: "mkv", VideoCodec: "h265", Height: 1080}, Decision{Action: Transcode}},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := Decide(rules, tc.info)
+			if got != tc.want {
+				t.Fatalf("expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	testcases := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"default action", Rule{Preset: "4k"}, false},
+		{"reject", Rule{Action: Reject}, false},
+		{"invalid action", Rule{Action: "delete"}, true},
+		{"preset without transcode", Rule{Action: Upload, Preset: "4k"}, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package transcoder
+
+import (
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/pkg/errors"
+)
+
+type custom struct {
+	profile Profile
+}
+
+func (t custom) Image() string {
+	return t.profile.Image
+}
+
+// Command executes the command template of the profile with the job.
+func (t custom) Command(job Job) ([]string, error) {
+	args := make([]string, len(t.profile.Command))
+	for i, arg := range t.profile.Command {
+		value, err := api.ProcessTemplate(arg, job)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to build the custom transcoder command")
+		}
+		args[i] = string(value)
+	}
+	return args, nil
+}
@@ -0,0 +1,49 @@
+package transcoder
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FFmpegImage runs ffmpeg in the transcode job.
+const FFmpegImage = "jrottenberg/ffmpeg:4.3-alpine"
+
+type ffmpeg struct {
+	profile Profile
+}
+
+func (t ffmpeg) Image() string {
+	return t.profile.image(FFmpegImage)
+}
+
+func (t ffmpeg) Command(job Job) ([]string, error) {
+	p := t.profile
+	encoders := map[string]string{"h264": "libx264", "h265": "libx265"}
+	formats := map[string]string{"mkv": "matroska", "mp4": "mp4"}
+
+	args := []string{"ffmpeg", "-nostdin", "-y"}
+	// Seek the input, so that ffmpeg skips to the keyframe at the start
+	if job.Start > 0 {
+		args = append(args, "-ss", seconds(job.Start))
+	}
+	if job.End > 0 {
+		args = append(args, "-t", seconds(job.End-job.Start))
+	}
+	args = append(args, "-i", job.InputPath, "-map", "0:v:0")
+	for _, track := range p.AudioTracks {
+		// The ? skips a track that the video doesn't have
+		args = append(args, "-map", fmt.Sprintf("0:a:%d?", track-1))
+	}
+
+	if p.VideoCodec == "copy" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", encoders[p.VideoCodec], "-crf", strconv.Itoa(p.Quality))
+	}
+
+	return append(args,
+		"-c:a", p.AudioCodec,
+		"-f", formats[p.Container],
+		job.OutputPath,
+	), nil
+}
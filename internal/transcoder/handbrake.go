@@ -0,0 +1,49 @@
+package transcoder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// HandBrakeImage runs HandBrakeCLI in the transcode job.
+const HandBrakeImage = "carolynvs/handbrakecli:1.2.0"
+
+type handbrake struct {
+	profile Profile
+}
+
+func (t handbrake) Image() string {
+	return t.profile.image(HandBrakeImage)
+}
+
+func (t handbrake) Command(job Job) ([]string, error) {
+	p := t.profile
+	args := []string{"HandBrakeCLI", "-i", job.InputPath, "-o", job.OutputPath}
+	if job.Start > 0 {
+		args = append(args, "--start-at", "seconds:"+seconds(job.Start))
+	}
+	if job.End > 0 {
+		// HandBrake stops after the duration, counted from the start
+		args = append(args, "--stop-at", "seconds:"+seconds(job.End-job.Start))
+	}
+	if p.Preset != "" {
+		return append(args, "--preset-import-file", job.PresetsFile, "--preset", p.Preset), nil
+	}
+
+	encoders := map[string]string{"h264": "x264", "h265": "x265"}
+	formats := map[string]string{"mkv": "av_mkv", "mp4": "av_mp4"}
+	audioEncoders := map[string]string{"aac": "av_aac", "ac3": "ac3", "copy": "copy"}
+
+	tracks := make([]string, len(p.AudioTracks))
+	for i, track := range p.AudioTracks {
+		tracks[i] = strconv.Itoa(track)
+	}
+
+	return append(args,
+		"--format", formats[p.Container],
+		"--encoder", encoders[p.VideoCodec],
+		"--quality", strconv.Itoa(p.Quality),
+		"--audio", strings.Join(tracks, ","),
+		"--aencoder", audioEncoders[p.AudioCodec],
+	), nil
+}
@@ -0,0 +1,100 @@
+package transcoder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Segment is a part of a video that is transcoded on its own, so that the
+// parts of a long video are transcoded in parallel and then concatenated.
+type Segment struct {
+	// Index orders the segments, starting at 0.
+	Index int
+
+	// Start and End are the times of the keyframes at the boundaries of the
+	// segment, in seconds. The last segment has an End of 0, and runs to the
+	// end of the video.
+	Start, End float64
+}
+
+// PlanSegments splits a video into at most count segments of about the same
+// length, each at least minDuration seconds long. Each segment starts at a
+// keyframe, the one closest to an even split, so that the segments line up
+// when they are concatenated. Fewer segments are returned when the keyframes
+// are too far apart, and none when the video is too short to split.
+func PlanSegments(duration float64, keyframes []float64, count int, minDuration float64) []Segment {
+	if minDuration > 0 && int(duration/minDuration) < count {
+		count = int(duration / minDuration)
+	}
+	if count < 2 || len(keyframes) == 0 {
+		return nil
+	}
+
+	var cuts []float64
+	for i := 1; i < count; i++ {
+		cut := closestKeyframe(keyframes, duration*float64(i)/float64(count))
+		prev := 0.0
+		if len(cuts) > 0 {
+			prev = cuts[len(cuts)-1]
+		}
+		if cut-prev < minDuration || duration-cut < minDuration || cut <= prev {
+			continue
+		}
+		cuts = append(cuts, cut)
+	}
+	if len(cuts) == 0 {
+		return nil
+	}
+
+	segments := make([]Segment, 0, len(cuts)+1)
+	start := 0.0
+	for i, cut := range cuts {
+		segments = append(segments, Segment{Index: i, Start: start, End: cut})
+		start = cut
+	}
+	return append(segments, Segment{Index: len(cuts), Start: start})
+}
+
+// closestKeyframe finds the keyframe nearest to a time, the keyframes are sorted.
+func closestKeyframe(keyframes []float64, t float64) float64 {
+	i := sort.SearchFloat64s(keyframes, t)
+	if i == len(keyframes) {
+		return keyframes[i-1]
+	}
+	if i > 0 && t-keyframes[i-1] < keyframes[i]-t {
+		return keyframes[i-1]
+	}
+	return keyframes[i]
+}
+
+// SegmentGlob matches the files of the transcoded segments.
+const SegmentGlob = "part-*"
+
+// SegmentFile is the file name of a transcoded segment, e.g. part-001.mkv.
+func SegmentFile(index int, container string) string {
+	return fmt.Sprintf("part-%03d.%s", index, container)
+}
+
+// ConcatCommand joins the transcoded segments listed in a concat file, in
+// the ffmpeg concat format, into a single video without encoding them again.
+// The command is run by ffmpeg in FFmpegImage.
+func ConcatCommand(listPath string, outputPath string, container string) []string {
+	formats := map[string]string{"mkv": "matroska", "mp4": "mp4"}
+	return []string{"ffmpeg", "-nostdin", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath,
+		"-map", "0", "-c", "copy",
+		"-f", formats[container],
+		outputPath,
+	}
+}
+
+// ConcatList is the content of the concat file, listing each segment file
+// in order. The paths are quoted, escaping any quotes in them.
+func ConcatList(paths []string) string {
+	var list strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(path, "'", `'\''`))
+	}
+	return list.String()
+}
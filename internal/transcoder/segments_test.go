@@ -0,0 +1,121 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanSegments(t *testing.T) {
+	// A keyframe every 10 seconds of an hour long video
+	var keyframes []float64
+	for t := 0.0; t < 3600; t += 10 {
+		keyframes = append(keyframes, t)
+	}
+
+	testcases := []struct {
+		name        string
+		duration    float64
+		keyframes   []float64
+		count       int
+		minDuration float64
+		want        []Segment
+	}{
+		{
+			name: "even split", duration: 3600, keyframes: keyframes, count: 3, minDuration: 300,
+			want: []Segment{{0, 0, 1200}, {1, 1200, 2400}, {2, 2400, 0}},
+		},
+		{
+			name: "closest keyframe", duration: 3600, keyframes: []float64{0, 1100, 1250, 3000}, count: 2, minDuration: 300,
+			want: []Segment{{0, 0, 1250}, {1, 1250, 0}},
+		},
+		{
+			name: "limited by the minimum duration", duration: 1000, keyframes: keyframes, count: 8, minDuration: 300,
+			want: []Segment{{0, 0, 330}, {1, 330, 670}, {2, 670, 0}},
+		},
+		{
+			name: "keyframes too far apart", duration: 3600, keyframes: []float64{0, 3500}, count: 4, minDuration: 300,
+		},
+		{
+			name: "sparse keyframes", duration: 3600, keyframes: []float64{0, 1000, 3000}, count: 4, minDuration: 300,
+			want: []Segment{{0, 0, 1000}, {1, 1000, 3000}, {2, 3000, 0}},
+		},
+		{
+			name: "too short", duration: 500, keyframes: keyframes, count: 4, minDuration: 300,
+		},
+		{
+			name: "single segment", duration: 3600, keyframes: keyframes, count: 1,
+		},
+		{
+			name: "no keyframes", duration: 3600, count: 4,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := PlanSegments(tc.duration, tc.keyframes, tc.count, tc.minDuration)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("expected %v, got %v", tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTranscoder_Command_Segment(t *testing.T) {
+	job := Job{InputPath: "/claim/movie.mkv", OutputPath: "/work/movie.mkv.parts/part-001.mkv",
+		PresetsFile: "/config/presets.json", Start: 600, End: 1200.5}
+
+	testcases := []struct {
+		name    string
+		profile Profile
+		want    string
+	}{
+		{
+			name:    "handbrake",
+			profile: Profile{Preset: "tivo"},
+			want:    "HandBrakeCLI -i /claim/movie.mkv -o /work/movie.mkv.parts/part-001.mkv --start-at seconds:600.000 --stop-at seconds:600.500 --preset-import-file /config/presets.json --preset tivo",
+		},
+		{
+			name:    "ffmpeg",
+			profile: Profile{Transcoder: FFmpeg},
+			want:    "ffmpeg -nostdin -y -ss 600.000 -t 600.500 -i /claim/movie.mkv -map 0:v:0 -map 0:a:0? -c:v libx264 -crf 20 -c:a aac -f matroska /work/movie.mkv.parts/part-001.mkv",
+		},
+		{
+			name:    "custom",
+			profile: Profile{Transcoder: Custom, Command: []string{"transcode", "--from={{.Start}}", "--to={{.End}}", "{{.InputPath}}"}},
+			want:    "transcode --from=600 --to=1200.5 /claim/movie.mkv",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := New(tc.profile)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			command, err := tr.Command(job)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if got := strings.Join(command, " "); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestConcatList(t *testing.T) {
+	got := ConcatList([]string{"/work/Bob's Show/e1.mkv.parts/part-000.mkv", "/work/Bob's Show/e1.mkv.parts/part-001.mkv"})
+	want := `file '/work/Bob'\''s Show/e1.mkv.parts/part-000.mkv'
+file '/work/Bob'\''s Show/e1.mkv.parts/part-001.mkv'
+`
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
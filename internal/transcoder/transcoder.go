@@ -0,0 +1,206 @@
+// Package transcoder builds the command that transcodes a video with
+// HandBrakeCLI, ffmpeg or a custom command, from a common encode profile.
+package transcoder
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Transcoder names.
+const (
+	// HandBrake transcodes with HandBrakeCLI, using either a HandBrake preset
+	// or the encode settings of the profile.
+	HandBrake = "handbrake"
+
+	// FFmpeg transcodes with ffmpeg, using the encode settings of the profile.
+	FFmpeg = "ffmpeg"
+
+	// Custom runs the command template of the profile.
+	Custom = "custom"
+)
+
+// Profile describes how to transcode a video. The encode settings are
+// translated into the arguments of each transcoder.
+type Profile struct {
+	// Transcoder is handbrake, ffmpeg or custom, defaults to handbrake.
+	Transcoder string `json:"transcoder,omitempty"`
+
+	// Image overrides the container image of the transcoder in the transcode
+	// job, and is required by the custom transcoder with the kubernetes executor.
+	Image string `json:"image,omitempty"`
+
+	// Preset is a HandBrake preset, used by handbrake instead of the encode settings.
+	Preset string `json:"preset,omitempty"`
+
+	// VideoCodec is h264 or h265, defaults to h264. The ffmpeg transcoder
+	// also supports copy, which keeps the video stream as is.
+	VideoCodec string `json:"videoCodec,omitempty"`
+
+	// Quality is the constant quality of the video, the HandBrake RF or the
+	// ffmpeg CRF, where lower is better. Defaults to 20.
+	Quality int `json:"quality,omitempty"`
+
+	// Container is the file format, mkv or mp4, defaults to mkv. The
+	// transcoded video keeps the file name of the original video.
+	Container string `json:"container,omitempty"`
+
+	// AudioCodec is aac, ac3 or copy, defaults to aac.
+	AudioCodec string `json:"audioCodec,omitempty"`
+
+	// AudioTracks are the audio tracks to keep, numbered from 1. Defaults to
+	// the first track.
+	AudioTracks []int `json:"audioTracks,omitempty"`
+
+	// Command is the command template run by the custom transcoder. Each
+	// argument is a template, executed with the Job, e.g. {{.InputPath}}.
+	// The segment of a split video is {{.Start}} to {{.End}}.
+	Command []string `json:"command,omitempty"`
+}
+
+// Default encode settings.
+const (
+	DefaultVideoCodec = "h264"
+	DefaultQuality    = 20
+	DefaultContainer  = "mkv"
+	DefaultAudioCodec = "aac"
+)
+
+var (
+	videoCodecs = []string{"h264", "h265", "copy"}
+	containers  = []string{"mkv", "mp4"}
+	audioCodecs = []string{"aac", "ac3", "copy"}
+)
+
+// WithDefaults fills in the encode settings that are not set.
+func (p Profile) WithDefaults() Profile {
+	if p.Transcoder == "" {
+		p.Transcoder = HandBrake
+	}
+	if p.VideoCodec == "" {
+		p.VideoCodec = DefaultVideoCodec
+	}
+	if p.Quality == 0 {
+		p.Quality = DefaultQuality
+	}
+	if p.Container == "" {
+		p.Container = DefaultContainer
+	}
+	if p.AudioCodec == "" {
+		p.AudioCodec = DefaultAudioCodec
+	}
+	if len(p.AudioTracks) == 0 {
+		p.AudioTracks = []int{1}
+	}
+	return p
+}
+
+// Validate checks that the transcoder and encode settings are supported.
+func (p Profile) Validate() error {
+	p = p.WithDefaults()
+
+	switch p.Transcoder {
+	case HandBrake:
+		if p.VideoCodec == "copy" {
+			return errors.New("the handbrake transcoder can't copy the video stream, use ffmpeg")
+		}
+	case FFmpeg:
+	case Custom:
+		if len(p.Command) == 0 {
+			return errors.New("the custom transcoder requires a command")
+		}
+	default:
+		return errors.Errorf("invalid transcoder %q, must be one of %s, %s or %s", p.Transcoder, HandBrake, FFmpeg, Custom)
+	}
+
+	settings := []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"videoCodec", p.VideoCodec, videoCodecs},
+		{"container", p.Container, containers},
+		{"audioCodec", p.AudioCodec, audioCodecs},
+	}
+	for _, s := range settings {
+		if !contains(s.allowed, s.value) {
+			return errors.Errorf("invalid %s %q, must be one of %s", s.name, s.value, strings.Join(s.allowed, ", "))
+		}
+	}
+
+	if p.Quality < 0 || p.Quality > 51 {
+		return errors.Errorf("invalid quality %d, must be between 0 and 51", p.Quality)
+	}
+	for _, track := range p.AudioTracks {
+		if track < 1 {
+			return errors.Errorf("invalid audio track %d, tracks are numbered from 1", track)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Job is a video to transcode.
+type Job struct {
+	// InputPath is the video to transcode.
+	InputPath string
+
+	// OutputPath is where the transcoded video is saved.
+	OutputPath string
+
+	// PresetsFile is the path to the HandBrake presets, used with a HandBrake preset.
+	PresetsFile string
+
+	// Start and End limit the transcode to a segment of the video, in
+	// seconds. An End of 0 transcodes to the end of the video.
+	Start, End float64
+}
+
+// seconds formats a time in seconds for the command line.
+func seconds(t float64) string {
+	return strconv.FormatFloat(t, 'f', 3, 64)
+}
+
+// Transcoder builds the command that transcodes a video.
+type Transcoder interface {
+	// Image is the container image that runs the command in the transcode job.
+	Image() string
+
+	// Command to run, including the program, to transcode the video.
+	Command(job Job) ([]string, error)
+}
+
+// New creates the transcoder selected by the profile.
+func New(profile Profile) (Transcoder, error) {
+	err := profile.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	profile = profile.WithDefaults()
+	switch profile.Transcoder {
+	case FFmpeg:
+		return ffmpeg{profile}, nil
+	case Custom:
+		return custom{profile}, nil
+	default:
+		return handbrake{profile}, nil
+	}
+}
+
+// image is the profile's image, falling back to the transcoder's default image.
+func (p Profile) image(defaultImage string) string {
+	if p.Image != "" {
+		return p.Image
+	}
+	return defaultImage
+}
@@ -0,0 +1,106 @@
+package transcoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranscoder_Command(t *testing.T) {
+	job := Job{InputPath: "/claim/TV/Show A/e1.mkv", OutputPath: "/work/TV/Show A/e1.mkv", PresetsFile: "/config/presets.json"}
+
+	testcases := []struct {
+		name      string
+		profile   Profile
+		wantImage string
+		want      string
+	}{
+		{
+			name:      "handbrake preset",
+			profile:   Profile{Preset: "tivo"},
+			wantImage: HandBrakeImage,
+			want:      "HandBrakeCLI -i /claim/TV/Show A/e1.mkv -o /work/TV/Show A/e1.mkv --preset-import-file /config/presets.json --preset tivo",
+		},
+		{
+			name:      "handbrake profile",
+			profile:   Profile{VideoCodec: "h265", Quality: 22, Container: "mp4", AudioTracks: []int{1, 2}},
+			wantImage: HandBrakeImage,
+			want:      "HandBrakeCLI -i /claim/TV/Show A/e1.mkv -o /work/TV/Show A/e1.mkv --format av_mp4 --encoder x265 --quality 22 --audio 1,2 --aencoder av_aac",
+		},
+		{
+			name:      "ffmpeg defaults",
+			profile:   Profile{Transcoder: FFmpeg},
+			wantImage: FFmpegImage,
+			want:      "ffmpeg -nostdin -y -i /claim/TV/Show A/e1.mkv -map 0:v:0 -map 0:a:0? -c:v libx264 -crf 20 -c:a aac -f matroska /work/TV/Show A/e1.mkv",
+		},
+		{
+			name:      "ffmpeg audio tracks",
+			profile:   Profile{Transcoder: FFmpeg, Image: "ffmpeg:latest", AudioCodec: "copy", AudioTracks: []int{2, 3}},
+			wantImage: "ffmpeg:latest",
+			want:      "ffmpeg -nostdin -y -i /claim/TV/Show A/e1.mkv -map 0:v:0 -map 0:a:1? -map 0:a:2? -c:v libx264 -crf 20 -c:a copy -f matroska /work/TV/Show A/e1.mkv",
+		},
+		{
+			name:      "ffmpeg remux",
+			profile:   Profile{Transcoder: FFmpeg, VideoCodec: "copy", AudioCodec: "copy", Container: "mp4"},
+			wantImage: FFmpegImage,
+			want:      "ffmpeg -nostdin -y -i /claim/TV/Show A/e1.mkv -map 0:v:0 -map 0:a:0? -c:v copy -c:a copy -f mp4 /work/TV/Show A/e1.mkv",
+		},
+		{
+			name:      "custom",
+			profile:   Profile{Transcoder: Custom, Image: "mytranscoder", Command: []string{"transcode", "--in={{.InputPath}}", "{{.OutputPath}}"}},
+			wantImage: "mytranscoder",
+			want:      "transcode --in=/claim/TV/Show A/e1.mkv /work/TV/Show A/e1.mkv",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := New(tc.profile)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if tr.Image() != tc.wantImage {
+				t.Fatalf("expected image %s, got %s", tc.wantImage, tr.Image())
+			}
+
+			command, err := tr.Command(job)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if got := strings.Join(command, " "); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestProfile_Validate(t *testing.T) {
+	testcases := []struct {
+		name    string
+		profile Profile
+		wantErr bool
+	}{
+		{"defaults", Profile{}, false},
+		{"unknown transcoder", Profile{Transcoder: "vlc"}, true},
+		{"custom without command", Profile{Transcoder: Custom}, true},
+		{"unknown codec", Profile{VideoCodec: "vp9"}, true},
+		{"handbrake can't copy video", Profile{VideoCodec: "copy"}, true},
+		{"unknown container", Profile{Container: "avi"}, true},
+		{"unknown audio codec", Profile{AudioCodec: "mp3"}, true},
+		{"quality out of range", Profile{Quality: 60}, true},
+		{"audio tracks start at 1", Profile{AudioTracks: []int{0}}, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.profile.Validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the configuration of the watcher, loaded from a yaml or json file.
+type Config struct {
+	// ConfigVolume contains the job templates.
+	ConfigVolume string `json:"configVolume"`
+
+	// WatchVolume contains the watch and failed directories.
+	WatchVolume string `json:"watchVolume"`
+
+	// WorkVolume contains the claim and transcoded directories.
+	WorkVolume string `json:"workVolume"`
+
+	// DirectoryNames are the names of the directories on the watch and work volumes.
+	DirectoryNames DirectoryNames `json:"directories"`
+
+	// StableThreshold is how long a video must not change before it is processed.
+	StableThreshold metav1.Duration `json:"stableThreshold"`
+
+	// DefaultPreset is used for every video when the PresetConfig file doesn't exist.
+	DefaultPreset string `json:"defaultPreset"`
+
+	// PresetConfig is the path to the preset configuration, see PresetConfig.
+	// Defaults to presets/presets.yaml in the config volume.
+	PresetConfig string `json:"presetConfig,omitempty"`
+
+	// HandBrakePresets is the path to the HandBrake presets used by the
+	// transcode jobs. Defaults to ghb/presets.json in the config volume.
+	HandBrakePresets string `json:"handbrakePresets,omitempty"`
+
+	// ReplacePolicy decides if an existing job with the same name as a new
+	// job is replaced, defaults to replace-if-finished.
+	ReplacePolicy jobs.ReplacePolicy `json:"replacePolicy"`
+
+	// Probe reads the media info of each video before it is transcoded, so
+	// that the rules in the preset configuration can skip or adapt the transcode.
+	Probe ProbeConfig `json:"probe"`
+
+	// Segments splits long videos at their keyframes into segments that are
+	// transcoded in parallel, and then concatenated. Requires probing.
+	Segments SegmentConfig `json:"segments"`
+
+	// Queue orders the videos waiting to be transcoded by priority, and
+	// limits how many are transcoded at the same time.
+	Queue QueueConfig `json:"queue"`
+
+	// Executor runs the transcode and upload steps of each video. The
+	// kubernetes executor creates a job for each step, and the local executor
+	// runs them as subprocesses of the watcher, without a cluster.
+	Executor string `json:"executor"`
+
+	// Local configures the local executor.
+	Local LocalConfig `json:"local"`
+
+	// MetricsAddress is the address on which to serve Prometheus metrics.
+	MetricsAddress string `json:"metricsAddress"`
+
+	// Plex is the server and share where videos are uploaded.
+	Plex PlexConfig `json:"plex"`
+}
+
+// ProbeConfig configures how videos are probed.
+type ProbeConfig struct {
+	// Enabled probes each video before it is transcoded. Corrupt videos are
+	// moved to the failed directory.
+	Enabled bool `json:"enabled"`
+
+	// Command is the path to ffprobe, defaults to ffprobe.
+	Command string `json:"command,omitempty"`
+}
+
+// Prober probes the videos, and is nil when probing is disabled.
+func (c ProbeConfig) Prober() probe.Prober {
+	if !c.Enabled {
+		return nil
+	}
+	return probe.FFprobe{Command: c.Command}
+}
+
+// MaxSegments limits how many segments a video is split into.
+const MaxSegments = 100
+
+// SegmentConfig configures how videos are split into segments.
+type SegmentConfig struct {
+	// Count is how many segments each video is split into, and 0 or 1
+	// transcodes each video in a single job.
+	Count int `json:"count"`
+
+	// MinDuration is the shortest segment. Shorter videos are split into
+	// fewer segments, or aren't split at all.
+	MinDuration metav1.Duration `json:"minDuration"`
+}
+
+// Enabled checks if videos are split.
+func (c SegmentConfig) Enabled() bool {
+	return c.Count > 1
+}
+
+// LocalConfig configures the local executor. The transcode command is built
+// by the transcoder of the video's preset, see PresetConfig.Profiles.
+type LocalConfig struct {
+	// Concurrency is how many steps run at the same time.
+	Concurrency int `json:"concurrency"`
+
+	// UploadCommand uploads a transcoded video to Plex, defaults to the
+	// uploader. Each argument is a template, executed with the same values
+	// as the upload job template, for example {{.TranscodedFile}}. The Plex token is passed in the PLEX_TOKEN environment variable.
+	UploadCommand []string `json:"uploadCommand"`
+}
+
+// DefaultLocalConfig is the local executor configuration used for settings
+// that are not in the config file.
+func DefaultLocalConfig() LocalConfig {
+	return LocalConfig{
+		Concurrency: 1,
+		UploadCommand: []string{
+			"uploader",
+			"-f", "{{.TranscodedFile}}",
+			"--suffix", "{{.DestinationSuffix}}",
+			"--plex-server", "{{.PlexServer}}",
+			"--plex-library", "{{.PlexLibrary}}",
+			"--plex-share", "{{.PlexShare}}",
+			"--raw", "{{.RawFile}}",
+		},
+	}
+}
+
+// DirectoryNames are the names of the directories that track the progress of a video.
+type DirectoryNames struct {
+	Watch string `json:"watch"`
+	Claim string `json:"claim"`
+	Work  string `json:"work"`
+	Fail  string `json:"fail"`
+}
+
+// PlexConfig is the Plex server and share where videos are uploaded.
+type PlexConfig struct {
+	// Server is the base URL of the Plex server, for example http://192.168.0.105:32400
+	Server string `json:"server"`
+
+	// Token authenticates with the Plex server. Prefer the PLEX_TOKEN
+	// environment variable over saving the token in the config file.
+	Token string `json:"token,omitempty"`
+
+	// Share is where the Plex libraries are mounted in the upload jobs.
+	Share string `json:"share"`
+}
+
+// DefaultDirectoryNames are the directory names used when they are not configured.
+var DefaultDirectoryNames = DirectoryNames{
+	Watch: "watch",
+	Claim: "claim",
+	Work:  "work",
+	Fail:  "fail",
+}
+
+// DefaultConfig is the configuration used for settings that are not in the config file.
+func DefaultConfig() Config {
+	return Config{
+		ConfigVolume:    "/config",
+		WatchVolume:     "/",
+		WorkVolume:      "/",
+		DirectoryNames:  DefaultDirectoryNames,
+		StableThreshold: metav1.Duration{Duration: 5 * time.Second},
+		DefaultPreset:   "tivo",
+		ReplacePolicy:   jobs.DefaultReplacePolicy,
+		Segments:        SegmentConfig{MinDuration: metav1.Duration{Duration: 5 * time.Minute}},
+		Executor:        KubernetesExecutor,
+		Local:           DefaultLocalConfig(),
+		MetricsAddress:  ":9090",
+		Plex: PlexConfig{
+			Share: "/plex",
+		},
+	}
+}
+
+// LoadConfig reads the configuration from a yaml or json file. Settings that
+// are not in the file keep their default value.
+func LoadConfig(path string) (Config, error) {
+	c := DefaultConfig()
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, errors.Wrapf(err, "could not read %s", path)
+	}
+
+	err = yaml.UnmarshalStrict(contents, &c)
+	if err != nil {
+		return c, errors.Wrapf(err, "unable to parse the watcher configuration in %s", path)
+	}
+
+	return c, nil
+}
+
+// Validate checks that the required settings are present.
+func (c Config) Validate() error {
+	required := []struct{ setting, value string }{
+		{"configVolume", c.ConfigVolume},
+		{"watchVolume", c.WatchVolume},
+		{"workVolume", c.WorkVolume},
+		{"directories.watch", c.DirectoryNames.Watch},
+		{"directories.claim", c.DirectoryNames.Claim},
+		{"directories.work", c.DirectoryNames.Work},
+		{"directories.fail", c.DirectoryNames.Fail},
+		{"defaultPreset", c.DefaultPreset},
+		{"plex.server", c.Plex.Server},
+		{"plex.token", c.Plex.Token},
+		{"plex.share", c.Plex.Share},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return errors.Errorf("%s is required", r.setting)
+		}
+	}
+
+	if c.StableThreshold.Duration <= 0 {
+		return errors.Errorf("stableThreshold must be positive, got %s", c.StableThreshold.Duration)
+	}
+
+	err := c.ReplacePolicy.Validate()
+	if err != nil {
+		return errors.Wrap(err, "invalid replacePolicy")
+	}
+
+	if c.Segments.Count < 0 || c.Segments.Count > MaxSegments {
+		return errors.Errorf("segments.count must be between 0 and %d, got %d", MaxSegments, c.Segments.Count)
+	}
+	if c.Segments.MinDuration.Duration < 0 {
+		return errors.Errorf("segments.minDuration can't be negative, got %s", c.Segments.MinDuration.Duration)
+	}
+	if c.Segments.Enabled() && !c.Probe.Enabled {
+		return errors.New("segments requires probe.enabled, the keyframes of each video are needed to split it")
+	}
+
+	err = c.Queue.Validate()
+	if err != nil {
+		return err
+	}
+
+	switch c.Executor {
+	case KubernetesExecutor:
+	case LocalExecutor:
+		if c.Local.Concurrency <= 0 {
+			return errors.Errorf("local.concurrency must be positive, got %d", c.Local.Concurrency)
+		}
+		if len(c.Local.UploadCommand) == 0 {
+			return errors.New("local.uploadCommand is required by the local executor")
+		}
+	default:
+		return errors.Errorf("invalid executor %q, must be %s or %s", c.Executor, KubernetesExecutor, LocalExecutor)
+	}
+
+	// The watch and failed directories share a volume, as do the claim and transcoded directories
+	dirs := c.Directories()
+	seen := make(map[string]bool, 4)
+	for _, dir := range []string{dirs.WatchDir, dirs.ClaimDir, dirs.TranscodedDir, dirs.FailedDir} {
+		if seen[dir] {
+			return errors.Errorf("the watch, claim, work and fail directories must be different, %s is used more than once", dir)
+		}
+		seen[dir] = true
+	}
+
+	return nil
+}
+
+// Directories lays out the video directories on the watch and work volumes.
+func (c Config) Directories() Directories {
+	return c.DirectoryNames.Layout(c.WatchVolume, c.WorkVolume)
+}
+
+// TemplatesDir contains templates for the jobs that are created by the watcher.
+func (c Config) TemplatesDir() string {
+	return filepath.Join(c.ConfigVolume, "templates")
+}
+
+// PlexLibraryConfig is the connection information used to upload videos to Plex.
+func (c Config) PlexLibraryConfig() plex.LibraryConfig {
+	return plex.LibraryConfig{
+		ServerConfig: plex.ServerConfig{URL: c.Plex.Server, Token: c.Plex.Token},
+		Share:        c.Plex.Share,
+	}
+}
+
+// HandBrakePresetsFile is the path to the HandBrake presets used to transcode videos.
+func (c Config) HandBrakePresetsFile() string {
+	if c.HandBrakePresets == "" {
+		return filepath.Join(c.ConfigVolume, "ghb", "presets.json")
+	}
+	return c.HandBrakePresets
+}
+
+// LoadPresets reads the preset configuration, falling back to DefaultPreset
+// when it doesn't exist, and validates that the presets are defined in the
+// HandBrake presets file. Custom profiles must have an image when they run
+// on the kubernetes executor.
+func (c Config) LoadPresets() (PresetConfig, error) {
+	presetConfig := c.PresetConfig
+	if presetConfig == "" {
+		presetConfig = filepath.Join(c.ConfigVolume, "presets", "presets.yaml")
+	}
+	handbrakePresets := c.HandBrakePresetsFile()
+
+	presets := PresetConfig{Default: c.DefaultPreset}
+	if _, err := os.Stat(presetConfig); err == nil {
+		presets, err = LoadPresetConfig(presetConfig)
+		if err != nil {
+			return presets, err
+		}
+	} else {
+		log.Printf("%s not found, using the %s preset for every video\n", presetConfig, c.DefaultPreset)
+	}
+
+	available, err := handbrake.LoadPresetNames(handbrakePresets)
+	if err != nil {
+		return presets, err
+	}
+
+	err = presets.Validate(available)
+	if err != nil {
+		return presets, err
+	}
+	if c.Executor == KubernetesExecutor {
+		err = presets.validateImages()
+	}
+	return presets, err
+}
@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig("../../cmd/watcher/watcher.yaml")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if c.WatchVolume != "/ponyshare/handbrk8s" {
+		t.Fatalf("expected the watch volume to be loaded, got %s", c.WatchVolume)
+	}
+	if c.StableThreshold.Duration != 5*time.Second {
+		t.Fatalf("expected the stable threshold to be 5s, got %s", c.StableThreshold.Duration)
+	}
+	if c.Plex.Token != "" {
+		t.Fatalf("expected the token to be left to the environment, got %s", c.Plex.Token)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() Config {
+		c := DefaultConfig()
+		c.Plex.Server = "http://localhost:32400"
+		c.Plex.Token = "abc123"
+		return c
+	}
+
+	testcases := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{"valid", func(c *Config) {}, false},
+		{"missing plex server", func(c *Config) { c.Plex.Server = "" }, true},
+		{"missing plex token", func(c *Config) { c.Plex.Token = "" }, true},
+		{"missing directory name", func(c *Config) { c.DirectoryNames.Claim = "" }, true},
+		{"zero stable threshold", func(c *Config) { c.StableThreshold.Duration = 0 }, true},
+		{"invalid replace policy", func(c *Config) { c.ReplacePolicy = "sometimes" }, true},
+		{"invalid executor", func(c *Config) { c.Executor = "docker" }, true},
+		{"local executor", func(c *Config) { c.Executor = LocalExecutor }, false},
+		{"local executor without concurrency", func(c *Config) {
+			c.Executor = LocalExecutor
+			c.Local.Concurrency = 0
+		}, true},
+		{"segments", func(c *Config) {
+			c.Segments.Count = 4
+			c.Probe.Enabled = true
+		}, false},
+		{"segments without probing", func(c *Config) { c.Segments.Count = 4 }, true},
+		{"too many segments", func(c *Config) {
+			c.Segments.Count = MaxSegments + 1
+			c.Probe.Enabled = true
+		}, true},
+		{"negative queue concurrency", func(c *Config) { c.Queue.Concurrency = -1 }, true},
+		{"queue class without a name", func(c *Config) {
+			c.Queue.Classes = []PriorityClass{{MinPriority: 100}}
+		}, true},
+		{"duplicate directory", func(c *Config) { c.DirectoryNames.Work = c.DirectoryNames.Claim }, true},
+		{"same names on different volumes", func(c *Config) {
+			c.WorkVolume = "/work"
+			c.DirectoryNames.Claim = c.DirectoryNames.Watch
+		}, false},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid()
+			tc.modify(&c)
+			err := c.Validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
+
+func TestVideoWatcher_Reload(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	dirs := w.Directories
+
+	c := DefaultConfig()
+	c.ConfigVolume = "../../manifests"
+	c.WatchVolume = "/somewhere/else"
+	c.HandBrakePresets = "../../cmd/handbrakecli/presets.json"
+	c.StableThreshold.Duration = time.Minute
+	c.Plex.Server = "http://localhost:32400"
+	c.Plex.Token = "abc123"
+
+	err := w.Reload(c)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if w.Directories != dirs {
+		t.Fatalf("expected the directories to be unchanged until the watcher is restarted, got %#v", w.Directories)
+	}
+	if w.TemplatesDir != filepath.Join("../../manifests", "templates") {
+		t.Fatalf("expected the templates directory to be reloaded, got %s", w.TemplatesDir)
+	}
+	if w.PlexCfg.URL != "http://localhost:32400" || w.PlexCfg.Token != "abc123" {
+		t.Fatalf("expected the Plex configuration to be reloaded, got %#v", w.PlexCfg)
+	}
+	if w.StableThreshold != time.Minute {
+		t.Fatalf("expected the stable threshold to be reloaded, got %s", w.StableThreshold)
+	}
+
+	c.DefaultPreset = "vhs"
+	err = w.Reload(c)
+	if err == nil {
+		t.Fatal("expected a reload with an undefined preset to fail")
+	}
+	if w.Presets.Default != "tivo" {
+		t.Fatalf("expected the previous presets to be kept, got %#v", w.Presets)
+	}
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Directories tracks the progress of a video by the directory that holds it.
+type Directories struct {
+	// WatchDir contains raw (untranscoded) video files.
+	WatchDir string
+
+	// ClaimDir temporarily holds raw video files while they are being transcoded.
+	ClaimDir string
+
+	// TranscodedDir contains completed (transcoded) video files.
+	TranscodedDir string
+
+	// FailedDir holds raw video files that could not be processed.
+	FailedDir string
+}
+
+// NewDirectories lays out the video directories on the watch and work volumes,
+// using the default directory names.
+func NewDirectories(watchVolume, workVolume string) Directories {
+	return DefaultDirectoryNames.Layout(watchVolume, workVolume)
+}
+
+// Layout places the video directories on the watch and work volumes.
+func (n DirectoryNames) Layout(watchVolume, workVolume string) Directories {
+	return Directories{
+		WatchDir:      filepath.Join(watchVolume, n.Watch),
+		FailedDir:     filepath.Join(watchVolume, n.Fail),
+		ClaimDir:      filepath.Join(workVolume, n.Claim),
+		TranscodedDir: filepath.Join(workVolume, n.Work),
+	}
+}
+
+// Requeue moves a claimed or failed video back into the watch directory,
+// so that it is processed again. The path is relative to the watch directory.
+func (d Directories) Requeue(pathSuffix string) error {
+	watchPath := filepath.Join(d.WatchDir, pathSuffix)
+	for _, dir := range []string{d.FailedDir, d.ClaimDir} {
+		src := filepath.Join(dir, pathSuffix)
+		_, err := os.Stat(src)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "cannot stat %s", src)
+		}
+
+		log.Printf("requeuing %s\n", src)
+		return moveFile(src, watchPath)
+	}
+
+	return errors.Errorf("unable to requeue %s, it is not in the claim or failed directories", pathSuffix)
+}
+
+// Fail moves a claimed video into the failed directory.
+// The path is relative to the watch directory.
+func (d Directories) Fail(pathSuffix string) error {
+	claimPath := filepath.Join(d.ClaimDir, pathSuffix)
+	failedPath := filepath.Join(d.FailedDir, pathSuffix)
+
+	log.Printf("cleaning up failed claim: %s\n", claimPath)
+	err := moveFile(claimPath, failedPath)
+	return errors.Wrap(err, "unable to cleanup failed claim")
+}
+
+// moveFile renames a file, creating the destination directory if necessary.
+func moveFile(src, dest string) error {
+	destDir := filepath.Dir(dest)
+	err := os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create directory %s", destDir)
+	}
+
+	err = os.Rename(src, dest)
+	return errors.Wrapf(err, "unable to move %s to %s", src, dest)
+}
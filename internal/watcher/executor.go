@@ -0,0 +1,199 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KubernetesExecutor runs each step as a job on the cluster.
+	KubernetesExecutor = "kubernetes"
+
+	// LocalExecutor runs each step as a subprocess of the watcher.
+	LocalExecutor = "local"
+)
+
+// Step is a transcode or upload of a video, run by an Executor.
+type Step struct {
+	// Type of the step, TranscodeJobType or UploadJobType, which selects
+	// the job template. The segments of a split video, and the job that
+	// joins them, are transcode steps.
+	Type string
+
+	// Video is the VideoTranscode that the step processes.
+	Video *transcodes.VideoTranscode
+
+	// Values are used by the job template or command of the step,
+	// transcodeJobValues or uploadJobValues.
+	Values interface{}
+}
+
+// Executor runs the steps that process each video.
+type Executor interface {
+	// Start a step, returning the name of the run, e.g. the job name, which
+	// is saved on the VideoTranscode status. When the step is already
+	// running for the video, the duplicate is skipped and the name of the
+	// existing run is returned.
+	Start(step Step) (name string, err error)
+
+	// Result of a run, returning false when it hasn't finished yet. A run
+	// that no longer exists has the Deleted outcome.
+	Result(name string) (jobs.Result, bool)
+
+	// Stop a run that is no longer needed, for example the other segments
+	// of a video when one of them fails. Stopping a run that no longer
+	// exists is not an error.
+	Stop(name string) error
+
+	// Follow sends the name of a video each time one of its runs changes,
+	// until done is closed.
+	Follow(done <-chan struct{}) (<-chan string, <-chan error)
+}
+
+// runName is the name of the run for a step, the same as the name of its job.
+func runName(step Step) string {
+	if values, ok := step.Values.(transcodeJobValues); ok && values.JobName != "" {
+		return values.JobName
+	}
+	return fmt.Sprintf("%s-%s", step.Video.Name, step.Type)
+}
+
+// deletedRunResult is the result of a run that no longer exists, for
+// example when the job was cancelled from the dashboard.
+func deletedRunResult(name string) jobs.Result {
+	return jobs.Result{
+		Namespace: Namespace,
+		Name:      name,
+		Outcome:   jobs.Deleted,
+		Reason:    reasonJobDeleted,
+		Message:   fmt.Sprintf("%s was deleted before it finished", name),
+	}
+}
+
+// kubernetesExecutor runs each step as a job, created from the job template
+// for the step in the templates directory, e.g. transcode.yaml.
+type kubernetesExecutor struct {
+	w         *VideoWatcher
+	client    kubernetes.Interface
+	jobClient jobs.Client
+}
+
+func newKubernetesExecutor(w *VideoWatcher, client kubernetes.Interface) *kubernetesExecutor {
+	return &kubernetesExecutor{w: w, client: client, jobClient: jobs.NewClient(client)}
+}
+
+// Start creates the job for a step, owned by the VideoTranscode so that the
+// job is removed along with it. An existing job is replaced according to the
+// ReplacePolicy. When the job is already running for this VideoTranscode,
+// for example because its status could not be saved after the job was
+// created, the duplicate is skipped and the running job is used.
+func (e *kubernetesExecutor) Start(step Step) (jobName string, err error) {
+	e.w.mu.RLock()
+	templateFile := filepath.Join(e.w.TemplatesDir, step.Type+".yaml")
+	opts := jobs.CreateOptions{Policy: e.w.ReplacePolicy}
+	e.w.mu.RUnlock()
+
+	template, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %s", templateFile)
+	}
+
+	j, err := jobs.BuildFromTemplate(string(template), step.Values)
+	if err != nil {
+		return "", err
+	}
+	j.OwnerReferences = append(j.OwnerReferences, step.Video.OwnerReference())
+
+	jobName, err = e.jobClient.CreateOrReplace(j, opts)
+	if existsErr, ok := errors.Cause(err).(jobs.JobExistsError); ok {
+		existing := existsErr.Existing
+		if !existsErr.Finished() && metav1.IsControlledBy(existing, step.Video) {
+			log.Printf("skipping duplicate job %s, it is already running for %s\n", existing.Name, step.Video.Name)
+			return existing.Name, nil
+		}
+	}
+	return jobName, err
+}
+
+// Result looks up the result of a job.
+func (e *kubernetesExecutor) Result(name string) (jobs.Result, bool) {
+	j, err := e.client.BatchV1().Jobs(Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return deletedRunResult(name), true
+	}
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to get %s", name))
+		return jobs.Result{}, false
+	}
+	return jobs.ResultOf(j)
+}
+
+// Stop deletes a job, along with its pods.
+func (e *kubernetesExecutor) Stop(name string) error {
+	return e.jobClient.Delete(name, Namespace)
+}
+
+// Follow the jobs that have the video label.
+func (e *kubernetesExecutor) Follow(done <-chan struct{}) (<-chan string, <-chan error) {
+	videoChan := make(chan string)
+	errChan := make(chan error)
+
+	hasVideo, err := labels.NewRequirement(VideoLabel, selection.Exists, nil)
+	if err != nil {
+		go func() {
+			defer close(videoChan)
+			defer close(errChan)
+			select {
+			case <-done:
+			case errChan <- errors.Wrap(err, "unable to select the jobs for each video"):
+			}
+		}()
+		return videoChan, errChan
+	}
+	set := jobs.JobSet{Selector: labels.NewSelector().Add(*hasVideo)}
+	jobChan, jobErrs := e.jobClient.Follow(done, Namespace, set, 0)
+
+	go func() {
+		defer close(videoChan)
+		defer close(errChan)
+
+		for {
+			select {
+			case <-done:
+				return
+			case err, ok := <-jobErrs:
+				if !ok {
+					return
+				}
+				select {
+				case <-done:
+				case errChan <- errors.Wrap(err, "unable to follow the jobs"):
+				}
+				return
+			case j, ok := <-jobChan:
+				if !ok {
+					return
+				}
+				select {
+				case <-done:
+					return
+				case videoChan <- j.Labels[VideoLabel]:
+				}
+			}
+		}
+	}()
+
+	return videoChan, errChan
+}
@@ -0,0 +1,270 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+)
+
+// Reasons that a local run failed.
+const (
+	reasonExitCode    = "ExitCode"
+	reasonStartFailed = "StartFailed"
+)
+
+// localExecutor runs each step as a subprocess of the watcher, so that
+// videos can be processed without a cluster. At most Concurrency steps run
+// at the same time, the rest wait their turn.
+type localExecutor struct {
+	// commands are the command templates for each step type. The transcode
+	// step runs the command built by the transcoder instead.
+	commands map[string][]string
+
+	// slots limits how many steps run at the same time.
+	slots chan struct{}
+
+	mu   sync.Mutex
+	runs map[string]*localRun
+
+	// followers are notified of the videos whose runs changed.
+	followers map[*localFollower]bool
+}
+
+type localRun struct {
+	video    string
+	result   jobs.Result
+	finished bool
+
+	// cmd is the running command, once the run has a slot.
+	cmd *exec.Cmd
+
+	// stopped is set when the run is stopped before it finished.
+	stopped bool
+}
+
+type localFollower struct {
+	changed map[string]bool
+	notify  chan struct{}
+}
+
+func newLocalExecutor(cfg LocalConfig) *localExecutor {
+	return &localExecutor{
+		commands: map[string][]string{
+			UploadJobType: cfg.UploadCommand,
+		},
+		slots:     make(chan struct{}, cfg.Concurrency),
+		runs:      make(map[string]*localRun),
+		followers: make(map[*localFollower]bool),
+	}
+}
+
+// Start runs the command for a step in the background, once there is a free slot.
+func (e *localExecutor) Start(step Step) (name string, err error) {
+	name = runName(step)
+
+	args, err := e.buildCommand(step)
+	if err != nil {
+		return "", err
+	}
+
+	e.mu.Lock()
+	if run, ok := e.runs[name]; ok && !run.finished {
+		e.mu.Unlock()
+		log.Printf("skipping duplicate run %s, it is already running for %s\n", name, step.Video.Name)
+		return name, nil
+	}
+	run := &localRun{video: step.Video.Name}
+	e.runs[name] = run
+	e.mu.Unlock()
+
+	log.Printf("queued local run: %s", name)
+	go e.run(name, run, step, args)
+	return name, nil
+}
+
+// buildCommand executes the command template for a step.
+func (e *localExecutor) buildCommand(step Step) ([]string, error) {
+	if values, ok := step.Values.(transcodeJobValues); ok {
+		if len(values.Command) == 0 {
+			return nil, errors.Errorf("no transcoder command for %s", step.Video.Name)
+		}
+		return values.Command, nil
+	}
+
+	templates := e.commands[step.Type]
+	if len(templates) == 0 {
+		return nil, errors.Errorf("no command is configured for the %s step", step.Type)
+	}
+
+	args := make([]string, len(templates))
+	for i, t := range templates {
+		arg, err := api.ProcessTemplate(t, step.Values)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to build the %s command", step.Type)
+		}
+		args[i] = string(arg)
+	}
+	return args, nil
+}
+
+func (e *localExecutor) run(name string, run *localRun, step Step, args []string) {
+	e.slots <- struct{}{}
+	defer func() { <-e.slots }()
+
+	log.Printf("running %s: %s", name, strings.Join(args, " "))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	switch values := step.Values.(type) {
+	case transcodeJobValues:
+		// The transcode job template does this in an init container
+		err := os.MkdirAll(values.OutputDir, 0755)
+		if err != nil {
+			e.finish(name, run, reasonStartFailed, errors.Wrapf(err, "unable to create %s", values.OutputDir).Error())
+			return
+		}
+	case uploadJobValues:
+		// Keep the token out of the command line, the upload job template
+		// sets it from a secret
+		cmd.Env = append(cmd.Env, "PLEX_TOKEN="+values.PlexToken)
+	}
+
+	// Don't start a run that was stopped while it waited for a slot
+	e.mu.Lock()
+	if run.stopped {
+		e.mu.Unlock()
+		e.finish(name, run, "", "")
+		return
+	}
+	err := cmd.Start()
+	if err == nil {
+		run.cmd = cmd
+	}
+	e.mu.Unlock()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		e.finish(name, run, reasonExitCode, fmt.Sprintf("%s exited with code %d", args[0], exitErr.ExitCode()))
+		return
+	}
+	if err != nil {
+		e.finish(name, run, reasonStartFailed, errors.Wrapf(err, "unable to run %s", args[0]).Error())
+		return
+	}
+	e.finish(name, run, "", "")
+}
+
+// finish records the result of a run, which failed when reason is set, and
+// notifies the followers. A run that was stopped is recorded as deleted.
+func (e *localExecutor) finish(name string, run *localRun, reason, message string) {
+	result := jobs.Result{Namespace: Namespace, Name: name, Outcome: jobs.Succeeded}
+	if reason != "" {
+		result.Outcome = jobs.Failed
+		result.Reason = reason
+		result.Message = message
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if run.stopped {
+		result = deletedRunResult(name)
+		result.Message = fmt.Sprintf("%s was stopped before it finished", name)
+	}
+	run.result = result
+	run.finished = true
+	log.Printf("%s %s %s %s\n", name, strings.ToLower(string(result.Outcome)), result.Reason, result.Message)
+	for f := range e.followers {
+		f.changed[run.video] = true
+		select {
+		case f.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Result of a run. The runs are kept in memory, so a run from before the
+// watcher restarted is reported as deleted.
+func (e *localExecutor) Result(name string) (jobs.Result, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	run, ok := e.runs[name]
+	if !ok {
+		return deletedRunResult(name), true
+	}
+	return run.result, run.finished
+}
+
+// Stop kills the command of a run, or keeps it from starting when it is
+// waiting for a slot.
+func (e *localExecutor) Stop(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	run, ok := e.runs[name]
+	if !ok || run.finished || run.stopped {
+		return nil
+	}
+	log.Printf("stopping local run: %s", name)
+	run.stopped = true
+	if run.cmd != nil {
+		// The command may have exited on its own, and the run is
+		// finished either way
+		run.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// Follow sends the name of a video each time one of its runs finishes.
+func (e *localExecutor) Follow(done <-chan struct{}) (<-chan string, <-chan error) {
+	videoChan := make(chan string)
+	errChan := make(chan error)
+
+	f := &localFollower{changed: make(map[string]bool), notify: make(chan struct{}, 1)}
+	e.mu.Lock()
+	e.followers[f] = true
+	e.mu.Unlock()
+
+	go func() {
+		defer close(videoChan)
+		defer close(errChan)
+		defer func() {
+			e.mu.Lock()
+			delete(e.followers, f)
+			e.mu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-f.notify:
+			}
+
+			e.mu.Lock()
+			changed := f.changed
+			f.changed = make(map[string]bool)
+			e.mu.Unlock()
+
+			for video := range changed {
+				select {
+				case <-done:
+					return
+				case videoChan <- video:
+				}
+			}
+		}
+	}()
+
+	return videoChan, errChan
+}
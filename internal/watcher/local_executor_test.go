@@ -0,0 +1,196 @@
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+)
+
+// writeTestScript saves a shell script that stands in for a step's command.
+func writeTestScript(t *testing.T, path, script string) {
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\nset -e\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+// waitForRun waits until a local run finishes, returning its result.
+func waitForRun(t *testing.T, e *localExecutor, name string) jobs.Result {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if result, finished := e.Result(name); finished {
+			return result
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLocalExecutor_Start(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "executor")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Fail when another transcode is running at the same time
+	script := filepath.Join(tmpDir, "transcode.sh")
+	writeTestScript(t, script, `
+mkdir "$0.lock"
+sleep 0.1
+rmdir "$0.lock"
+[ "$1" != "fail" ]`)
+
+	e := newLocalExecutor(LocalConfig{Concurrency: 1})
+
+	var names []string
+	for i, preset := range []string{"tivo", "tivo", "fail"} {
+		vt := transcodes.New(fmt.Sprintf("video%d", i), Namespace, transcodes.VideoTranscodeSpec{})
+		values := transcodeJobValues{Command: []string{script, preset}, OutputDir: filepath.Join(tmpDir, "out")}
+		name, err := e.Start(Step{Type: TranscodeJobType, Video: vt, Values: values})
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		names = append(names, name)
+	}
+
+	for _, name := range names[:2] {
+		if result := waitForRun(t, e, name); !result.Succeeded() {
+			t.Fatalf("expected %s to succeed, got %#v", name, result)
+		}
+	}
+	result := waitForRun(t, e, names[2])
+	if result.Outcome != jobs.Failed || result.Reason != reasonExitCode {
+		t.Fatalf("expected the failing command to fail with an exit code, got %#v", result)
+	}
+
+	// Runs from before the watcher restarted are gone
+	result, finished := e.Result("missing-transcode")
+	if !finished || result.Outcome != jobs.Deleted {
+		t.Fatalf("expected an unknown run to be deleted, got %#v", result)
+	}
+
+	// A step without a command is rejected
+	_, err = e.Start(Step{Type: UploadJobType, Video: transcodes.New("foo", Namespace, transcodes.VideoTranscodeSpec{})})
+	if err == nil {
+		t.Fatal("expected a step without a command to be rejected")
+	}
+}
+
+func TestLocalExecutor_Stop(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "executor")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	script := filepath.Join(tmpDir, "transcode.sh")
+	writeTestScript(t, script, "exec sleep 30")
+
+	e := newLocalExecutor(LocalConfig{Concurrency: 1})
+
+	// The first run is killed, and the second is stopped while it waits for a slot
+	var names []string
+	for i := 0; i < 2; i++ {
+		vt := transcodes.New(fmt.Sprintf("video%d", i), Namespace, transcodes.VideoTranscodeSpec{})
+		values := transcodeJobValues{Command: []string{script}, OutputDir: filepath.Join(tmpDir, "out")}
+		name, err := e.Start(Step{Type: TranscodeJobType, Video: vt, Values: values})
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		names = append(names, name)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range names {
+		err := e.Stop(name)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	for _, name := range names {
+		if result := waitForRun(t, e, name); result.Outcome != jobs.Deleted {
+			t.Fatalf("expected %s to be stopped, got %#v", name, result)
+		}
+	}
+
+	err = e.Stop("missing-transcode")
+	if err != nil {
+		t.Fatalf("expected stopping an unknown run to be ignored, got %#v", err)
+	}
+}
+
+func TestVideoWatcher_LocalExecutor(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Stand-ins for the transcoder and the uploader
+	transcodeScript := filepath.Join(tmpDir, "transcode.sh")
+	writeTestScript(t, transcodeScript, `cp "$1" "$2"`)
+	uploader := filepath.Join(tmpDir, "upload.sh")
+	writeTestScript(t, uploader, `
+[ "$PLEX_TOKEN" = "abc123" ]
+mkdir -p "$(dirname "$2")"
+cp "$1" "$2"`)
+
+	local := LocalConfig{
+		Concurrency:   2,
+		UploadCommand: []string{uploader, "{{.TranscodedFile}}", "{{.PlexShare}}/{{.DestinationSuffix}}"},
+	}
+	presets := PresetConfig{
+		Default: "stub",
+		Profiles: map[string]transcoder.Profile{
+			"stub": {Transcoder: transcoder.Custom, Command: []string{transcodeScript, "{{.InputPath}}", "{{.OutputPath}}"}},
+		},
+	}
+	w := &VideoWatcher{
+		done:            make(chan struct{}),
+		Directories:     NewDirectories(tmpDir, tmpDir),
+		executorType:    LocalExecutor,
+		Presets:         presets,
+		PlexCfg:         plex.LibraryConfig{ServerConfig: plex.ServerConfig{Token: "abc123"}, Share: filepath.Join(tmpDir, "plex")},
+		executor:        newLocalExecutor(local),
+		transcodeClient: transcodes.NewMemoryStore(),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.runOperator()
+	}()
+	defer func() {
+		w.Close()
+		wg.Wait()
+	}()
+
+	vt := submitTestVideo(t, w, "Movies/local.mkv")
+	deadline := time.Now().Add(5 * time.Second)
+	for vt.Status.Phase != transcodes.Succeeded && vt.Status.Phase != transcodes.Failed {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the video to be processed, got %s", vt.Status.Phase)
+		}
+		time.Sleep(10 * time.Millisecond)
+		vt = getTestTranscode(t, w, vt.Name)
+	}
+
+	assertPhase(t, vt, transcodes.Succeeded)
+	assertFileExists(t, filepath.Join(tmpDir, "plex/Movies/local.mkv"))
+	if vt.Status.TranscodeJob != "movies-local-mkv-transcode" || vt.Status.UploadJob != "movies-local-mkv-upload" {
+		t.Fatalf("expected the runs to be recorded on the status, got %#v", vt.Status)
+	}
+}
@@ -0,0 +1,368 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// operatorResync is how often every VideoTranscode is reconciled again, so
+// that a status that could not be saved is retried.
+const operatorResync = 5 * time.Minute
+
+// Condition reasons set by the operator, in addition to the reasons that a
+// job failed, such as BackoffLimitExceeded.
+const (
+	reasonJobSucceeded    = "JobSucceeded"
+	reasonJobFailed       = "JobFailed"
+	reasonJobDeleted      = "JobDeleted"
+	reasonJobCreateFailed = "JobCreateFailed"
+	reasonSourceNotFound  = "SourceNotFound"
+	reasonInvalidSpec     = "InvalidSpec"
+	reasonInvalidMedia    = "InvalidMedia"
+	reasonRejected        = "Rejected"
+	reasonSkipped         = "TranscodeSkipped"
+)
+
+// runOperator reconciles the VideoTranscode resources, starting the transcode
+// step, then the upload step once the transcode succeeds, and recording their
+// progress on the VideoTranscode status. Every VideoTranscode is listed when
+// the watcher starts, so videos that progressed while the watcher was
+// stopped are picked up.
+func (w *VideoWatcher) runOperator() {
+	vtChan, vtErrs := w.transcodeClient.Follow(w.done, Namespace, operatorResync)
+
+	videoChan, runErrs := w.executor.Follow(w.done)
+	w.keyframes.init()
+	w.seedActiveTranscodes()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case err, ok := <-vtErrs:
+			if ok {
+				log.Fatal(errors.Wrapf(err, "unable to follow the %s, is the custom resource definition installed?", transcodes.Resource))
+			}
+			return
+		case err, ok := <-runErrs:
+			if ok {
+				log.Fatal(err)
+			}
+			return
+		case vt, ok := <-vtChan:
+			if !ok {
+				return
+			}
+			w.reconcileTranscode(vt)
+		case video, ok := <-videoChan:
+			if !ok {
+				return
+			}
+			w.reconcileLatest(video)
+		case result := <-w.keyframes.done:
+			w.receiveKeyframes(result)
+			w.reconcileLatest(result.name)
+		}
+	}
+}
+
+// seedActiveTranscodes counts the videos that were transcoding before the
+// watcher restarted, so that Pending videos that are followed before them
+// are not released over the queue concurrency.
+func (w *VideoWatcher) seedActiveTranscodes() {
+	vts, err := w.transcodeClient.List(Namespace)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "unable to list the %s, is the custom resource definition installed?", transcodes.Resource))
+	}
+	for _, vt := range vts {
+		if vt.Status.Phase == transcodes.Transcoding {
+			w.queue.Start(vt.Name)
+		}
+	}
+}
+
+// reconcileLatest gets the latest VideoTranscode, because the one that we
+// were sent may be stale, and reconciles it.
+func (w *VideoWatcher) reconcileLatest(name string) {
+	vt, err := w.transcodeClient.Get(name, Namespace)
+	if apierrors.IsNotFound(errors.Cause(err)) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	w.reconcileTranscode(vt)
+}
+
+// reconcileTranscode advances a VideoTranscode to its next phase, once the
+// job for its current phase has finished. Pending videos wait in the queue
+// until they are released by releaseTranscodes.
+func (w *VideoWatcher) reconcileTranscode(vt *transcodes.VideoTranscode) {
+	switch vt.Status.Phase {
+	case "", transcodes.Pending:
+		w.queue.Push(vt)
+	case transcodes.Transcoding:
+		// Count videos that were transcoding before the watcher restarted
+		w.queue.Start(vt.Name)
+		w.checkTranscode(vt)
+	case transcodes.Uploading:
+		w.checkUpload(vt)
+	}
+	if vt.Status.Phase != transcodes.Transcoding {
+		w.queue.Finish(vt.Name)
+	}
+
+	w.releaseTranscodes()
+}
+
+// releaseTranscodes starts transcoding the videos in the queue, highest
+// priority first, until the queue concurrency is reached.
+func (w *VideoWatcher) releaseTranscodes() {
+	w.mu.RLock()
+	limit := w.Queue.Concurrency
+	w.mu.RUnlock()
+
+	defer func() {
+		metrics.VideosQueued.Set(float64(w.queue.Len()))
+	}()
+
+	for w.queue.Len() > 0 {
+		if limit > 0 && len(w.queue.Active()) >= limit {
+			w.pruneActiveTranscodes()
+			if len(w.queue.Active()) >= limit {
+				return
+			}
+		}
+
+		name := w.queue.Pop()
+		vt, err := w.transcodeClient.Get(name, Namespace)
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			// The video is queued again on the next resync
+			log.Println(err)
+			continue
+		}
+		if vt.Status.Phase != "" && vt.Status.Phase != transcodes.Pending {
+			continue
+		}
+
+		w.startTranscode(vt)
+		if vt.Status.Phase == transcodes.Transcoding {
+			w.queue.Start(vt.Name)
+		}
+	}
+}
+
+// pruneActiveTranscodes stops counting the videos that are no longer
+// transcoding, for example because the VideoTranscode was deleted.
+func (w *VideoWatcher) pruneActiveTranscodes() {
+	for _, name := range w.queue.Active() {
+		vt, err := w.transcodeClient.Get(name, Namespace)
+		if apierrors.IsNotFound(errors.Cause(err)) || (err == nil && vt.Status.Phase != transcodes.Transcoding) {
+			w.queue.Finish(name)
+		}
+	}
+}
+
+func (w *VideoWatcher) startTranscode(vt *transcodes.VideoTranscode) {
+	err := vt.Validate()
+	if err != nil {
+		w.failTranscode(vt, transcodes.Transcoded, reasonInvalidSpec, err.Error())
+		return
+	}
+
+	claimPath := filepath.Join(w.ClaimDir, vt.Spec.SourcePath)
+	if _, err := os.Stat(claimPath); err != nil {
+		w.failTranscode(vt, transcodes.Transcoded, reasonSourceNotFound, fmt.Sprintf("%s is not in the claim directory", vt.Spec.SourcePath))
+		return
+	}
+
+	decision, ok := w.probeTranscode(vt)
+	if !ok {
+		return
+	}
+	switch decision.Action {
+	case probe.Reject:
+		w.failTranscode(vt, transcodes.Transcoded, reasonRejected, fmt.Sprintf("rejected by the %s rule", decision.Rule))
+		return
+	case probe.Upload:
+		setCondition(vt, transcodes.Transcoded, metav1.ConditionTrue, reasonSkipped,
+			fmt.Sprintf("uploading the original video, which matched the %s rule", decision.Rule))
+		w.startUpload(vt)
+		return
+	case probe.Transcode:
+		if w.shouldSplit(vt) && w.readKeyframes(vt) {
+			// The video is split by checkKeyframes, once its keyframes are read
+			vt.Status.Phase = transcodes.Transcoding
+			w.saveTranscodeStatus(vt)
+			return
+		}
+	}
+
+	w.startSingleTranscode(vt, decision)
+}
+
+// startSingleTranscode transcodes a video in a single job.
+func (w *VideoWatcher) startSingleTranscode(vt *transcodes.VideoTranscode, decision probe.Decision) {
+	jobName, err := w.createTranscodeJob(vt, decision)
+	if err != nil {
+		log.Println(err)
+		w.failTranscode(vt, transcodes.Transcoded, reasonJobCreateFailed, err.Error())
+		return
+	}
+
+	vt.Status.Phase = transcodes.Transcoding
+	vt.Status.TranscodeJob = jobName
+	w.saveTranscodeStatus(vt)
+}
+
+// probeTranscode reads the media info of a claimed video, recording it on
+// the status, and decides what to do with the video using the rules. It
+// returns false when the video is not a playable video, or the probe should
+// be retried on the next resync.
+func (w *VideoWatcher) probeTranscode(vt *transcodes.VideoTranscode) (probe.Decision, bool) {
+	w.mu.RLock()
+	prober := w.Prober
+	rules := w.Presets.Rules
+	w.mu.RUnlock()
+
+	if prober == nil {
+		return probe.Decision{Action: probe.Transcode}, true
+	}
+
+	info, err := prober.Probe(filepath.Join(w.ClaimDir, vt.Spec.SourcePath))
+	if probe.IsInvalidMedia(err) {
+		w.failTranscode(vt, transcodes.Transcoded, reasonInvalidMedia, err.Error())
+		return probe.Decision{}, false
+	}
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to probe %s, retrying later", vt.Spec.SourcePath))
+		return probe.Decision{}, false
+	}
+
+	decision := probe.Decide(rules, info)
+	log.Printf("%s is %s %s %dx%d, %s it\n", vt.Name, info.Container, info.VideoCodec, info.Width, info.Height, decision.Action)
+	vt.Status.Media = &info
+	vt.Status.Action = decision.Action
+	return decision, true
+}
+
+func (w *VideoWatcher) checkTranscode(vt *transcodes.VideoTranscode) {
+	if len(vt.Status.Segments) > 0 {
+		w.checkSegments(vt)
+		return
+	}
+	if vt.Status.TranscodeJob == "" {
+		w.checkKeyframes(vt)
+		return
+	}
+
+	result, finished := w.executor.Result(vt.Status.TranscodeJob)
+	if !finished {
+		return
+	}
+
+	if !result.Succeeded() {
+		w.failTranscode(vt, transcodes.Transcoded, result.Reason, result.Message)
+		return
+	}
+	setCondition(vt, transcodes.Transcoded, metav1.ConditionTrue, reasonJobSucceeded, "")
+	w.startUpload(vt)
+}
+
+// startUpload starts the upload of a transcoded video, or of the original
+// video when the transcode was skipped.
+func (w *VideoWatcher) startUpload(vt *transcodes.VideoTranscode) {
+	if info, err := os.Stat(w.uploadSourcePath(vt)); err == nil {
+		vt.Status.OutputSize = info.Size()
+	} else {
+		log.Println(errors.Wrapf(err, "unable to determine the size of the transcoded %s", vt.Spec.SourcePath))
+	}
+
+	jobName, err := w.createUploadJob(vt)
+	if err != nil {
+		log.Println(err)
+		w.failTranscode(vt, transcodes.Uploaded, reasonJobCreateFailed, err.Error())
+		return
+	}
+
+	vt.Status.Phase = transcodes.Uploading
+	vt.Status.UploadJob = jobName
+	w.saveTranscodeStatus(vt)
+}
+
+func (w *VideoWatcher) checkUpload(vt *transcodes.VideoTranscode) {
+	result, finished := w.executor.Result(vt.Status.UploadJob)
+	if !finished {
+		return
+	}
+
+	if !result.Succeeded() {
+		w.failTranscode(vt, transcodes.Uploaded, result.Reason, result.Message)
+		return
+	}
+
+	setCondition(vt, transcodes.Uploaded, metav1.ConditionTrue, reasonJobSucceeded, "")
+	vt.Status.Phase = transcodes.Succeeded
+	if w.saveTranscodeStatus(vt) {
+		metrics.PipelinesFinished.WithLabelValues(metrics.Succeeded).Inc()
+	}
+}
+
+// failTranscode records why a VideoTranscode failed, and moves the video to
+// the failed directory when it is still claimed.
+func (w *VideoWatcher) failTranscode(vt *transcodes.VideoTranscode, conditionType, reason, message string) {
+	if reason == "" {
+		reason = reasonJobFailed
+	}
+	log.Printf("%s failed: %s %s\n", vt.Name, reason, message)
+	setCondition(vt, conditionType, metav1.ConditionFalse, reason, message)
+	vt.Status.Phase = transcodes.Failed
+
+	if vt.Spec.SourcePath != "" {
+		claimPath := filepath.Join(w.ClaimDir, vt.Spec.SourcePath)
+		if _, err := os.Stat(claimPath); err == nil {
+			w.cleanupFailedClaim(vt.Spec.SourcePath)
+		}
+	}
+
+	if w.saveTranscodeStatus(vt) {
+		metrics.PipelinesFinished.WithLabelValues(metrics.Failed).Inc()
+	}
+}
+
+// saveTranscodeStatus updates the status of a VideoTranscode, and returns
+// if it was saved. A status that wasn't saved is reconciled again, so
+// anything counted when a video finishes is only counted once it is saved.
+func (w *VideoWatcher) saveTranscodeStatus(vt *transcodes.VideoTranscode) bool {
+	_, err := w.transcodeClient.UpdateStatus(vt)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func setCondition(vt *transcodes.VideoTranscode, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&vt.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: vt.Generation,
+	})
+}
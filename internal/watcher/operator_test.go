@@ -0,0 +1,521 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// submitTestVideo claims and submits a video, returning its VideoTranscode.
+func submitTestVideo(t *testing.T, w *VideoWatcher, pathSuffix string) *transcodes.VideoTranscode {
+	writeTestVideo(t, filepath.Join(w.ClaimDir, pathSuffix))
+	w.submitVideo(pathSuffix)
+	return getTestTranscode(t, w, videoName(pathSuffix))
+}
+
+func getTestTranscode(t *testing.T, w *VideoWatcher, name string) *transcodes.VideoTranscode {
+	vt, err := w.transcodeClient.Get(name, Namespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return vt
+}
+
+func setTestJobStatus(t *testing.T, client *fake.Clientset, name string, status batchv1.JobStatus) {
+	j := getTestJob(t, client, name)
+	j.Status = status
+	_, err := client.BatchV1().Jobs(Namespace).UpdateStatus(context.TODO(), j, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func assertPhase(t *testing.T, vt *transcodes.VideoTranscode, want transcodes.Phase) {
+	if vt.Status.Phase != want {
+		t.Fatalf("expected %s to be %s, got %s: %#v", vt.Name, want, vt.Status.Phase, vt.Status.Conditions)
+	}
+}
+
+func assertCondition(t *testing.T, vt *transcodes.VideoTranscode, conditionType string, status metav1.ConditionStatus, reason string) {
+	cond := meta.FindStatusCondition(vt.Status.Conditions, conditionType)
+	if cond == nil || cond.Status != status || cond.Reason != reason {
+		t.Fatalf("expected the %s condition to be %s with reason %s, got %#v", conditionType, status, reason, cond)
+	}
+}
+
+func TestVideoWatcher_ReconcileTranscode(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	vt := submitTestVideo(t, w, "TV/Show/episode.mkv")
+	if vt.Spec.Library != "TV" || vt.Spec.Preset != "tivo" || vt.Spec.Destination != "TV/Show/episode.mkv" {
+		t.Fatalf("expected the spec to be filled in from the video path, got %#v", vt.Spec)
+	}
+
+	// Pending -> Transcoding
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, "tv-show-episode-mkv")
+	assertPhase(t, vt, transcodes.Transcoding)
+	transcode := getTestJob(t, client, vt.Status.TranscodeJob)
+	if len(transcode.OwnerReferences) != 1 || transcode.OwnerReferences[0].Name != vt.Name {
+		t.Fatalf("expected the transcode job to be owned by the VideoTranscode, got %#v", transcode.OwnerReferences)
+	}
+	container := transcode.Spec.Template.Spec.Containers[0]
+	if command := strings.Join(container.Command, " "); container.Image != transcoder.HandBrakeImage || !strings.Contains(command, "--preset tivo") {
+		t.Fatalf("expected the transcode job to run HandBrakeCLI with the preset, got %s %s", container.Image, command)
+	}
+
+	// Nothing happens until the transcode job finishes
+	w.reconcileTranscode(vt)
+	assertPhase(t, getTestTranscode(t, w, "tv-show-episode-mkv"), transcodes.Transcoding)
+
+	// Transcoding -> Uploading
+	transcodedPath := w.transcodedPath(vt)
+	err := os.MkdirAll(filepath.Dir(transcodedPath), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(transcodedPath, []byte("transcoded"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	setTestJobStatus(t, client, vt.Status.TranscodeJob, succeededStatus)
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, "tv-show-episode-mkv")
+	assertPhase(t, vt, transcodes.Uploading)
+	assertCondition(t, vt, transcodes.Transcoded, metav1.ConditionTrue, reasonJobSucceeded)
+	if vt.Status.OutputSize != int64(len("transcoded")) {
+		t.Fatalf("expected the size of the transcoded video to be recorded, got %d", vt.Status.OutputSize)
+	}
+	upload := getTestJob(t, client, vt.Status.UploadJob)
+	if args := strings.Join(upload.Spec.Template.Spec.Containers[0].Args, " "); !strings.Contains(args, "--plex-library TV") {
+		t.Fatalf("expected the upload job to use the library from the spec, got %s", args)
+	}
+
+	// Uploading -> Succeeded
+	setTestJobStatus(t, client, vt.Status.UploadJob, succeededStatus)
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, "tv-show-episode-mkv")
+	assertPhase(t, vt, transcodes.Succeeded)
+	assertCondition(t, vt, transcodes.Uploaded, metav1.ConditionTrue, reasonJobSucceeded)
+}
+
+func TestVideoWatcher_ReconcileTranscode_DuplicateJob(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	vt := submitTestVideo(t, w, "Movies/duplicate.mkv")
+	w.reconcileTranscode(vt)
+
+	// The job was created, but the status was not saved
+	vt = getTestTranscode(t, w, vt.Name)
+	jobName := vt.Status.TranscodeJob
+	vt.Status = transcodes.VideoTranscodeStatus{Phase: transcodes.Pending}
+	_, err := w.transcodeClient.UpdateStatus(vt)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	client.ClearActions()
+
+	// The running job is used instead of being replaced
+	w.reconcileTranscode(getTestTranscode(t, w, vt.Name))
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+	if vt.Status.TranscodeJob != jobName {
+		t.Fatalf("expected the running job %s to be used, got %s", jobName, vt.Status.TranscodeJob)
+	}
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "delete" {
+			t.Fatalf("expected the running job to be left alone, got %#v", a)
+		}
+	}
+}
+
+func TestVideoWatcher_SubmitVideo_InProgress(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	vt := submitTestVideo(t, w, "Movies/again.mkv")
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+
+	// Dropping the video again doesn't start it over
+	submitTestVideo(t, w, "Movies/again.mkv")
+	got := getTestTranscode(t, w, vt.Name)
+	assertPhase(t, got, transcodes.Transcoding)
+	if got.UID != vt.UID || got.Status.TranscodeJob != vt.Status.TranscodeJob {
+		t.Fatalf("expected the VideoTranscode in progress to be kept, got %#v", got)
+	}
+}
+
+func TestVideoWatcher_ReconcileTranscode_Failed(t *testing.T) {
+	failedWith := func(reason string) batchv1.JobStatus {
+		status := failedStatus
+		status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: reason}}
+		return status
+	}
+
+	testcases := []struct {
+		name          string
+		setup         func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode)
+		wantCondition string
+		wantReason    string
+	}{
+		{
+			name: "source not found",
+			setup: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				err := w.Fail(vt.Spec.SourcePath)
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+			},
+			wantCondition: transcodes.Transcoded,
+			wantReason:    reasonSourceNotFound,
+		},
+		{
+			name: "transcode failed",
+			setup: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				w.reconcileTranscode(vt)
+				setTestJobStatus(t, client, "movies-failed-mkv-transcode", failedWith("BackoffLimitExceeded"))
+			},
+			wantCondition: transcodes.Transcoded,
+			wantReason:    "BackoffLimitExceeded",
+		},
+		{
+			name: "transcode deleted",
+			setup: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				w.reconcileTranscode(vt)
+				err := jobs.NewClient(client).Delete("movies-failed-mkv-transcode", Namespace)
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+			},
+			wantCondition: transcodes.Transcoded,
+			wantReason:    reasonJobDeleted,
+		},
+		{
+			name: "upload failed",
+			setup: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				w.reconcileTranscode(vt)
+				setTestJobStatus(t, client, "movies-failed-mkv-transcode", succeededStatus)
+				w.reconcileTranscode(getTestTranscode(t, w, vt.Name))
+				setTestJobStatus(t, client, "movies-failed-mkv-upload", failedWith(""))
+			},
+			wantCondition: transcodes.Uploaded,
+			wantReason:    reasonJobFailed,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w, client, cleanup := buildTestWatcher(t)
+			defer cleanup()
+
+			vt := submitTestVideo(t, w, "Movies/failed.mkv")
+			tc.setup(t, w, client, vt)
+
+			w.reconcileTranscode(getTestTranscode(t, w, vt.Name))
+			vt = getTestTranscode(t, w, vt.Name)
+			assertPhase(t, vt, transcodes.Failed)
+			assertCondition(t, vt, tc.wantCondition, metav1.ConditionFalse, tc.wantReason)
+			assertFileExists(t, filepath.Join(w.FailedDir, "Movies/failed.mkv"))
+
+			// Failed is final
+			w.reconcileTranscode(vt)
+			assertPhase(t, getTestTranscode(t, w, vt.Name), transcodes.Failed)
+		})
+	}
+}
+
+func TestVideoWatcher_RunOperator(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	// Don't update the jobs until the operator is watching for the change
+	watching := make(chan struct{})
+	var once sync.Once
+	client.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		watcher, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		once.Do(func() { close(watching) })
+		return true, watcher, nil
+	})
+
+	submitTestVideo(t, w, "Movies/movie.mkv")
+
+	operatorDone := make(chan struct{})
+	go func() {
+		defer close(operatorDone)
+		w.runOperator()
+	}()
+	defer func() {
+		w.Close()
+		<-operatorDone
+	}()
+
+	waitForPhase := func(want transcodes.Phase) *transcodes.VideoTranscode {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			vt := getTestTranscode(t, w, "movies-movie-mkv")
+			if vt.Status.Phase == want {
+				return vt
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s, got %s", want, vt.Status.Phase)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	vt := waitForPhase(transcodes.Transcoding)
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the operator to watch the jobs")
+	}
+
+	setTestJobStatus(t, client, vt.Status.TranscodeJob, succeededStatus)
+	vt = waitForPhase(transcodes.Uploading)
+
+	setTestJobStatus(t, client, vt.Status.UploadJob, succeededStatus)
+	waitForPhase(transcodes.Succeeded)
+}
+
+// testProber returns the media info for each claimed video, and any other
+// file is invalid. The videos have a keyframe every 10 seconds.
+type testProber map[string]probe.MediaInfo
+
+func (p testProber) Probe(path string) (probe.MediaInfo, error) {
+	info, ok := p[filepath.Base(path)]
+	if !ok {
+		return info, probe.InvalidMediaError{Path: path, Reason: "it has no video stream"}
+	}
+	return info, nil
+}
+
+func (p testProber) Keyframes(path string) ([]float64, error) {
+	info, err := p.Probe(path)
+	if err != nil {
+		return nil, err
+	}
+	var keyframes []float64
+	for t := 0.0; t < info.DurationSeconds; t += 10 {
+		keyframes = append(keyframes, t)
+	}
+	return keyframes, nil
+}
+
+func TestVideoWatcher_ReconcileTranscode_Probe(t *testing.T) {
+	prober := testProber{
+		"compatible.mkv": {Container: "mkv", VideoCodec: "h264", Height: 1080, DurationSeconds: 60, AudioTracks: 1},
+		"uhd.mkv":        {Container: "mkv", VideoCodec: "h265", Height: 2160, DurationSeconds: 60, AudioTracks: 1},
+		"legacy.avi":     {Container: "avi", VideoCodec: "h264", Height: 480, DurationSeconds: 60, AudioTracks: 2},
+		"sample.mkv":     {Container: "mkv", VideoCodec: "h265", Height: 1080, DurationSeconds: 5, AudioTracks: 1},
+	}
+	rules := []probe.Rule{
+		{Name: "samples", MaxHeight: 1080, VideoCodecs: []string{"h265"}, Action: probe.Reject},
+		{Name: "compatible", VideoCodecs: []string{"h264"}, Containers: []string{"mkv"}, Action: probe.Upload},
+		{Name: "legacy", Containers: []string{"avi"}, Action: probe.Remux},
+		{Name: "4k", MinHeight: 2160, Preset: "4k"},
+	}
+
+	testcases := []struct {
+		name          string
+		pathSuffix    string
+		wantPhase     transcodes.Phase
+		wantCondition metav1.ConditionStatus
+		wantReason    string
+		check         func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode)
+	}{
+		{
+			name:          "upload without transcoding",
+			pathSuffix:    "Movies/compatible.mkv",
+			wantPhase:     transcodes.Uploading,
+			wantCondition: metav1.ConditionTrue,
+			wantReason:    reasonSkipped,
+			check: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				if vt.Status.TranscodeJob != "" {
+					t.Fatalf("expected the transcode to be skipped, got %s", vt.Status.TranscodeJob)
+				}
+				upload := getTestJob(t, client, vt.Status.UploadJob)
+				args := strings.Join(upload.Spec.Template.Spec.Containers[0].Args, " ")
+				if !strings.Contains(args, "-f "+filepath.Join(w.ClaimDir, "Movies/compatible.mkv")) {
+					t.Fatalf("expected the original video to be uploaded, got %s", args)
+				}
+			},
+		},
+		{
+			name:          "preset by resolution",
+			pathSuffix:    "Movies/uhd.mkv",
+			wantPhase:     transcodes.Transcoding,
+			wantCondition: "",
+			check: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				transcode := getTestJob(t, client, vt.Status.TranscodeJob)
+				command := strings.Join(transcode.Spec.Template.Spec.Containers[0].Command, " ")
+				if !strings.Contains(command, "--preset 4k") {
+					t.Fatalf("expected the preset of the rule to be used, got %s", command)
+				}
+				if vt.Status.Media == nil || vt.Status.Media.Height != 2160 {
+					t.Fatalf("expected the media info to be recorded, got %#v", vt.Status.Media)
+				}
+			},
+		},
+		{
+			name:          "remux",
+			pathSuffix:    "Movies/legacy.avi",
+			wantPhase:     transcodes.Transcoding,
+			wantCondition: "",
+			check: func(t *testing.T, w *VideoWatcher, client *fake.Clientset, vt *transcodes.VideoTranscode) {
+				transcode := getTestJob(t, client, vt.Status.TranscodeJob)
+				command := strings.Join(transcode.Spec.Template.Spec.Containers[0].Command, " ")
+				if !strings.Contains(command, "-map 0:a:1? -c:v copy -c:a copy -f matroska") {
+					t.Fatalf("expected every stream to be copied, got %s", command)
+				}
+			},
+		},
+		{
+			name:          "rejected",
+			pathSuffix:    "Movies/sample.mkv",
+			wantPhase:     transcodes.Failed,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    reasonRejected,
+		},
+		{
+			name:          "corrupt",
+			pathSuffix:    "Movies/corrupt.mkv",
+			wantPhase:     transcodes.Failed,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    reasonInvalidMedia,
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w, client, cleanup := buildTestWatcher(t)
+			defer cleanup()
+			w.Prober = prober
+			w.Presets.Rules = rules
+
+			vt := submitTestVideo(t, w, tc.pathSuffix)
+			w.reconcileTranscode(vt)
+			vt = getTestTranscode(t, w, vt.Name)
+
+			assertPhase(t, vt, tc.wantPhase)
+			if tc.wantCondition != "" {
+				assertCondition(t, vt, transcodes.Transcoded, tc.wantCondition, tc.wantReason)
+			}
+			if tc.wantPhase == transcodes.Failed {
+				assertFileExists(t, filepath.Join(w.FailedDir, tc.pathSuffix))
+			}
+			if tc.check != nil {
+				tc.check(t, w, client, vt)
+			}
+		})
+	}
+}
+
+func TestVideoWatcher_ReconcileTranscode_Queue(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	w.Queue = QueueConfig{
+		Concurrency: 1,
+		Libraries:   map[string]int32{"TV": 100},
+		Classes:     []PriorityClass{{Name: "handbrk8s-high", MinPriority: 100}},
+	}
+	assertQueued := func(name string) {
+		if vt := getTestTranscode(t, w, name); vt.Status.Phase != "" && vt.Status.Phase != transcodes.Pending {
+			t.Fatalf("expected %s to wait in the queue, got %s", name, vt.Status.Phase)
+		}
+	}
+
+	// A backlog of movies, only one is transcoded at a time
+	var backlog []*transcodes.VideoTranscode
+	for i := 1; i <= 3; i++ {
+		vt := submitTestVideo(t, w, fmt.Sprintf("Movies/movie%d.mkv", i))
+		w.reconcileTranscode(vt)
+		backlog = append(backlog, vt)
+	}
+	assertPhase(t, getTestTranscode(t, w, backlog[0].Name), transcodes.Transcoding)
+	assertQueued(backlog[1].Name)
+	assertQueued(backlog[2].Name)
+
+	// A new episode waits for the running transcode, but not the backlog
+	episode := submitTestVideo(t, w, "TV/Show/new-episode.mkv")
+	if episode.Spec.Priority != 100 || episode.Spec.PriorityClassName != "handbrk8s-high" {
+		t.Fatalf("expected the episode to have the priority of the TV library, got %#v", episode.Spec)
+	}
+	w.reconcileTranscode(episode)
+	assertQueued(episode.Name)
+
+	running := getTestTranscode(t, w, backlog[0].Name)
+	writeTestVideo(t, w.transcodedPath(running))
+	setTestJobStatus(t, client, running.Status.TranscodeJob, succeededStatus)
+	w.reconcileTranscode(running)
+	assertPhase(t, getTestTranscode(t, w, backlog[0].Name), transcodes.Uploading)
+
+	episode = getTestTranscode(t, w, episode.Name)
+	assertPhase(t, episode, transcodes.Transcoding)
+	assertQueued(backlog[1].Name)
+	transcode := getTestJob(t, client, episode.Status.TranscodeJob)
+	if transcode.Spec.Template.Spec.PriorityClassName != "handbrk8s-high" {
+		t.Fatalf("expected the transcode job to have the priority class of the video, got %q", transcode.Spec.Template.Spec.PriorityClassName)
+	}
+	upload := getTestJob(t, client, getTestTranscode(t, w, backlog[0].Name).Status.UploadJob)
+	if upload.Spec.Template.Spec.PriorityClassName != "" {
+		t.Fatalf("expected the upload job of a movie to not have a priority class, got %q", upload.Spec.Template.Spec.PriorityClassName)
+	}
+
+	// The queue is released when a transcoding video is deleted
+	err := w.transcodeClient.Delete(episode.Name, Namespace)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	w.reconcileTranscode(getTestTranscode(t, w, backlog[2].Name))
+	assertPhase(t, getTestTranscode(t, w, backlog[1].Name), transcodes.Transcoding)
+	assertQueued(backlog[2].Name)
+}
+
+func TestVideoWatcher_SeedActiveTranscodes(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	w.Queue = QueueConfig{Concurrency: 1}
+
+	// A video was transcoding before the watcher restarted
+	running := submitTestVideo(t, w, "Movies/running.mkv")
+	running.Status.Phase = transcodes.Transcoding
+	_, err := w.transcodeClient.UpdateStatus(running)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	pending := submitTestVideo(t, w, "Movies/pending.mkv")
+
+	// The pending video is followed first, and waits for the running one
+	w.seedActiveTranscodes()
+	w.reconcileTranscode(pending)
+	if vt := getTestTranscode(t, w, pending.Name); vt.Status.Phase != "" && vt.Status.Phase != transcodes.Pending {
+		t.Fatalf("expected %s to wait in the queue, got %s", vt.Name, vt.Status.Phase)
+	}
+}
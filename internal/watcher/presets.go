@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// PresetConfig selects the preset used to transcode a video. A preset is
+// either the name of an encode profile, or of a HandBrake preset.
+type PresetConfig struct {
+	// Default preset used when a video doesn't match a pattern or library.
+	Default string `json:"default"`
+
+	// Patterns are checked in order against the path of the video, relative
+	// to the watch directory, and take precedence over Libraries.
+	Patterns []PresetPattern `json:"patterns,omitempty"`
+
+	// Libraries maps the name of a library, the first segment of the path,
+	// to a preset.
+	Libraries map[string]string `json:"libraries,omitempty"`
+
+	// Profiles are encode profiles, which select the transcoder, e.g. ffmpeg,
+	// and its settings. They are used like a preset, by name.
+	Profiles map[string]transcoder.Profile `json:"profiles,omitempty"`
+
+	// Rules are checked in order against the media info of a video, when
+	// probing is enabled, and the first rule that matches decides whether
+	// the video is transcoded, remuxed, uploaded as is, or rejected. The
+	// preset of a rule takes precedence over the patterns and libraries.
+	Rules []probe.Rule `json:"rules,omitempty"`
+}
+
+// PresetPattern selects a preset for videos with a path that matches a glob
+// pattern, using the syntax of filepath.Match. For example, "TV/*/*.mkv".
+type PresetPattern struct {
+	Pattern string `json:"pattern"`
+	Preset  string `json:"preset"`
+}
+
+// LoadPresetConfig reads the preset configuration from a yaml or json file.
+func LoadPresetConfig(path string) (PresetConfig, error) {
+	var c PresetConfig
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, errors.Wrapf(err, "could not read %s", path)
+	}
+
+	err = yaml.UnmarshalStrict(contents, &c)
+	if err != nil {
+		return c, errors.Wrapf(err, "unable to parse the preset configuration in %s", path)
+	}
+
+	return c, nil
+}
+
+// Preset selects the preset for a video, using the path of the video relative
+// to the watch directory.
+func (c PresetConfig) Preset(pathSuffix string) string {
+	for _, p := range c.Patterns {
+		if ok, _ := filepath.Match(p.Pattern, pathSuffix); ok {
+			return p.Preset
+		}
+	}
+
+	if preset, ok := c.Libraries[libraryName(pathSuffix)]; ok {
+		return preset
+	}
+
+	return c.Default
+}
+
+// Profile is the encode profile for a preset. A preset that isn't one of the
+// profiles is a HandBrake preset.
+func (c PresetConfig) Profile(preset string) transcoder.Profile {
+	if profile, ok := c.Profiles[preset]; ok {
+		return profile
+	}
+	return transcoder.Profile{Transcoder: transcoder.HandBrake, Preset: preset}
+}
+
+// Validate checks that the patterns and profiles are valid, and that every
+// preset is either a profile, or one of the available HandBrake presets.
+func (c PresetConfig) Validate(availablePresets []string) error {
+	available := make(map[string]bool, len(availablePresets))
+	for _, name := range availablePresets {
+		available[name] = true
+	}
+	checkHandBrakePreset := func(preset, usedBy string) error {
+		if !available[preset] {
+			return errors.Errorf("the %s preset, used by %s, is not defined in the HandBrake presets %v", preset, usedBy, availablePresets)
+		}
+		return nil
+	}
+	checkPreset := func(preset, usedBy string) error {
+		if _, ok := c.Profiles[preset]; ok {
+			return nil
+		}
+		return checkHandBrakePreset(preset, usedBy)
+	}
+
+	for name, profile := range c.Profiles {
+		if err := profile.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid profile %s", name)
+		}
+		if profile.Preset != "" {
+			if err := checkHandBrakePreset(profile.Preset, "profile "+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.Default == "" {
+		return errors.New("a default preset is required")
+	}
+	if err := checkPreset(c.Default, "default"); err != nil {
+		return err
+	}
+
+	for _, p := range c.Patterns {
+		if _, err := filepath.Match(p.Pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", p.Pattern)
+		}
+		if err := checkPreset(p.Preset, "pattern "+p.Pattern); err != nil {
+			return err
+		}
+	}
+
+	for library, preset := range c.Libraries {
+		if err := checkPreset(preset, "library "+library); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if r.Preset != "" {
+			if err := checkPreset(r.Preset, "rule "+r.String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateImages checks that every custom profile has an image, which is
+// required to run its command in a transcode job on the cluster. The other
+// transcoders fall back to their default image.
+func (c PresetConfig) validateImages() error {
+	for name, profile := range c.Profiles {
+		if profile.Transcoder == transcoder.Custom && profile.Image == "" {
+			return errors.Errorf("invalid profile %s, the custom transcoder requires an image with the %s executor", name, KubernetesExecutor)
+		}
+	}
+	return nil
+}
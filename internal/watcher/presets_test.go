@@ -0,0 +1,163 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+)
+
+func TestPresetConfig_Preset(t *testing.T) {
+	c := PresetConfig{
+		Default: "tivo",
+		Patterns: []PresetPattern{
+			{Pattern: "TV/Cartoons/*", Preset: "cartoons"},
+			{Pattern: "*/*.avi", Preset: "legacy"},
+		},
+		Libraries: map[string]string{
+			"TV":     "tv",
+			"Movies": "movies",
+		},
+	}
+
+	testcases := []struct {
+		pathSuffix string
+		want       string
+	}{
+		{"Movies/Star Wars.mkv", "movies"},
+		{"TV/Seinfeld S01E01.mkv", "tv"},
+		{"TV/Cartoons/Bugs Bunny.mkv", "cartoons"},
+		{"Movies/Metropolis.avi", "legacy"},
+		{"Home Videos/Birthday.mkv", "tivo"},
+		{"Unsorted.mkv", "tivo"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.pathSuffix, func(t *testing.T) {
+			got := c.Preset(tc.pathSuffix)
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPresetConfig_Validate(t *testing.T) {
+	available := []string{"tivo", "movies"}
+
+	testcases := []struct {
+		name    string
+		config  PresetConfig
+		wantErr bool
+	}{
+		{"valid", PresetConfig{Default: "tivo", Libraries: map[string]string{"Movies": "movies"}}, false},
+		{"missing default", PresetConfig{Libraries: map[string]string{"Movies": "movies"}}, true},
+		{"unknown default", PresetConfig{Default: "vhs"}, true},
+		{"unknown library preset", PresetConfig{Default: "tivo", Libraries: map[string]string{"TV": "tv"}}, true},
+		{"unknown pattern preset", PresetConfig{Default: "tivo", Patterns: []PresetPattern{{Pattern: "TV/*", Preset: "tv"}}}, true},
+		{"invalid pattern", PresetConfig{Default: "tivo", Patterns: []PresetPattern{{Pattern: "TV/[", Preset: "tivo"}}}, true},
+		{"profile", PresetConfig{Default: "tivo", Libraries: map[string]string{"TV": "tv"},
+			Profiles: map[string]transcoder.Profile{"tv": {Transcoder: transcoder.FFmpeg}}}, false},
+		{"invalid profile", PresetConfig{Default: "tivo",
+			Profiles: map[string]transcoder.Profile{"tv": {Transcoder: transcoder.FFmpeg, VideoCodec: "vp9"}}}, true},
+		{"unknown profile preset", PresetConfig{Default: "tivo",
+			Profiles: map[string]transcoder.Profile{"tv": {Preset: "vhs"}}}, true},
+		{"rule", PresetConfig{Default: "tivo", Rules: []probe.Rule{{MinHeight: 2160, Preset: "movies"}}}, false},
+		{"unknown rule preset", PresetConfig{Default: "tivo", Rules: []probe.Rule{{MinHeight: 2160, Preset: "4k"}}}, true},
+		{"invalid rule", PresetConfig{Default: "tivo", Rules: []probe.Rule{{Action: probe.Upload, Preset: "tivo"}}}, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate(available)
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
+
+func TestPresetConfig_ValidateImages(t *testing.T) {
+	custom := transcoder.Profile{Transcoder: transcoder.Custom, Command: []string{"encode", "{{.InputPath}}"}}
+	withImage := custom
+	withImage.Image = "example/encoder"
+
+	testcases := []struct {
+		name    string
+		profile transcoder.Profile
+		wantErr bool
+	}{
+		{"handbrake", transcoder.Profile{Transcoder: transcoder.HandBrake}, false},
+		{"custom with image", withImage, false},
+		{"custom without image", custom, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			c := PresetConfig{Default: "tv", Profiles: map[string]transcoder.Profile{"tv": tc.profile}}
+			err := c.validateImages()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
+
+func TestPresetConfig_Profile(t *testing.T) {
+	c := PresetConfig{
+		Default:  "tivo",
+		Profiles: map[string]transcoder.Profile{"tv": {Transcoder: transcoder.FFmpeg}},
+	}
+
+	if p := c.Profile("tv"); p.Transcoder != transcoder.FFmpeg {
+		t.Fatalf("expected the tv profile to use ffmpeg, got %#v", p)
+	}
+	if p := c.Profile("tivo"); p.Transcoder != transcoder.HandBrake || p.Preset != "tivo" {
+		t.Fatalf("expected a HandBrake preset to use HandBrake, got %#v", p)
+	}
+}
+
+func TestLoadPresetConfig(t *testing.T) {
+	c, err := LoadPresetConfig("../../cmd/watcher/presets.yaml")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if c.Default != "tivo" {
+		t.Fatalf("expected the default preset to be tivo, got %s", c.Default)
+	}
+	if c.Libraries["Movies"] != "tivo" {
+		t.Fatalf("expected the Movies library to be mapped, got %#v", c.Libraries)
+	}
+}
+
+func TestLoadPresetConfig_UnknownField(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "presets")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "presets.yaml")
+	err = ioutil.WriteFile(path, []byte("default: tivo\nlibrary:\n  Movies: tivo\n"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	_, err = LoadPresetConfig(path)
+	if err == nil {
+		t.Fatal("expected a misspelled field to be rejected")
+	}
+}
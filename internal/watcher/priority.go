@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// PriorityFile is a marker in the watch directory that sets the priority of
+// the videos in its directory, and the directories below it. It contains
+// the priority, e.g. 100.
+const PriorityFile = ".priority"
+
+// QueueConfig orders the videos waiting to be transcoded, and limits how
+// many are transcoded at the same time.
+type QueueConfig struct {
+	// Concurrency is how many videos are transcoded at the same time, and
+	// the rest wait in the queue, highest priority first. The segments of a
+	// split video count as one video. 0 transcodes every video right away.
+	Concurrency int `json:"concurrency"`
+
+	// DefaultPriority is used for videos that don't match a library,
+	// pattern or size.
+	DefaultPriority int32 `json:"defaultPriority"`
+
+	// Libraries maps the name of a library, the first segment of the path,
+	// to a priority.
+	Libraries map[string]int32 `json:"libraries,omitempty"`
+
+	// Patterns match the path of the video, relative to the watch directory.
+	Patterns []PriorityPattern `json:"patterns,omitempty"`
+
+	// Sizes match videos that are smaller than a size.
+	Sizes []PrioritySize `json:"sizes,omitempty"`
+
+	// Classes select the priorityClassName of the jobs of a video.
+	Classes []PriorityClass `json:"classes,omitempty"`
+}
+
+// PriorityPattern sets the priority of videos with a path that matches a
+// glob pattern, using the syntax of filepath.Match. For example, "TV/*/*".
+type PriorityPattern struct {
+	Pattern  string `json:"pattern"`
+	Priority int32  `json:"priority"`
+}
+
+// PrioritySize sets the priority of videos smaller than a size, e.g. 2Gi.
+type PrioritySize struct {
+	SmallerThan resource.Quantity `json:"smallerThan"`
+	Priority    int32             `json:"priority"`
+}
+
+// PriorityClass is set as the priorityClassName of the jobs of videos with
+// at least the minimum priority. The PriorityClass must exist in the cluster.
+type PriorityClass struct {
+	Name        string `json:"name"`
+	MinPriority int32  `json:"minPriority"`
+}
+
+// Priority of a video, which is the highest priority of the library,
+// patterns and sizes that match it, or the default priority when none
+// match. The priority in a marker file takes precedence, and is nil when
+// there is no marker.
+func (c QueueConfig) Priority(pathSuffix string, size int64, marker *int32) int32 {
+	if marker != nil {
+		return *marker
+	}
+
+	var priorities []int32
+	if p, ok := c.Libraries[libraryName(pathSuffix)]; ok {
+		priorities = append(priorities, p)
+	}
+	for _, p := range c.Patterns {
+		if ok, _ := filepath.Match(p.Pattern, pathSuffix); ok {
+			priorities = append(priorities, p.Priority)
+		}
+	}
+	for _, s := range c.Sizes {
+		if size < s.SmallerThan.Value() {
+			priorities = append(priorities, s.Priority)
+		}
+	}
+
+	if len(priorities) == 0 {
+		return c.DefaultPriority
+	}
+	highest := priorities[0]
+	for _, p := range priorities[1:] {
+		if p > highest {
+			highest = p
+		}
+	}
+	return highest
+}
+
+// PriorityClassName is the class with the highest minimum priority that the
+// priority reaches, or empty when it doesn't reach any class.
+func (c QueueConfig) PriorityClassName(priority int32) string {
+	var class *PriorityClass
+	for i, pc := range c.Classes {
+		if priority >= pc.MinPriority && (class == nil || pc.MinPriority > class.MinPriority) {
+			class = &c.Classes[i]
+		}
+	}
+	if class == nil {
+		return ""
+	}
+	return class.Name
+}
+
+// Validate checks the concurrency, patterns and classes.
+func (c QueueConfig) Validate() error {
+	if c.Concurrency < 0 {
+		return errors.Errorf("queue.concurrency can't be negative, got %d", c.Concurrency)
+	}
+	for _, p := range c.Patterns {
+		if _, err := filepath.Match(p.Pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid queue pattern %q", p.Pattern)
+		}
+	}
+	for _, s := range c.Sizes {
+		if s.SmallerThan.Sign() <= 0 {
+			return errors.Errorf("queue size %s must be positive", s.SmallerThan.String())
+		}
+	}
+	for _, pc := range c.Classes {
+		if pc.Name == "" {
+			return errors.Errorf("the queue class with minPriority %d requires a name", pc.MinPriority)
+		}
+	}
+	return nil
+}
+
+// readPriorityMarker finds the nearest PriorityFile, from the directory of
+// the video up to the watch directory, and returns nil when there isn't one.
+func readPriorityMarker(watchDir, pathSuffix string) (*int32, error) {
+	dir := filepath.Dir(pathSuffix)
+	for {
+		markerPath := filepath.Join(watchDir, dir, PriorityFile)
+		contents, err := ioutil.ReadFile(markerPath)
+		if err == nil {
+			value := strings.TrimSpace(string(contents))
+			priority, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("invalid priority %q in %s", value, markerPath)
+			}
+			p := int32(priority)
+			return &p, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "could not read %s", markerPath)
+		}
+
+		if dir == "." {
+			return nil, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,143 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQueueConfig_Priority(t *testing.T) {
+	cfg := QueueConfig{
+		DefaultPriority: 10,
+		Libraries:       map[string]int32{"TV": 100, "Movies": 0},
+		Patterns:        []PriorityPattern{{Pattern: "TV/Kids/*", Priority: 200}},
+		Sizes:           []PrioritySize{{SmallerThan: resource.MustParse("1Gi"), Priority: 50}},
+	}
+	marker := int32(-5)
+
+	testcases := []struct {
+		name       string
+		pathSuffix string
+		size       int64
+		marker     *int32
+		want       int32
+	}{
+		{"default", "Home Videos/birthday.mp4", 2 << 30, nil, 10},
+		{"library", "TV/Show/episode.mkv", 2 << 30, nil, 100},
+		{"pattern", "TV/Kids/episode.mkv", 2 << 30, nil, 200},
+		{"size", "Movies/short.mkv", 100 << 20, nil, 50},
+		{"highest match", "TV/Show/episode.mkv", 100 << 20, nil, 100},
+		{"marker", "TV/Kids/episode.mkv", 100 << 20, &marker, -5},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := cfg.Priority(tc.pathSuffix, tc.size, tc.marker)
+			if got != tc.want {
+				t.Fatalf("expected priority %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestQueueConfig_PriorityClassName(t *testing.T) {
+	cfg := QueueConfig{Classes: []PriorityClass{
+		{Name: "handbrk8s-high", MinPriority: 100},
+		{Name: "handbrk8s-normal", MinPriority: 0},
+	}}
+
+	testcases := map[int32]string{-1: "", 0: "handbrk8s-normal", 99: "handbrk8s-normal", 100: "handbrk8s-high", 500: "handbrk8s-high"}
+	for priority, want := range testcases {
+		if got := cfg.PriorityClassName(priority); got != want {
+			t.Fatalf("expected priority %d to use class %q, got %q", priority, want, got)
+		}
+	}
+}
+
+func TestQueueConfig_Validate(t *testing.T) {
+	testcases := []struct {
+		name    string
+		config  QueueConfig
+		wantErr bool
+	}{
+		{"valid", QueueConfig{Concurrency: 2, Patterns: []PriorityPattern{{Pattern: "TV/*", Priority: 1}}}, false},
+		{"negative concurrency", QueueConfig{Concurrency: -1}, true},
+		{"invalid pattern", QueueConfig{Patterns: []PriorityPattern{{Pattern: "TV/[", Priority: 1}}}, true},
+		{"zero size", QueueConfig{Sizes: []PrioritySize{{Priority: 1}}}, true},
+		{"class without a name", QueueConfig{Classes: []PriorityClass{{MinPriority: 1}}}, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected validation to fail")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%#v", err)
+			}
+		})
+	}
+}
+
+func TestReadPriorityMarker(t *testing.T) {
+	watchDir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(watchDir)
+
+	writeMarker := func(dir, contents string) {
+		err := os.MkdirAll(filepath.Join(watchDir, dir), 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(watchDir, dir, PriorityFile), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	writeMarker("TV", "100\n")
+	writeMarker("TV/New Show", "500")
+	writeMarker("Movies/Broken", "high")
+
+	testcases := []struct {
+		name       string
+		pathSuffix string
+		want       *int32
+		wantErr    bool
+	}{
+		{"nearest marker", "TV/New Show/episode.mkv", int32Ptr(500), false},
+		{"parent marker", "TV/Old Show/Season 1/episode.mkv", int32Ptr(100), false},
+		{"no marker", "Movies/movie.mkv", nil, false},
+		{"invalid marker", "Movies/Broken/movie.mkv", nil, true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readPriorityMarker(watchDir, tc.pathSuffix)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an invalid marker to be an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
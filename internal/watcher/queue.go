@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+)
+
+// transcodeQueue orders the videos waiting to be transcoded, highest
+// priority first, then oldest first, and tracks the videos that are
+// transcoding. It is only used by the operator, and is not safe for
+// concurrent use.
+type transcodeQueue struct {
+	waiting queuedVideos
+
+	// queued are the names of the waiting videos.
+	queued map[string]bool
+
+	// active are the names of the videos that are transcoding.
+	active map[string]bool
+
+	// seq keeps videos queued at the same time in order.
+	seq uint64
+}
+
+type queuedVideo struct {
+	name     string
+	priority int32
+	created  time.Time
+	seq      uint64
+}
+
+// Len is the number of videos waiting.
+func (q *transcodeQueue) Len() int {
+	return len(q.waiting)
+}
+
+// Push adds a video to the queue, unless it is already waiting.
+func (q *transcodeQueue) Push(vt *transcodes.VideoTranscode) {
+	if q.queued == nil {
+		q.queued = make(map[string]bool)
+	}
+	if q.queued[vt.Name] {
+		return
+	}
+	q.queued[vt.Name] = true
+	q.seq++
+	heap.Push(&q.waiting, queuedVideo{
+		name:     vt.Name,
+		priority: vt.Spec.Priority,
+		created:  vt.CreationTimestamp.Time,
+		seq:      q.seq,
+	})
+}
+
+// Pop removes the next video to transcode from the queue.
+func (q *transcodeQueue) Pop() string {
+	v := heap.Pop(&q.waiting).(queuedVideo)
+	delete(q.queued, v.name)
+	return v.name
+}
+
+// Start records that a video is transcoding.
+func (q *transcodeQueue) Start(name string) {
+	if q.active == nil {
+		q.active = make(map[string]bool)
+	}
+	q.active[name] = true
+}
+
+// Finish records that a video is no longer transcoding.
+func (q *transcodeQueue) Finish(name string) {
+	delete(q.active, name)
+}
+
+// Active are the names of the videos that are transcoding.
+func (q *transcodeQueue) Active() []string {
+	names := make([]string, 0, len(q.active))
+	for name := range q.active {
+		names = append(names, name)
+	}
+	return names
+}
+
+// queuedVideos implements heap.Interface.
+type queuedVideos []queuedVideo
+
+func (v queuedVideos) Len() int { return len(v) }
+
+func (v queuedVideos) Less(i, j int) bool {
+	if v[i].priority != v[j].priority {
+		return v[i].priority > v[j].priority
+	}
+	if !v[i].created.Equal(v[j].created) {
+		return v[i].created.Before(v[j].created)
+	}
+	return v[i].seq < v[j].seq
+}
+
+func (v queuedVideos) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+
+func (v *queuedVideos) Push(x interface{}) {
+	*v = append(*v, x.(queuedVideo))
+}
+
+func (v *queuedVideos) Pop() interface{} {
+	old := *v
+	n := len(old)
+	item := old[n-1]
+	*v = old[:n-1]
+	return item
+}
@@ -0,0 +1,42 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTranscodeQueue(t *testing.T) {
+	now := time.Now()
+	video := func(name string, priority int32, created time.Duration) *transcodes.VideoTranscode {
+		vt := transcodes.New(name, Namespace, transcodes.VideoTranscodeSpec{Priority: priority})
+		vt.CreationTimestamp = metav1.NewTime(now.Add(created))
+		return vt
+	}
+
+	var q transcodeQueue
+	q.Push(video("backlog-2", 0, 2*time.Minute))
+	q.Push(video("backlog-1", 0, time.Minute))
+	q.Push(video("new-episode", 100, time.Hour))
+	q.Push(video("same-time-1", 0, 3*time.Minute))
+	q.Push(video("same-time-2", 0, 3*time.Minute))
+	q.Push(video("backlog-1", 0, time.Minute))
+
+	want := []string{"new-episode", "backlog-1", "backlog-2", "same-time-1", "same-time-2"}
+	if q.Len() != len(want) {
+		t.Fatalf("expected a video to only be queued once, got %d videos", q.Len())
+	}
+	for _, name := range want {
+		if got := q.Pop(); got != name {
+			t.Fatalf("expected %s to be next, got %s", name, got)
+		}
+	}
+
+	// A video can be queued again after it is popped
+	q.Push(video("backlog-1", 0, time.Minute))
+	if q.Len() != 1 {
+		t.Fatalf("expected the video to be queued again, got %d videos", q.Len())
+	}
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Job types, set on the JobTypeLabel of each job.
+const (
+	TranscodeJobType = "transcode"
+	UploadJobType    = "upload"
+
+	// SegmentJobType transcodes a segment of a split video. The segments
+	// are joined by a transcode job.
+	SegmentJobType = "segment"
+)
+
+// reconcileClaims resumes processing videos that were claimed before the
+// watcher restarted. Videos that were claimed, but never submitted as a
+// VideoTranscode, are requeued. The operator picks up the rest.
+func (w *VideoWatcher) reconcileClaims() {
+	var claimed []string
+	err := filepath.Walk(w.ClaimDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || isHidden(path) {
+			return nil
+		}
+
+		pathSuffix, err := filepath.Rel(w.ClaimDir, path)
+		if err != nil {
+			return errors.Wrapf(err, "unable to determine path suffix of %s", path)
+		}
+		claimed = append(claimed, pathSuffix)
+		return nil
+	})
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to reconcile the claim directory %s", w.ClaimDir))
+		return
+	}
+
+	for _, pathSuffix := range claimed {
+		err := w.reconcileClaim(pathSuffix)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "unable to reconcile %s", pathSuffix))
+		}
+	}
+}
+
+// reconcileClaim requeues a claimed video that doesn't have a VideoTranscode.
+func (w *VideoWatcher) reconcileClaim(pathSuffix string) error {
+	for _, name := range videoNames(pathSuffix) {
+		vt, err := w.transcodeClient.Get(name, Namespace)
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if vt.Spec.SourcePath == pathSuffix {
+			return nil
+		}
+	}
+
+	// We stopped before submitting the video, let the watcher start over
+	log.Printf("requeuing %s, it was claimed but has no %s\n", pathSuffix, transcodes.Resource)
+	return w.Requeue(pathSuffix)
+}
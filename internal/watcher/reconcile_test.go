@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// buildTestWatcher creates a watcher, without starting it, that creates jobs
+// from the real job templates using a fake clientset.
+func buildTestWatcher(t *testing.T) (w *VideoWatcher, client *fake.Clientset, cleanup func()) {
+	tmpDir, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	client = fake.NewSimpleClientset()
+	w = &VideoWatcher{
+		done:            make(chan struct{}),
+		Directories:     NewDirectories(tmpDir, tmpDir),
+		TemplatesDir:    "../../manifests/job-templates",
+		Presets:         PresetConfig{Default: "tivo"},
+		transcodeClient: transcodes.NewClient(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())),
+	}
+	w.executor = newKubernetesExecutor(w, client)
+
+	return w, client, func() { os.RemoveAll(tmpDir) }
+}
+
+func writeTestVideo(t *testing.T, path string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(path, []byte("video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func getTestJob(t *testing.T, client *fake.Clientset, name string) *batchv1.Job {
+	j, err := client.BatchV1().Jobs(Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected %s to exist: %v", name, err)
+	}
+	return j
+}
+
+func assertFileExists(t *testing.T, path string) {
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected %s to exist: %v", path, err)
+	}
+}
+
+var (
+	succeededStatus = batchv1.JobStatus{
+		Succeeded:  1,
+		Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+	}
+	failedStatus = batchv1.JobStatus{
+		Failed:     20,
+		Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+	}
+)
+
+func TestVideoWatcher_ReconcileClaims(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	// Claimed, but the watcher stopped before submitting the video
+	writeTestVideo(t, filepath.Join(w.ClaimDir, "Movies/notsubmitted.mkv"))
+
+	// Submitted, the operator picks it up from here
+	writeTestVideo(t, filepath.Join(w.ClaimDir, "TV/Show/submitted.mkv"))
+	w.submitVideo("TV/Show/submitted.mkv")
+
+	// Hidden files are ignored
+	writeTestVideo(t, filepath.Join(w.ClaimDir, "Movies/.DS_Store"))
+
+	w.reconcileClaims()
+
+	assertFileExists(t, filepath.Join(w.WatchDir, "Movies/notsubmitted.mkv"))
+	assertFileExists(t, filepath.Join(w.ClaimDir, "TV/Show/submitted.mkv"))
+	assertFileExists(t, filepath.Join(w.ClaimDir, "Movies/.DS_Store"))
+}
+
+func TestVideoWatcher_SubmitVideo_NameCollision(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+
+	// Both paths sanitize to tv-show-a-episode-mkv
+	first := submitTestVideo(t, w, "TV/Show A/episode.mkv")
+	writeTestVideo(t, filepath.Join(w.ClaimDir, "TV/Show-A/episode.mkv"))
+	w.submitVideo("TV/Show-A/episode.mkv")
+
+	second := getTestTranscode(t, w, jobs.HashedName("TV/Show-A/episode.mkv", maxVideoNameLength))
+	if first.Name != "tv-show-a-episode-mkv" || second.Spec.SourcePath != "TV/Show-A/episode.mkv" {
+		t.Fatalf("expected the second video to use the hashed name, got %s and %s", first.Name, second.Name)
+	}
+	if second.Annotations[VideoPathAnnotation] != "TV/Show-A/episode.mkv" {
+		t.Fatalf("expected the video path to be annotated, got %#v", second.Annotations)
+	}
+
+	// Neither video is requeued
+	w.reconcileClaims()
+	assertFileExists(t, filepath.Join(w.ClaimDir, "TV/Show A/episode.mkv"))
+	assertFileExists(t, filepath.Join(w.ClaimDir, "TV/Show-A/episode.mkv"))
+}
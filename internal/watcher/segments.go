@@ -0,0 +1,324 @@
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// concatListFile lists the transcoded segments of a video, in the
+// segments directory, for the concat job.
+const concatListFile = "parts.txt"
+
+// ConcatJobName is the name of the transcode job that joins the segments of
+// a split video.
+func ConcatJobName(video string) string {
+	return video + "-concat"
+}
+
+// keyframeResult is the keyframes of a video, read in the background.
+type keyframeResult struct {
+	name      string
+	keyframes []float64
+	err       error
+}
+
+// keyframeReads tracks the videos whose keyframes are read in the
+// background, so that reading a long video doesn't block the operator. It
+// is only used by the operator, and is not safe for concurrent use.
+type keyframeReads struct {
+	// results are the keyframes of each video by name, and nil while they
+	// are being read.
+	results map[string]*keyframeResult
+
+	// done receives the keyframes of a video once they are read.
+	done chan keyframeResult
+}
+
+func (r *keyframeReads) init() {
+	if r.results == nil {
+		r.results = make(map[string]*keyframeResult)
+		r.done = make(chan keyframeResult)
+	}
+}
+
+// shouldSplit checks if a video is split into segments, when splitting is
+// enabled and the video is long enough. The video must have been probed.
+func (w *VideoWatcher) shouldSplit(vt *transcodes.VideoTranscode) bool {
+	w.mu.RLock()
+	cfg := w.Segments
+	prober := w.Prober
+	w.mu.RUnlock()
+
+	media := vt.Status.Media
+	if !cfg.Enabled() || prober == nil || media == nil {
+		return false
+	}
+	return media.DurationSeconds >= 2*cfg.MinDuration.Seconds()
+}
+
+// planSegments splits a video into segments at its keyframes.
+func (w *VideoWatcher) planSegments(vt *transcodes.VideoTranscode, keyframes []float64) []transcoder.Segment {
+	w.mu.RLock()
+	cfg := w.Segments
+	w.mu.RUnlock()
+
+	return transcoder.PlanSegments(vt.Status.Media.DurationSeconds, keyframes, cfg.Count, cfg.MinDuration.Seconds())
+}
+
+// readKeyframes starts reading the keyframes of a video in the background,
+// which reads the whole video. The operator receives the result from
+// w.keyframes.done, and reconciles the video again. It returns false when
+// probing has been disabled.
+func (w *VideoWatcher) readKeyframes(vt *transcodes.VideoTranscode) bool {
+	w.mu.RLock()
+	prober := w.Prober
+	w.mu.RUnlock()
+	if prober == nil {
+		return false
+	}
+
+	w.keyframes.init()
+	w.keyframes.results[vt.Name] = nil
+	name := vt.Name
+	path := filepath.Join(w.ClaimDir, vt.Spec.SourcePath)
+	done := w.keyframes.done
+
+	log.Printf("reading the keyframes of %s\n", name)
+	go func() {
+		keyframes, err := prober.Keyframes(path)
+		select {
+		case <-w.done:
+		case done <- keyframeResult{name: name, keyframes: keyframes, err: err}:
+		}
+	}()
+	return true
+}
+
+// receiveKeyframes records the keyframes of a video, so that they are used
+// the next time the video is reconciled.
+func (w *VideoWatcher) receiveKeyframes(result keyframeResult) {
+	w.keyframes.init()
+	w.keyframes.results[result.name] = &result
+}
+
+// checkKeyframes splits a video once its keyframes are read, and starts
+// transcoding the segments. The keyframes are read again when the watcher
+// restarted while they were being read. The video is transcoded in a single
+// job when it can't be split.
+func (w *VideoWatcher) checkKeyframes(vt *transcodes.VideoTranscode) {
+	w.mu.RLock()
+	rules := w.Presets.Rules
+	w.mu.RUnlock()
+
+	decision := probe.Decision{Action: probe.Transcode}
+	if vt.Status.Media != nil {
+		decision = probe.Decide(rules, *vt.Status.Media)
+	}
+
+	w.keyframes.init()
+	result, ok := w.keyframes.results[vt.Name]
+	if !ok {
+		if !w.shouldSplit(vt) || !w.readKeyframes(vt) {
+			w.startSingleTranscode(vt, decision)
+		}
+		return
+	}
+	if result == nil {
+		return
+	}
+	delete(w.keyframes.results, vt.Name)
+
+	if probe.IsInvalidMedia(result.err) {
+		w.failTranscode(vt, transcodes.Transcoded, reasonInvalidMedia, result.err.Error())
+		return
+	}
+	if result.err != nil {
+		log.Println(errors.Wrapf(result.err, "unable to split %s, transcoding it in a single job", vt.Name))
+	}
+
+	var segments []transcoder.Segment
+	if result.err == nil && decision.Action == probe.Transcode {
+		segments = w.planSegments(vt, result.keyframes)
+	}
+	if len(segments) == 0 {
+		w.startSingleTranscode(vt, decision)
+		return
+	}
+	w.startSegments(vt, decision, segments)
+}
+
+// startSegments creates a job for each segment of a video, which run in
+// parallel. The jobs are named NAME-part-000, NAME-part-001, and so on.
+func (w *VideoWatcher) startSegments(vt *transcodes.VideoTranscode, decision probe.Decision, segments []transcoder.Segment) {
+	settings, err := w.transcodeSettings(vt, decision)
+	if err != nil {
+		w.failTranscode(vt, transcodes.Transcoded, reasonJobCreateFailed, err.Error())
+		return
+	}
+
+	// Remove the segments of an earlier attempt
+	segmentsDir := w.segmentsDir(vt)
+	if err := os.RemoveAll(segmentsDir); err != nil {
+		log.Println(errors.Wrapf(err, "unable to remove the old segments of %s in %s", vt.Name, segmentsDir))
+	}
+
+	log.Printf("splitting %s into %d segments\n", vt.Name, len(segments))
+	vt.Status.Segments = make([]transcodes.Segment, 0, len(segments))
+	for _, s := range segments {
+		job := transcoder.Job{
+			InputPath:  filepath.Join(w.ClaimDir, vt.Spec.SourcePath),
+			OutputPath: w.segmentPath(vt, s.Index, settings.profile.Container),
+			Start:      s.Start,
+			End:        s.End,
+		}
+		jobName, err := w.startTranscodeStep(vt, settings, SegmentJobType, fmt.Sprintf("%s-part-%03d", vt.Name, s.Index), job)
+		if err != nil {
+			log.Println(err)
+			w.stopSegments(vt)
+			w.failTranscode(vt, transcodes.Transcoded, reasonJobCreateFailed, err.Error())
+			return
+		}
+		vt.Status.Segments = append(vt.Status.Segments, transcodes.Segment{Start: s.Start, End: s.End, Job: jobName})
+	}
+
+	vt.Status.Phase = transcodes.Transcoding
+	w.saveTranscodeStatus(vt)
+}
+
+// checkSegments waits for every segment of a video to be transcoded, and
+// then for the concat job that joins them. The video fails as soon as any
+// segment fails, and the other segments are stopped.
+func (w *VideoWatcher) checkSegments(vt *transcodes.VideoTranscode) {
+	if vt.Status.ConcatJob != "" {
+		w.checkConcat(vt)
+		return
+	}
+
+	allFinished := true
+	for i, s := range vt.Status.Segments {
+		result, finished := w.executor.Result(s.Job)
+		if !finished {
+			allFinished = false
+			continue
+		}
+		if !result.Succeeded() {
+			message := fmt.Sprintf("segment %d of %d failed", i+1, len(vt.Status.Segments))
+			if result.Message != "" {
+				message += ": " + result.Message
+			}
+			w.stopSegments(vt)
+			w.failTranscode(vt, transcodes.Transcoded, result.Reason, message)
+			return
+		}
+	}
+	if !allFinished {
+		return
+	}
+
+	jobName, err := w.createConcatJob(vt)
+	if err != nil {
+		log.Println(err)
+		w.failTranscode(vt, transcodes.Transcoded, reasonJobCreateFailed, err.Error())
+		return
+	}
+	vt.Status.ConcatJob = jobName
+	w.saveTranscodeStatus(vt)
+}
+
+// stopSegments stops the segments of a video that haven't finished, once the
+// video has failed, so that they don't keep using the cluster.
+func (w *VideoWatcher) stopSegments(vt *transcodes.VideoTranscode) {
+	for _, s := range vt.Status.Segments {
+		if _, finished := w.executor.Result(s.Job); finished {
+			continue
+		}
+		if err := w.executor.Stop(s.Job); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// createConcatJob writes the list of transcoded segments, and starts the job
+// that joins them into the transcoded video with ffmpeg.
+func (w *VideoWatcher) createConcatJob(vt *transcodes.VideoTranscode) (string, error) {
+	// The presets may have been reloaded since the segments were created,
+	// so use the segments that were transcoded, which are sorted by name
+	segmentsDir := w.segmentsDir(vt)
+	paths, err := filepath.Glob(filepath.Join(segmentsDir, transcoder.SegmentGlob))
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to list the segments of %s", vt.Name)
+	}
+	if len(paths) != len(vt.Status.Segments) {
+		return "", errors.Errorf("expected %d transcoded segments of %s in %s, found %d",
+			len(vt.Status.Segments), vt.Name, segmentsDir, len(paths))
+	}
+	container := strings.TrimPrefix(filepath.Ext(paths[0]), ".")
+
+	listPath := filepath.Join(segmentsDir, concatListFile)
+	err = ioutil.WriteFile(listPath, []byte(transcoder.ConcatList(paths)), 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to write the segments of %s to %s", vt.Name, listPath)
+	}
+
+	outputPath := w.transcodedPath(vt)
+	log.Printf("creating concat job for %s\n", filepath.Base(outputPath))
+	values := transcodeJobValues{
+		Name:       vt.Name,
+		JobName:    ConcatJobName(vt.Name),
+		JobType:    TranscodeJobType,
+		InputPath:  listPath,
+		OutputDir:  filepath.Dir(outputPath),
+		OutputPath: outputPath,
+		PathSuffix: vt.Spec.SourcePath,
+		Transcoder: transcoder.FFmpeg,
+		Image:      transcoder.FFmpegImage,
+		Command:    transcoder.ConcatCommand(listPath, outputPath, container),
+
+		PriorityClassName: vt.Spec.PriorityClassName,
+	}
+	return w.startTranscodeJob(vt, values)
+}
+
+// checkConcat starts the upload once the segments are joined, and removes
+// the segments.
+func (w *VideoWatcher) checkConcat(vt *transcodes.VideoTranscode) {
+	result, finished := w.executor.Result(vt.Status.ConcatJob)
+	if !finished {
+		return
+	}
+
+	if !result.Succeeded() {
+		w.failTranscode(vt, transcodes.Transcoded, result.Reason, result.Message)
+		return
+	}
+
+	segmentsDir := w.segmentsDir(vt)
+	if err := os.RemoveAll(segmentsDir); err != nil {
+		log.Println(errors.Wrapf(err, "unable to remove the segments of %s in %s", vt.Name, segmentsDir))
+	}
+
+	setCondition(vt, transcodes.Transcoded, metav1.ConditionTrue, reasonJobSucceeded,
+		fmt.Sprintf("transcoded in %d segments", len(vt.Status.Segments)))
+	w.startUpload(vt)
+}
+
+// segmentsDir is where the segments of a split video are transcoded, next
+// to the transcoded video.
+func (w *VideoWatcher) segmentsDir(vt *transcodes.VideoTranscode) string {
+	return w.transcodedPath(vt) + ".parts"
+}
+
+// segmentPath is where a segment of a split video is transcoded.
+func (w *VideoWatcher) segmentPath(vt *transcodes.VideoTranscode, index int, container string) string {
+	return filepath.Join(w.segmentsDir(vt), transcoder.SegmentFile(index, container))
+}
@@ -0,0 +1,311 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// enableTestSegments splits videos into at most 4 segments, of at least 5 minutes.
+func enableTestSegments(w *VideoWatcher) {
+	w.Prober = testProber{
+		"long.mkv":  {Container: "mkv", VideoCodec: "h264", Height: 1080, DurationSeconds: 3600, AudioTracks: 1},
+		"short.mkv": {Container: "mkv", VideoCodec: "h264", Height: 1080, DurationSeconds: 400, AudioTracks: 1},
+	}
+	w.Segments = SegmentConfig{Count: 4, MinDuration: metav1.Duration{Duration: 5 * time.Minute}}
+}
+
+// receiveTestKeyframes waits for the keyframes of a video to be read in the
+// background, like the operator, and reconciles the video to split it.
+func receiveTestKeyframes(t *testing.T, w *VideoWatcher, vt *transcodes.VideoTranscode) *transcodes.VideoTranscode {
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+	if len(vt.Status.Segments) != 0 || vt.Status.TranscodeJob != "" {
+		t.Fatalf("expected the video to wait for its keyframes, got %#v", vt.Status)
+	}
+
+	select {
+	case result := <-w.keyframes.done:
+		w.receiveKeyframes(result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the keyframes")
+	}
+	w.reconcileTranscode(vt)
+	return getTestTranscode(t, w, vt.Name)
+}
+
+// assertJobsDeleted checks that the jobs no longer exist.
+func assertJobsDeleted(t *testing.T, client *fake.Clientset, names ...string) {
+	for _, name := range names {
+		_, err := client.BatchV1().Jobs(Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if !apierrors.IsNotFound(err) {
+			t.Fatalf("expected %s to be deleted, got %#v", name, err)
+		}
+	}
+}
+
+// writeTestSegments stands in for the segment jobs, which don't run in the tests.
+func writeTestSegments(t *testing.T, w *VideoWatcher, vt *transcodes.VideoTranscode) {
+	for i := range vt.Status.Segments {
+		writeTestVideo(t, w.segmentPath(vt, i, "mkv"))
+	}
+}
+
+func TestVideoWatcher_PlanSegments(t *testing.T) {
+	testcases := []struct {
+		name      string
+		config    SegmentConfig
+		media     *probe.MediaInfo
+		wantCount int
+	}{
+		{"disabled", SegmentConfig{Count: 1}, &probe.MediaInfo{DurationSeconds: 3600}, 0},
+		{"not probed", SegmentConfig{Count: 4}, nil, 0},
+		{"too short", SegmentConfig{Count: 4, MinDuration: metav1.Duration{Duration: 5 * time.Minute}}, &probe.MediaInfo{DurationSeconds: 400}, 0},
+		{"fewer segments", SegmentConfig{Count: 4, MinDuration: metav1.Duration{Duration: 5 * time.Minute}}, &probe.MediaInfo{DurationSeconds: 1000}, 3},
+		{"split", SegmentConfig{Count: 4, MinDuration: metav1.Duration{Duration: 5 * time.Minute}}, &probe.MediaInfo{DurationSeconds: 3600}, 4},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w, _, cleanup := buildTestWatcher(t)
+			defer cleanup()
+			enableTestSegments(w)
+			w.Segments = tc.config
+			if tc.media != nil {
+				w.Prober = testProber{"movie.mkv": *tc.media}
+			}
+
+			vt := submitTestVideo(t, w, "Movies/movie.mkv")
+			vt.Status.Media = tc.media
+			var segments []transcoder.Segment
+			if w.shouldSplit(vt) {
+				keyframes, err := w.Prober.Keyframes(filepath.Join(w.ClaimDir, vt.Spec.SourcePath))
+				if err != nil {
+					t.Fatalf("%#v", err)
+				}
+				segments = w.planSegments(vt, keyframes)
+			}
+			if len(segments) != tc.wantCount {
+				t.Fatalf("expected %d segments, got %#v", tc.wantCount, segments)
+			}
+		})
+	}
+}
+
+func TestVideoWatcher_ReconcileTranscode_Segments(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	enableTestSegments(w)
+
+	vt := submitTestVideo(t, w, "Movies/long.mkv")
+
+	// Pending -> Transcoding, with a job for each segment once the keyframes are read
+	w.reconcileTranscode(vt)
+	vt = receiveTestKeyframes(t, w, vt)
+	assertPhase(t, vt, transcodes.Transcoding)
+	wantSegments := []transcodes.Segment{
+		{Start: 0, End: 900, Job: "movies-long-mkv-part-000"},
+		{Start: 900, End: 1800, Job: "movies-long-mkv-part-001"},
+		{Start: 1800, End: 2700, Job: "movies-long-mkv-part-002"},
+		{Start: 2700, Job: "movies-long-mkv-part-003"},
+	}
+	if len(vt.Status.Segments) != len(wantSegments) {
+		t.Fatalf("expected %d segments, got %#v", len(wantSegments), vt.Status.Segments)
+	}
+	for i, want := range wantSegments {
+		if vt.Status.Segments[i] != want {
+			t.Fatalf("expected segment %d to be %#v, got %#v", i, want, vt.Status.Segments[i])
+		}
+	}
+	if vt.Status.TranscodeJob != "" {
+		t.Fatalf("expected the video to not be transcoded in a single job, got %s", vt.Status.TranscodeJob)
+	}
+
+	segment := getTestJob(t, client, "movies-long-mkv-part-001")
+	if segment.Labels[JobTypeLabel] != SegmentJobType || segment.Labels[VideoLabel] != vt.Name {
+		t.Fatalf("expected the segment job to be labeled with its type and video, got %v", segment.Labels)
+	}
+	command := strings.Join(segment.Spec.Template.Spec.Containers[0].Command, " ")
+	wantCommand := "-o " + filepath.Join(w.TranscodedDir, "Movies/long.mkv.parts/part-001.mkv") +
+		" --start-at seconds:900.000 --stop-at seconds:900.000"
+	if !strings.Contains(command, wantCommand) {
+		t.Fatalf("expected the segment job to transcode the second segment, got %s", command)
+	}
+
+	// Nothing happens until every segment is transcoded
+	writeTestSegments(t, w, vt)
+	for _, s := range vt.Status.Segments[:3] {
+		setTestJobStatus(t, client, s.Job, succeededStatus)
+	}
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	if vt.Status.ConcatJob != "" {
+		t.Fatalf("expected the segments to be joined once they are all transcoded, got %s", vt.Status.ConcatJob)
+	}
+
+	// Segments -> concat
+	setTestJobStatus(t, client, vt.Status.Segments[3].Job, succeededStatus)
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+	if vt.Status.ConcatJob != "movies-long-mkv-concat" {
+		t.Fatalf("expected the concat job to be created, got %q", vt.Status.ConcatJob)
+	}
+	concat := getTestJob(t, client, vt.Status.ConcatJob)
+	if concat.Labels[JobTypeLabel] != TranscodeJobType || concat.Spec.Template.Spec.Containers[0].Image != transcoder.FFmpegImage {
+		t.Fatalf("expected the concat job to be a transcode job running ffmpeg, got %v", concat.Labels)
+	}
+	list, err := ioutil.ReadFile(filepath.Join(w.segmentsDir(vt), concatListFile))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if strings.Count(string(list), "file ") != 4 || !strings.HasSuffix(string(list), "part-003.mkv'\n") {
+		t.Fatalf("expected the concat list to have the segments in order, got %s", list)
+	}
+
+	// Concat -> Uploading, and the segments are removed
+	writeTestVideo(t, w.transcodedPath(vt))
+	setTestJobStatus(t, client, vt.Status.ConcatJob, succeededStatus)
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Uploading)
+	assertCondition(t, vt, transcodes.Transcoded, metav1.ConditionTrue, reasonJobSucceeded)
+	if _, err := os.Stat(w.segmentsDir(vt)); !os.IsNotExist(err) {
+		t.Fatalf("expected the segments to be removed, got %v", err)
+	}
+	upload := getTestJob(t, client, vt.Status.UploadJob)
+	if args := strings.Join(upload.Spec.Template.Spec.Containers[0].Args, " "); !strings.Contains(args, "-f "+w.transcodedPath(vt)) {
+		t.Fatalf("expected the joined video to be uploaded, got %s", args)
+	}
+}
+
+func TestVideoWatcher_ReconcileTranscode_SegmentFailed(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	enableTestSegments(w)
+
+	vt := submitTestVideo(t, w, "Movies/long.mkv")
+	w.reconcileTranscode(vt)
+	vt = receiveTestKeyframes(t, w, vt)
+	failed := testutil.ToFloat64(metrics.PipelinesFinished.WithLabelValues(metrics.Failed))
+
+	// The video fails as soon as a segment fails, without waiting on the rest
+	setTestJobStatus(t, client, vt.Status.Segments[2].Job, failedStatus)
+	setTestJobStatus(t, client, vt.Status.Segments[3].Job, failedStatus)
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	w.reconcileTranscode(vt)
+	if got := testutil.ToFloat64(metrics.PipelinesFinished.WithLabelValues(metrics.Failed)); got != failed+1 {
+		t.Fatalf("expected the failed video to be counted once, got %v", got-failed)
+	}
+	assertPhase(t, vt, transcodes.Failed)
+	assertCondition(t, vt, transcodes.Transcoded, metav1.ConditionFalse, reasonJobFailed)
+	if cond := vt.Status.Conditions[0]; cond.Message != "segment 3 of 4 failed" {
+		t.Fatalf("expected the message to identify the segment, got %q", cond.Message)
+	}
+	assertFileExists(t, filepath.Join(w.FailedDir, "Movies/long.mkv"))
+	if vt.Status.ConcatJob != "" {
+		t.Fatalf("expected the segments to not be joined, got %s", vt.Status.ConcatJob)
+	}
+
+	// The segments that were still running are stopped
+	assertJobsDeleted(t, client, vt.Status.Segments[0].Job, vt.Status.Segments[1].Job)
+	getTestJob(t, client, vt.Status.Segments[2].Job)
+}
+
+// failingExecutor fails to start one of the runs.
+type failingExecutor struct {
+	Executor
+	fail string
+}
+
+func (e failingExecutor) Start(step Step) (string, error) {
+	if runName(step) == e.fail {
+		return "", errors.Errorf("unable to create %s, the cluster is out of quota", e.fail)
+	}
+	return e.Executor.Start(step)
+}
+
+func TestVideoWatcher_ReconcileTranscode_SegmentCreateFailed(t *testing.T) {
+	w, client, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	enableTestSegments(w)
+
+	w.executor = failingExecutor{Executor: w.executor, fail: "movies-long-mkv-part-002"}
+
+	vt := submitTestVideo(t, w, "Movies/long.mkv")
+	w.reconcileTranscode(vt)
+	vt = receiveTestKeyframes(t, w, vt)
+	assertPhase(t, vt, transcodes.Failed)
+	assertCondition(t, vt, transcodes.Transcoded, metav1.ConditionFalse, reasonJobCreateFailed)
+
+	// The segments that were started before the failure are stopped
+	assertJobsDeleted(t, client, "movies-long-mkv-part-000", "movies-long-mkv-part-001")
+}
+
+func TestVideoWatcher_ReconcileTranscode_ShortVideo(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	enableTestSegments(w)
+
+	vt := submitTestVideo(t, w, "Movies/short.mkv")
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+	if len(vt.Status.Segments) != 0 || vt.Status.TranscodeJob != "movies-short-mkv-transcode" {
+		t.Fatalf("expected a short video to be transcoded in a single job, got %#v", vt.Status)
+	}
+}
+
+// slowProber reads keyframes once it is released, like ffprobe reading a
+// long video from a network share.
+type slowProber struct {
+	testProber
+	release chan struct{}
+}
+
+func (p slowProber) Keyframes(path string) ([]float64, error) {
+	<-p.release
+	return p.testProber.Keyframes(path)
+}
+
+func TestVideoWatcher_ReconcileTranscode_SlowKeyframes(t *testing.T) {
+	w, _, cleanup := buildTestWatcher(t)
+	defer cleanup()
+	defer close(w.done)
+	enableTestSegments(w)
+	prober := slowProber{testProber: w.Prober.(testProber), release: make(chan struct{})}
+	w.Prober = prober
+
+	// Reconciling doesn't wait for the keyframes
+	vt := submitTestVideo(t, w, "Movies/long.mkv")
+	w.reconcileTranscode(vt)
+	vt = getTestTranscode(t, w, vt.Name)
+	assertPhase(t, vt, transcodes.Transcoding)
+	w.reconcileTranscode(vt)
+
+	// The keyframes are read again after the watcher restarts
+	w.keyframes = keyframeReads{}
+	w.reconcileTranscode(vt)
+	close(prober.release)
+
+	vt = receiveTestKeyframes(t, w, vt)
+	if len(vt.Status.Segments) != 4 {
+		t.Fatalf("expected the video to be split once the keyframes were read, got %#v", vt.Status)
+	}
+}
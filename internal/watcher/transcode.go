@@ -1,36 +1,159 @@
 package watcher
 
 import (
-	"io/ioutil"
 	"log"
 	"path/filepath"
+	"strings"
 
-	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	"github.com/carolynvs/handbrk8s/internal/probe"
+	"github.com/carolynvs/handbrk8s/internal/transcoder"
 	"github.com/pkg/errors"
 )
 
+// jobPresetsFile is where the transcode job template mounts the HandBrake presets.
+const jobPresetsFile = "/config/ghb/presets.json"
+
 // TranscodeJobValues are the set of values to replace in transcodeJobYaml
 type transcodeJobValues struct {
 	Name, InputPath, OutputDir, OutputPath, Preset string
+	PathSuffix                                     string
+
+	// JobName is the name of the job, e.g. NAME-transcode, or NAME-part-001
+	// for a segment of a split video.
+	JobName string
+
+	// JobType is set on the job-type label, TranscodeJobType or SegmentJobType.
+	JobType string
+
+	// Transcoder transcodes the video, e.g. handbrake or ffmpeg.
+	Transcoder string
+
+	// Image runs the transcoder in the transcode job.
+	Image string
+
+	// Command transcodes the video, including the program, e.g. HandBrakeCLI.
+	Command []string
+
+	// PriorityClassName is set on the pod, when the video has a priority class.
+	PriorityClassName string
+}
+
+// remuxPreset is the name of the profile used by the remux action.
+const remuxPreset = "remux"
+
+// transcodeSettings are the preset and transcoder selected for a video.
+type transcodeSettings struct {
+	preset      string
+	profile     transcoder.Profile
+	transcoder  transcoder.Transcoder
+	presetsFile string
 }
 
-// CreateTranscodeJob creates a job to transcode a video
-func (w *VideoWatcher) createTranscodeJob(inputPath string, outputPath string) (jobName string, err error) {
-	templateFile := filepath.Join(w.TemplatesDir, "transcode.yaml")
-	template, err := ioutil.ReadFile(templateFile)
+// transcodeSettings selects the preset of a video. The preset of the probe
+// rule that matched the video takes precedence over the preset of the
+// VideoTranscode.
+func (w *VideoWatcher) transcodeSettings(vt *transcodes.VideoTranscode, decision probe.Decision) (transcodeSettings, error) {
+	w.mu.RLock()
+	preset := decision.Preset
+	if preset == "" {
+		preset = vt.Spec.Preset
+	}
+	if preset == "" {
+		preset = w.Presets.Preset(vt.Spec.SourcePath)
+	}
+	profile := w.Presets.Profile(preset)
+	if decision.Action == probe.Remux {
+		preset = remuxPreset
+		profile = remuxProfile(vt)
+	}
+	presetsFile := jobPresetsFile
+	if w.executorType == LocalExecutor {
+		presetsFile = w.HandBrakePresets
+	}
+	w.mu.RUnlock()
+
+	profile = profile.WithDefaults()
+	t, err := transcoder.New(profile)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not read %s", templateFile)
+		return transcodeSettings{}, errors.Wrapf(err, "invalid profile %s", preset)
 	}
+	return transcodeSettings{preset: preset, profile: profile, transcoder: t, presetsFile: presetsFile}, nil
+}
 
-	filename := filepath.Base(inputPath)
+// CreateTranscodeJob starts the step that transcodes a claimed video
+func (w *VideoWatcher) createTranscodeJob(vt *transcodes.VideoTranscode, decision probe.Decision) (jobName string, err error) {
+	settings, err := w.transcodeSettings(vt, decision)
+	if err != nil {
+		return "", err
+	}
 
-	log.Printf("creating transcode job for %s\n", filename)
+	job := transcoder.Job{
+		InputPath:  filepath.Join(w.ClaimDir, vt.Spec.SourcePath),
+		OutputPath: w.transcodedPath(vt),
+	}
+	return w.startTranscodeStep(vt, settings, TranscodeJobType, vt.Name+"-transcode", job)
+}
+
+// startTranscodeStep runs the transcoder on a video, or a segment of it.
+func (w *VideoWatcher) startTranscodeStep(vt *transcodes.VideoTranscode, settings transcodeSettings, jobType, jobName string, job transcoder.Job) (string, error) {
+	job.PresetsFile = settings.presetsFile
+	command, err := settings.transcoder.Command(job)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("creating %s %s job for %s\n", settings.profile.Transcoder, jobType, filepath.Base(job.InputPath))
 	values := transcodeJobValues{
-		Name:       jobs.SanitizeJobName(filename),
-		InputPath:  inputPath,
-		OutputDir:  filepath.Dir(outputPath),
-		OutputPath: outputPath,
-		Preset:     w.VideoPreset,
+		Name:       vt.Name,
+		JobName:    jobName,
+		JobType:    jobType,
+		InputPath:  job.InputPath,
+		OutputDir:  filepath.Dir(job.OutputPath),
+		OutputPath: job.OutputPath,
+		Preset:     settings.preset,
+		PathSuffix: vt.Spec.SourcePath,
+		Transcoder: settings.profile.Transcoder,
+		Image:      settings.transcoder.Image(),
+		Command:    command,
+
+		PriorityClassName: vt.Spec.PriorityClassName,
 	}
-	return jobs.CreateFromTemplate(string(template), values)
+	return w.startTranscodeJob(vt, values)
+}
+
+// startTranscodeJob starts a job from the transcode job template.
+func (w *VideoWatcher) startTranscodeJob(vt *transcodes.VideoTranscode, values transcodeJobValues) (string, error) {
+	jobName, err := w.executor.Start(Step{Type: TranscodeJobType, Video: vt, Values: values})
+	if err == nil {
+		metrics.JobsCreated.WithLabelValues(values.JobType).Inc()
+	}
+	return jobName, err
+}
+
+// remuxProfile copies the video and every audio track of a video into a new
+// file, in the format of its file extension, without encoding them again.
+func remuxProfile(vt *transcodes.VideoTranscode) transcoder.Profile {
+	profile := transcoder.Profile{
+		Transcoder: transcoder.FFmpeg,
+		VideoCodec: "copy",
+		AudioCodec: "copy",
+		Container:  "mkv",
+	}
+	switch strings.ToLower(filepath.Ext(vt.Spec.SourcePath)) {
+	case ".mp4", ".m4v":
+		profile.Container = "mp4"
+	}
+	if vt.Status.Media != nil {
+		for track := 1; track <= vt.Status.Media.AudioTracks; track++ {
+			profile.AudioTracks = append(profile.AudioTracks, track)
+		}
+	}
+	return profile
+}
+
+// transcodedPath is where the transcode job saves the video.
+func (w *VideoWatcher) transcodedPath(vt *transcodes.VideoTranscode) string {
+	return filepath.Join(w.TranscodedDir, vt.Spec.SourcePath)
 }
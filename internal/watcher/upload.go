@@ -1,43 +1,54 @@
 package watcher
 
 import (
-	"io/ioutil"
 	"log"
 	"path/filepath"
 
-	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
-	"github.com/pkg/errors"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
+	"github.com/carolynvs/handbrk8s/internal/probe"
 )
 
 type uploadJobValues struct {
-	WaitForJob                    string
 	Name, TranscodedFile, RawFile string
 	DestinationSuffix             string
 	PlexServer, PlexToken         string
 	PlexLibrary, PlexShare        string
+	PriorityClassName             string
 }
 
-// CreateUploadJob creates a job to upload a video to Plex
-func (w *VideoWatcher) createUploadJob(waitForJob, transcodedFile, rawFile, pathSuffix, library string) (jobName string, err error) {
-	templateFile := filepath.Join(w.TemplatesDir, "upload.yaml")
-	template, err := ioutil.ReadFile(templateFile)
-	if err != nil {
-		return "", errors.Wrapf(err, "could not read %s", templateFile)
-	}
+// CreateUploadJob starts the step that uploads a video to Plex, once it has been transcoded
+func (w *VideoWatcher) createUploadJob(vt *transcodes.VideoTranscode) (jobName string, err error) {
+	w.mu.RLock()
+	plexCfg := w.PlexCfg
+	w.mu.RUnlock()
 
-	filename := filepath.Base(transcodedFile)
+	transcodedFile := w.uploadSourcePath(vt)
 
-	log.Printf("creating upload job for %s\n", filename)
+	log.Printf("creating upload job for %s\n", filepath.Base(transcodedFile))
 	values := uploadJobValues{
-		Name:              jobs.SanitizeJobName(filename),
-		WaitForJob:        waitForJob,
+		Name:              vt.Name,
 		TranscodedFile:    transcodedFile,
-		RawFile:           rawFile,
-		DestinationSuffix: pathSuffix,
-		PlexServer:        w.PlexCfg.URL,
-		PlexToken:         w.PlexCfg.Token,
-		PlexLibrary:       library,
-		PlexShare:         w.PlexCfg.Share, // Assume that the library name is the share path
+		RawFile:           filepath.Join(w.ClaimDir, vt.Spec.SourcePath),
+		DestinationSuffix: vt.Spec.Destination,
+		PlexServer:        plexCfg.URL,
+		PlexToken:         plexCfg.Token,
+		PlexLibrary:       vt.Spec.Library,
+		PlexShare:         plexCfg.Share, // Assume that the library name is the share path
+		PriorityClassName: vt.Spec.PriorityClassName,
+	}
+	jobName, err = w.executor.Start(Step{Type: UploadJobType, Video: vt, Values: values})
+	if err == nil {
+		metrics.JobsCreated.WithLabelValues(UploadJobType).Inc()
+	}
+	return jobName, err
+}
+
+// uploadSourcePath is the video that is uploaded, which is the original video
+// when the probe rules skipped the transcode.
+func (w *VideoWatcher) uploadSourcePath(vt *transcodes.VideoTranscode) string {
+	if vt.Status.Action == probe.Upload {
+		return filepath.Join(w.ClaimDir, vt.Spec.SourcePath)
 	}
-	return jobs.CreateFromTemplate(string(template), values)
+	return w.transcodedPath(vt)
 }
@@ -5,66 +5,149 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/transcodes"
+	"github.com/carolynvs/handbrk8s/internal/metrics"
 	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/probe"
 	"github.com/pkg/errors"
 )
 
 const Namespace = "handbrk8s"
 
+const (
+	// VideoLabel identifies the video that a job is processing.
+	VideoLabel = "video"
+
+	// JobTypeLabel identifies the step, such as transcode or upload, that a job performs.
+	JobTypeLabel = "job-type"
+
+	// VideoPathAnnotation is the path of the video, relative to the watch directory.
+	VideoPathAnnotation = jobs.SourceAnnotation
+)
+
 type VideoWatcher struct {
 	done chan struct{}
 
-	// WatchDir contains raw (untranscoded) video files.
-	WatchDir string
+	// mu guards the settings that can be changed by Reload.
+	mu sync.RWMutex
+
+	// dirWatcher waits for videos to stop changing in the watch directory.
+	dirWatcher *fs.StableFileWatcher
 
-	// ClaimDir temporarily holds raw video files while they are being transcoded.
-	ClaimDir string
+	// executor runs the transcode and upload steps of each video.
+	executor Executor
 
-	// TranscodedDir contains completed (transcoded) video files.
-	TranscodedDir string
+	// executorType is the configured executor, which can't be changed by Reload.
+	executorType string
+
+	// transcodeClient submits the VideoTranscode for each video, and saves
+	// its status. It is a transcodes.MemoryStore with the local executor.
+	transcodeClient transcodes.Store
+
+	Directories
 
 	// TemplatesDir contains templates for jobs that are created by the watcher.
 	TemplatesDir string
 
-	FailedDir string
-
-	// VideoPreset is the name of a HandBrake preset.
-	VideoPreset string
+	// Presets selects the HandBrake preset for each video.
+	Presets PresetConfig
 
 	// PlexCfg contains connection information upload a file to a Plex server.
 	PlexCfg plex.LibraryConfig
+
+	// StableThreshold is how long a video must not change before it is processed.
+	StableThreshold time.Duration
+
+	// ReplacePolicy decides if a finished VideoTranscode, or a job, with the
+	// same name is replaced.
+	ReplacePolicy jobs.ReplacePolicy
+
+	// HandBrakePresets is the path to the HandBrake presets used by the local executor.
+	HandBrakePresets string
+
+	// Prober reads the media info of each video before it is transcoded,
+	// and is nil when probing is disabled.
+	Prober probe.Prober
+
+	// Segments splits long videos into segments that are transcoded in parallel.
+	Segments SegmentConfig
+
+	// Queue prioritizes the videos, and limits how many are transcoded at the same time.
+	Queue QueueConfig
+
+	// queue holds the videos waiting to be transcoded, and is only used by the operator.
+	queue transcodeQueue
+
+	// keyframes are read in the background before a video is split, and
+	// are only used by the operator.
+	keyframes keyframeReads
 }
 
 // NewVideoWatcher begins watching for new videos to transcode.
-func NewVideoWatcher(configVolume, watchVolume, workVolume string, videoPreset string, plexCfg plex.LibraryConfig) (*VideoWatcher, error) {
-	if _, err := os.Stat(configVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("config volume, %s, is not mounted", configVolume)
+func NewVideoWatcher(cfg Config) (*VideoWatcher, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(watchVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("watch volume, %s, is not mounted", watchVolume)
+	if _, err := os.Stat(cfg.ConfigVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("config volume, %s, is not mounted", cfg.ConfigVolume)
 	}
 
-	if _, err := os.Stat(workVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("work volume, %s, is not mounted", workVolume)
+	if _, err := os.Stat(cfg.WatchVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("watch volume, %s, is not mounted", cfg.WatchVolume)
+	}
+
+	if _, err := os.Stat(cfg.WorkVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("work volume, %s, is not mounted", cfg.WorkVolume)
+	}
+
+	presets, err := cfg.LoadPresets()
+	if err != nil {
+		return nil, err
 	}
 
 	w := &VideoWatcher{
-		done:          make(chan struct{}),
-		WatchDir:      filepath.Join(watchVolume, "watch"),
-		FailedDir:     filepath.Join(watchVolume, "fail"),
-		ClaimDir:      filepath.Join(workVolume, "claim"),
-		TranscodedDir: filepath.Join(workVolume, "work"),
-		TemplatesDir:  filepath.Join(configVolume, "templates"),
-		VideoPreset:   videoPreset,
-		PlexCfg:       plexCfg,
+		done:             make(chan struct{}),
+		executorType:     cfg.Executor,
+		Directories:      cfg.Directories(),
+		TemplatesDir:     cfg.TemplatesDir(),
+		Presets:          presets,
+		PlexCfg:          cfg.PlexLibraryConfig(),
+		StableThreshold:  cfg.StableThreshold.Duration,
+		ReplacePolicy:    cfg.ReplacePolicy,
+		HandBrakePresets: cfg.HandBrakePresetsFile(),
+		Prober:           cfg.Probe.Prober(),
+		Segments:         cfg.Segments,
+		Queue:            cfg.Queue,
 	}
 
-	err := os.MkdirAll(w.WatchDir, 0755)
+	if cfg.Executor == LocalExecutor {
+		log.Println("running the transcode and upload steps locally, videos are tracked in memory")
+		w.executor = newLocalExecutor(cfg.Local)
+		w.transcodeClient = transcodes.NewMemoryStore()
+	} else {
+		client, err := api.GetClient()
+		if err != nil {
+			return nil, err
+		}
+
+		dynamicClient, err := api.GetDynamicClient()
+		if err != nil {
+			return nil, err
+		}
+
+		w.executor = newKubernetesExecutor(w, client)
+		w.transcodeClient = transcodes.NewClient(dynamicClient)
+	}
+
+	err = os.MkdirAll(w.WatchDir, 0755)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to create watch directory %s", w.WatchDir)
 	}
@@ -90,10 +173,18 @@ func NewVideoWatcher(configVolume, watchVolume, workVolume string, videoPreset s
 }
 
 func (w *VideoWatcher) start() {
-	dirWatcher, err := fs.NewStableFileWatcher(w.WatchDir, 5*time.Second)
+	// Pick up where we left off before watching for new videos, so that
+	// requeued videos are found when the watch directory is first scanned
+	w.reconcileClaims()
+	go w.runOperator()
+
+	w.mu.Lock()
+	dirWatcher, err := fs.NewStableFileWatcher(w.WatchDir, w.StableThreshold)
 	if err != nil {
 		log.Fatal(errors.Wrapf(err, "unable to watch %s", w.WatchDir))
 	}
+	w.dirWatcher = dirWatcher
+	w.mu.Unlock()
 	defer dirWatcher.Close()
 
 	for {
@@ -110,11 +201,54 @@ func (w *VideoWatcher) Close() {
 	close(w.done)
 }
 
+// Reload applies a new configuration without interrupting videos that are
+// being processed. The volumes and directories cannot be changed while the
+// watcher is running, because in-flight videos would be stranded in the old
+// directories, so a restart is required to change them.
+func (w *VideoWatcher) Reload(cfg Config) error {
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	presets, err := cfg.LoadPresets()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if cfg.Directories() != w.Directories {
+		log.Printf("ignoring changes to the volumes and directories, restart the watcher to use %#v\n", cfg.Directories())
+	}
+	if cfg.Executor != w.executorType {
+		log.Printf("ignoring the change to the executor, restart the watcher to use the %s executor\n", cfg.Executor)
+	}
+
+	w.TemplatesDir = cfg.TemplatesDir()
+	w.Presets = presets
+	w.PlexCfg = cfg.PlexLibraryConfig()
+	w.StableThreshold = cfg.StableThreshold.Duration
+	w.ReplacePolicy = cfg.ReplacePolicy
+	w.HandBrakePresets = cfg.HandBrakePresetsFile()
+	w.Prober = cfg.Probe.Prober()
+	w.Segments = cfg.Segments
+	w.Queue = cfg.Queue
+	if w.dirWatcher != nil {
+		w.dirWatcher.SetStableThreshold(w.StableThreshold)
+	}
+
+	log.Println("reloaded the watcher configuration")
+	return nil
+}
+
 func (w *VideoWatcher) handleVideo(path string) {
 	// Ignore hidden files
-	if strings.HasPrefix(".", filepath.Base(path)) {
+	if isHidden(path) {
 		return
 	}
+	metrics.VideosDetected.Inc()
 
 	// Preserve the directory nesting of the video relative to the watch directory
 	// Example: /watch/Movies/Foo/bar.mkv -> Movies/Foo/bar.mkv
@@ -134,6 +268,7 @@ func (w *VideoWatcher) handleVideo(path string) {
 	if err != nil {
 		// TODO: add a retry with backoff
 		log.Println(errors.Wrapf(err, "unable to create directory %s", destDir))
+		metrics.Claims.WithLabelValues(metrics.Failed).Inc()
 		return
 	}
 
@@ -142,47 +277,104 @@ func (w *VideoWatcher) handleVideo(path string) {
 		// TODO: add a retry with backoff
 		log.Println(errors.Wrapf(err, "unable to move %s to %s, skipping for now",
 			path, claimPath))
+		metrics.Claims.WithLabelValues(metrics.Failed).Inc()
 		return
 	}
+	metrics.Claims.WithLabelValues(metrics.Succeeded).Inc()
 	os.Chmod(claimPath, 0666)
 
-	transcodedPath := filepath.Join(w.TranscodedDir, pathSuffix)
-	transcodeJobName, err := w.createTranscodeJob(claimPath, transcodedPath)
+	w.submitVideo(pathSuffix)
+}
+
+// submitVideo creates a VideoTranscode for a claimed video, which the
+// operator then processes. The video is moved to the failed directory when
+// the VideoTranscode cannot be created.
+func (w *VideoWatcher) submitVideo(pathSuffix string) {
+	w.mu.RLock()
+	preset := w.Presets.Preset(pathSuffix)
+	queue := w.Queue
+	policy := w.ReplacePolicy
+	w.mu.RUnlock()
+
+	priority := w.videoPriority(queue, pathSuffix)
+
+	var err error
+	for _, name := range videoNames(pathSuffix) {
+		vt := transcodes.New(name, Namespace, transcodes.VideoTranscodeSpec{
+			SourcePath:        pathSuffix,
+			Library:           libraryName(pathSuffix),
+			Preset:            preset,
+			Destination:       pathSuffix,
+			Priority:          priority,
+			PriorityClassName: queue.PriorityClassName(priority),
+		})
+		vt.Annotations = map[string]string{VideoPathAnnotation: pathSuffix}
+
+		_, err = w.transcodeClient.CreateOrReplace(vt, policy)
+		if !jobs.IsNameConflict(err) {
+			break
+		}
+		log.Println(err)
+	}
 	if err != nil {
 		log.Println(err)
-		w.cleanupFailedClaim(claimPath)
-		return
+		w.cleanupFailedClaim(pathSuffix)
 	}
+}
 
-	// Assume that the library is the first segment of the path, e.g. /watch/LIBRARY/../video.mkv
-	library := strings.Split(pathSuffix, string(os.PathSeparator))[0]
+// videoPriority is the priority of a claimed video, from the queue
+// configuration, or the priority marker in the watch directory.
+func (w *VideoWatcher) videoPriority(queue QueueConfig, pathSuffix string) int32 {
+	var size int64
+	if info, err := os.Stat(filepath.Join(w.ClaimDir, pathSuffix)); err == nil {
+		size = info.Size()
+	}
 
-	_, err = w.createUploadJob(transcodeJobName, transcodedPath, claimPath, pathSuffix, library)
+	marker, err := readPriorityMarker(w.WatchDir, pathSuffix)
 	if err != nil {
-		log.Println(err)
-		err = jobs.Delete(transcodeJobName, Namespace)
-		if err != nil {
-			log.Println(err)
-		}
-		w.cleanupFailedClaim(claimPath)
-		return
+		log.Println(errors.Wrapf(err, "ignoring the priority marker of %s", pathSuffix))
 	}
+	return queue.Priority(pathSuffix, size, marker)
 }
 
-func (w *VideoWatcher) cleanupFailedClaim(claimPath string) {
-	pathSuffix := strings.Replace(claimPath, w.ClaimDir, "", 1)
+// maxVideoNameLength leaves room in the job names for the longest suffix,
+// NAME-transcode. The NAME-part-000 and NAME-concat jobs of a split video fit.
+const maxVideoNameLength = jobs.MaxNameLength - len("-transcode")
 
-	log.Printf("cleaning up failed claim: %s\n", claimPath)
-	failedPath := filepath.Join(w.FailedDir, pathSuffix)
-	destDir := filepath.Dir(failedPath)
-	err := os.MkdirAll(destDir, 0755)
-	if err != nil {
-		// TODO: add a retry with backoff
-		log.Println(errors.Wrapf(err, "unable to create directory %s", destDir))
-		return
+// videoName is the name shared by the VideoTranscode and jobs that process
+// a video, and the value of their video label. The name is derived from the
+// full path, so that videos with the same file name in different
+// directories, e.g. TV/ShowA/S01E01.mkv and TV/ShowB/S01E01.mkv, don't collide.
+func videoName(pathSuffix string) string {
+	return jobs.SanitizeName(pathSuffix, maxVideoNameLength)
+}
+
+// videoNames are the names that a video can use, in order of preference.
+// The name with a hash suffix is used when another video already uses the
+// name, e.g. TV/Show A/S01E01.mkv and TV/Show-A/S01E01.mkv.
+func videoNames(pathSuffix string) []string {
+	name := videoName(pathSuffix)
+	hashed := jobs.HashedName(pathSuffix, maxVideoNameLength)
+	if hashed == name {
+		return []string{name}
 	}
-	err = os.Rename(claimPath, failedPath)
+	return []string{name, hashed}
+}
+
+// libraryName is the name of the Plex library for a video.
+// Assume that the library is the first segment of the path, e.g. /watch/LIBRARY/../video.mkv
+func libraryName(pathSuffix string) string {
+	return strings.Split(pathSuffix, string(os.PathSeparator))[0]
+}
+
+// isHidden checks if a file should be ignored by the watcher.
+func isHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+func (w *VideoWatcher) cleanupFailedClaim(pathSuffix string) {
+	err := w.Fail(pathSuffix)
 	if err != nil {
-		log.Println(errors.Wrap(err, "unable to cleanup failed claim"))
+		log.Println(err)
 	}
 }